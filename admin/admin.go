@@ -0,0 +1,238 @@
+//Package admin 运行时管理接口: 停用/恢复grpc接口和网关路由, 修改日志级别, 查看/重置断路器, 重新加载配置.
+//挂在监控端口上, 必须配置认证
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"local/sndaRpc/auth"
+	"local/sndaRpc/client"
+	"local/sndaRpc/gateway"
+	"local/sndaRpc/logHelper"
+	"local/sndaRpc/server"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	//PREFIX 管理接口的路径前缀
+	PREFIX = "/admin/"
+)
+
+//MethodSwitcher 可以停用接口的grpc服务, 如 *server.GRPCServer
+type MethodSwitcher interface {
+	Methods() []*server.MethodStatus
+	SetMethodEnabled(fullMethod string, enabled bool) error
+}
+
+//RouteSwitcher 可以停用路由的网关, 如 *gateway.HTTPGateWay
+type RouteSwitcher interface {
+	Routes() []*gateway.RouteStatus
+	SetRouteEnabled(name string, enabled bool) error
+}
+
+//BreakerManager 管理断路器的客户端, 如 *client.GRPCClient
+type BreakerManager interface {
+	Breakers() []*client.BreakerState
+	ResetBreaker(name string) error
+}
+
+//Admin 管理接口的http.Handler
+type Admin struct {
+	logger    log.Logger
+	authNames []string
+	role      string
+	server    MethodSwitcher
+	gateway   RouteSwitcher
+	client    BreakerManager
+	reload    func() error
+	mux       *http.ServeMux
+}
+
+//New 创建管理接口. authNames 对应<auth>的name, 需要先注册, 任意一个通过即可; role不为空时还要求调用方有该角色
+func New(authNames []string, role string) (*Admin, error) {
+	if len(authNames) == 0 {
+		return nil, fmt.Errorf("admin: auth required")
+	}
+	for _, name := range authNames {
+		if _, ok := auth.Get(name); !ok {
+			return nil, fmt.Errorf("admin: auth %s not registered", name)
+		}
+	}
+	me := &Admin{
+		logger:    log.NewLogfmtLogger(os.Stderr),
+		authNames: authNames,
+		role:      role,
+		mux:       http.NewServeMux(),
+	}
+	me.mux.HandleFunc(PREFIX+"methods", me.handleMethods)
+	me.mux.HandleFunc(PREFIX+"routes", me.handleRoutes)
+	me.mux.HandleFunc(PREFIX+"loggers", me.handleLoggers)
+	me.mux.HandleFunc(PREFIX+"breakers", me.handleBreakers)
+	me.mux.HandleFunc(PREFIX+"breakers/reset", me.handleResetBreaker)
+	me.mux.HandleFunc(PREFIX+"reload", me.handleReload)
+	return me, nil
+}
+
+//SetLogger 记录管理操作的logger
+func (me *Admin) SetLogger(lg log.Logger) error {
+	if nil == lg {
+		return fmt.Errorf("nil logger not allow")
+	}
+	me.logger = lg
+	return nil
+}
+
+//SetServer 设置管理的grpc服务
+func (me *Admin) SetServer(srv MethodSwitcher) {
+	me.server = srv
+}
+
+//SetGateway 设置管理的网关
+func (me *Admin) SetGateway(gw RouteSwitcher) {
+	me.gateway = gw
+}
+
+//SetClient 设置管理断路器的客户端
+func (me *Admin) SetClient(clt BreakerManager) {
+	me.client = clt
+}
+
+//SetReload 设置POST /admin/reload时调用的函数
+func (me *Admin) SetReload(reload func() error) {
+	me.reload = reload
+}
+
+//ServeHTTP 认证通过后分发到各个管理接口
+func (me *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.VerifyRequest(r, me.authNames, r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if len(me.role) > 0 && !principal.HasRole(me.role) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("role %s required", me.role))
+		return
+	}
+	if r.Method != "GET" {
+		//修改操作都记录下来
+		defer level.Warn(me.logger).Log("msg", "admin", "principal", principal.ID, "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+	}
+	me.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]interface{}{"message": err.Error()})
+}
+
+//GET 列表, POST name=&enabled= 修改
+func (me *Admin) handleMethods(w http.ResponseWriter, r *http.Request) {
+	if me.server == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("grpc server not managed"))
+		return
+	}
+	me.handleSwitch(w, r, func() interface{} { return me.server.Methods() }, me.server.SetMethodEnabled)
+}
+
+func (me *Admin) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	if me.gateway == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("gateway not managed"))
+		return
+	}
+	me.handleSwitch(w, r, func() interface{} { return me.gateway.Routes() }, me.gateway.SetRouteEnabled)
+}
+
+func (me *Admin) handleSwitch(w http.ResponseWriter, r *http.Request, list func() interface{}, set func(string, bool) error) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, list())
+	case "POST":
+		name := r.FormValue("name")
+		enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid enabled %s", r.FormValue("enabled")))
+			return
+		}
+		if err := set(name, enabled); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, list())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+//GET 全部日志级别, POST name=&level= 修改
+func (me *Admin) handleLoggers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, logHelper.Levels())
+	case "POST":
+		if err := logHelper.SetLevel(r.FormValue("name"), r.FormValue("level")); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, logHelper.Levels())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+//GET 断路器状态
+func (me *Admin) handleBreakers(w http.ResponseWriter, r *http.Request) {
+	if me.client == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("client not managed"))
+		return
+	}
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, me.client.Breakers())
+}
+
+//POST name= 重置一个接口的断路器, name为空时重置全部
+func (me *Admin) handleResetBreaker(w http.ResponseWriter, r *http.Request) {
+	if me.client == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("client not managed"))
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if err := me.client.ResetBreaker(r.FormValue("name")); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, me.client.Breakers())
+}
+
+//POST 重新加载<service>的策略配置, <auth>的定义和其他配置需要重启
+func (me *Admin) handleReload(w http.ResponseWriter, r *http.Request) {
+	if me.reload == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("reload not supported"))
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if err := me.reload(); err != nil {
+		level.Error(me.logger).Log("msg", "admin reload", "err", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"message": "reloaded"})
+}
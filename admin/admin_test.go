@@ -0,0 +1,204 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"local/sndaRpc/auth"
+	"local/sndaRpc/client"
+	"local/sndaRpc/logHelper"
+	"local/sndaRpc/pb/login"
+	"local/sndaRpc/server"
+	"local/sndaRpc/servertest"
+	"local/sndaRpc/util"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type loginService struct{}
+
+func (s *loginService) Login(ctx context.Context, in *login.LoginRequest) (*login.LoginReply, error) {
+	if in.GetUserName() == "fail" {
+		return nil, status.Error(codes.Internal, "failed")
+	}
+	return &login.LoginReply{SessionId: in.GetUserName()}, nil
+}
+
+func (s *loginService) Logout(ctx context.Context, in *login.LogoutRequest) (*login.LogoutReply, error) {
+	return &login.LogoutReply{}, nil
+}
+
+func init() {
+	auth.Register("admin-test", auth.NewAPIKeyVerifier("", []*util.AuthKey{
+		{ID: "ops", Roles: "admin", Value: "ops-key"},
+		{ID: "dev", Value: "dev-key"},
+	}))
+}
+
+func newAdmin(t *testing.T) (*Admin, *servertest.Env) {
+	env := servertest.New(t, &servertest.Config{
+		Services: []servertest.Service{{HandlerInterface: (*login.LoginServiceServer)(nil), HandlerCls: &loginService{}, ProtoName: "loginService.proto"}},
+		Gateway:  []*util.HTTPGateWayInfo{{Name: "/login", Method: "/login.loginService/login"}},
+	})
+	admin, err := New([]string{"admin-test"}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.SetLogger(log.NewNopLogger())
+	admin.SetServer(env.Server)
+	admin.SetGateway(env.Gateway)
+	admin.SetClient(env.Client)
+	return admin, env
+}
+
+func do(admin *Admin, method, path string, form url.Values, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	if form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if len(key) > 0 {
+		r.Header.Set("X-Api-Key", key)
+	}
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	admin, _ := newAdmin(t)
+	if w := do(admin, "GET", "/admin/methods", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expect 401, got %d", w.Code)
+	}
+	if w := do(admin, "GET", "/admin/methods", nil, "dev-key"); w.Code != http.StatusForbidden {
+		t.Errorf("expect 403, got %d", w.Code)
+	}
+	if _, err := New(nil, ""); err == nil {
+		t.Error("admin without auth should fail")
+	}
+	if _, err := New([]string{"admin-test", "admin-missing"}, ""); err == nil {
+		t.Error("admin with unregistered auth should fail")
+	}
+}
+
+func TestMethods(t *testing.T) {
+	admin, env := newAdmin(t)
+	const method = "/login.loginService/login"
+
+	w := do(admin, "POST", "/admin/methods", url.Values{"name": {method}, "enabled": {"false"}}, "ops-key")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected %d %s", w.Code, w.Body)
+	}
+	var list []*server.MethodStatus
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 2 || list[0].Name != method || list[0].Enabled || !list[1].Enabled {
+		t.Errorf("unexpected methods %s", w.Body)
+	}
+	_, err := env.Client.Invoke(context.Background(), method, &login.LoginRequest{UserName: "tommy"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expect Unavailable, got %v", err)
+	}
+
+	do(admin, "POST", "/admin/methods", url.Values{"name": {method}, "enabled": {"true"}}, "ops-key")
+	if _, err := env.Client.Invoke(context.Background(), method, &login.LoginRequest{UserName: "tommy"}); err != nil {
+		t.Error(err)
+	}
+	if w := do(admin, "POST", "/admin/methods", url.Values{"name": {"/x/y"}, "enabled": {"true"}}, "ops-key"); w.Code != http.StatusNotFound {
+		t.Errorf("expect 404, got %d", w.Code)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	admin, env := newAdmin(t)
+	do(admin, "POST", "/admin/routes", url.Values{"name": {"/login"}, "enabled": {"false"}}, "ops-key")
+	if w := env.Request("POST", "/login", strings.NewReader(`{"userName":"tommy"}`)); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expect 503, got %d", w.Code)
+	}
+	do(admin, "POST", "/admin/routes", url.Values{"name": {"/login"}, "enabled": {"true"}}, "ops-key")
+	if w := env.Request("POST", "/login", strings.NewReader(`{"userName":"tommy"}`)); w.Code != http.StatusOK {
+		t.Errorf("expect 200, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestLoggers(t *testing.T) {
+	admin, _ := newAdmin(t)
+	var buf bytes.Buffer
+	if _, err := logHelper.RegisterByWriter("admin_test", &buf, "error"); err != nil {
+		t.Fatal(err)
+	}
+	logHelper.Info("admin_test").Log("msg", "hidden")
+	w := do(admin, "POST", "/admin/loggers", url.Values{"name": {"admin_test"}, "level": {"info"}}, "ops-key")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `{"name":"admin_test","level":"info"}`) {
+		t.Errorf("unexpected %d %s", w.Code, w.Body)
+	}
+	logHelper.Info("admin_test").Log("msg", "shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "shown") {
+		t.Errorf("unexpected log output %q", out)
+	}
+	if w := do(admin, "POST", "/admin/loggers", url.Values{"name": {"admin_test"}, "level": {"verbose"}}, "ops-key"); w.Code != http.StatusBadRequest {
+		t.Errorf("expect 400, got %d", w.Code)
+	}
+}
+
+func TestBreakers(t *testing.T) {
+	admin, env := newAdmin(t)
+	const method = "/login.loginService/login"
+	//gobreaker默认连续失败超过5次后打开
+	for i := 0; i < 10; i++ {
+		env.Client.Invoke(context.Background(), method, &login.LoginRequest{UserName: "fail"})
+	}
+	state := func() string {
+		var list []*client.BreakerState
+		json.Unmarshal(do(admin, "GET", "/admin/breakers", nil, "ops-key").Body.Bytes(), &list)
+		for _, b := range list {
+			if b.Interface == method {
+				return b.State
+			}
+		}
+		return ""
+	}
+	if s := state(); s != "open" {
+		t.Fatalf("expect open, got %s", s)
+	}
+	w := do(admin, "POST", "/admin/breakers/reset", url.Values{"name": {method}}, "ops-key")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected %d %s", w.Code, w.Body)
+	}
+	if s := state(); s != "closed" {
+		t.Errorf("expect closed, got %s", s)
+	}
+	if _, err := env.Client.Invoke(context.Background(), method, &login.LoginRequest{UserName: "tommy"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	admin, _ := newAdmin(t)
+	if w := do(admin, "POST", "/admin/reload", nil, "ops-key"); w.Code != http.StatusNotFound {
+		t.Errorf("expect 404 without reload func, got %d", w.Code)
+	}
+	var calls int
+	admin.SetReload(func() error {
+		calls++
+		if calls > 1 {
+			return errors.New("bad config")
+		}
+		return nil
+	})
+	if w := do(admin, "GET", "/admin/reload", nil, "ops-key"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect 405, got %d", w.Code)
+	}
+	if w := do(admin, "POST", "/admin/reload", nil, "ops-key"); w.Code != http.StatusOK {
+		t.Errorf("expect 200, got %d", w.Code)
+	}
+	if w := do(admin, "POST", "/admin/reload", nil, "ops-key"); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "bad config") {
+		t.Errorf("expect 500, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"fmt"
+	"local/sndaRpc/util"
+	"net"
+	"strings"
+)
+
+//Rule 一条授权规则, 配置了的条件都满足才算匹配
+type Rule struct {
+	principals []string
+	roles      []string
+	nets       []*net.IPNet
+	desc       string
+}
+
+//NewRule 通过xml配置创建规则
+func NewRule(info *util.ACLRule) (*Rule, error) {
+	rule := &Rule{
+		principals: SplitNames(info.Principal),
+		roles:      SplitNames(info.Role),
+	}
+	for _, cidr := range SplitNames(info.CIDR) {
+		//单个ip按/32或/128处理
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %s", cidr)
+		}
+		rule.nets = append(rule.nets, ipNet)
+	}
+	if len(rule.principals) == 0 && len(rule.roles) == 0 && len(rule.nets) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	rule.desc = fmt.Sprintf("principal=%s role=%s cidr=%s", info.Principal, info.Role, info.CIDR)
+	return rule, nil
+}
+
+//Match 判断调用方是否匹配该规则. principal为nil表示未认证, ip为nil表示拿不到对端地址
+func (me *Rule) Match(principal *Principal, ip net.IP) bool {
+	if len(me.principals) > 0 {
+		if principal == nil {
+			return false
+		}
+		matched := false
+		for _, id := range me.principals {
+			if id == "*" || id == principal.ID {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(me.roles) > 0 {
+		if principal == nil {
+			return false
+		}
+		matched := false
+		for _, role := range me.roles {
+			if principal.HasRole(role) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(me.nets) > 0 {
+		if ip == nil {
+			return false
+		}
+		matched := false
+		for _, ipNet := range me.nets {
+			if ipNet.Contains(ip) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+//String 输出规则, 记录审计日志用
+func (me *Rule) String() string {
+	return me.desc
+}
+
+//Policy 一个接口的授权策略
+//先检查deny, 匹配任意一条即拒绝; allow不为空时必须匹配其中一条
+type Policy struct {
+	Allow []*Rule
+	Deny  []*Rule
+}
+
+//NewPolicy 合并service和method上的规则.
+//deny合并; method上有allow时只用method的allow, 否则用service的
+func NewPolicy(serverInfo *util.ServerInfo, methodInfo *util.MethodInfo) (*Policy, error) {
+	policy := new(Policy)
+	var allowList, denyList []*util.ACLRule
+	if serverInfo != nil {
+		allowList = serverInfo.AllowList
+		denyList = append(denyList, serverInfo.DenyList...)
+	}
+	if methodInfo != nil {
+		if len(methodInfo.AllowList) > 0 {
+			allowList = methodInfo.AllowList
+		}
+		denyList = append(denyList, methodInfo.DenyList...)
+	}
+	for _, info := range allowList {
+		rule, err := NewRule(info)
+		if err != nil {
+			return nil, err
+		}
+		policy.Allow = append(policy.Allow, rule)
+	}
+	for _, info := range denyList {
+		rule, err := NewRule(info)
+		if err != nil {
+			return nil, err
+		}
+		policy.Deny = append(policy.Deny, rule)
+	}
+	return policy, nil
+}
+
+//IsEmpty 没有任何规则
+func (me *Policy) IsEmpty() bool {
+	return len(me.Allow) == 0 && len(me.Deny) == 0
+}
+
+//Evaluate 判断是否允许访问, 拒绝时返回原因
+func (me *Policy) Evaluate(principal *Principal, ip net.IP) (bool, string) {
+	for _, rule := range me.Deny {
+		if rule.Match(principal, ip) {
+			return false, "deny " + rule.String()
+		}
+	}
+	if len(me.Allow) == 0 {
+		return true, ""
+	}
+	for _, rule := range me.Allow {
+		if rule.Match(principal, ip) {
+			return true, ""
+		}
+	}
+	return false, "no allow rule matched"
+}
+
+//IPFromAddr 从net.Addr中取出ip, unix socket等返回nil
+func IPFromAddr(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return net.ParseIP(addr.String())
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"local/sndaRpc/util"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// APIKEY_HEADER 默认的apikey metadata key
+	APIKEY_HEADER = "x-api-key"
+)
+
+//APIKeyVerifier 静态apikey认证, 适合内部服务之间调用
+type APIKeyVerifier struct {
+	header  string
+	query   string //网关中可以从该query参数读取apikey
+	keyList []*util.AuthKey
+}
+
+//NewAPIKeyVerifier 创建APIKeyVerifier
+//header: 携带apikey的metadata key, 为空时用x-api-key
+//keyList: 允许的key, id作为调用方标识
+func NewAPIKeyVerifier(header string, keyList []*util.AuthKey) *APIKeyVerifier {
+	if len(header) == 0 {
+		header = APIKEY_HEADER
+	}
+	return &APIKeyVerifier{header: strings.ToLower(header), keyList: keyList}
+}
+
+//SetQuery 在网关中header没有apikey时从query参数param读取, 为空时只读header
+func (me *APIKeyVerifier) SetQuery(param string) {
+	me.query = param
+}
+
+//Verify 校验apikey
+func (me *APIKeyVerifier) Verify(ctx context.Context, md metadata.MD, fullMethod string) (*Principal, error) {
+	apiKey := first(md, me.header)
+	if len(apiKey) == 0 {
+		return nil, ErrNoCredentials
+	}
+	for _, key := range me.keyList {
+		//逐个比较, 避免通过耗时猜测key
+		if subtle.ConstantTimeCompare([]byte(key.Value), []byte(apiKey)) == 1 {
+			return &Principal{ID: key.ID, Scheme: TYPE_APIKEY, Roles: SplitNames(key.Roles)}, nil
+		}
+	}
+	return nil, errors.New("invalid api key")
+}
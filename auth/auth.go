@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"local/sndaRpc/util"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	//认证方式
+	TYPE_APIKEY = "apikey"
+	TYPE_HMAC   = "hmac"
+	TYPE_JWT    = "jwt"
+)
+
+var (
+	verifierMu  sync.RWMutex
+	verifierMap map[string]Verifier
+
+	// ErrNoCredentials 请求中没有携带该认证方式需要的信息
+	ErrNoCredentials = errors.New("no credentials")
+)
+
+func init() {
+	verifierMap = make(map[string]Verifier)
+}
+
+//principalKey context中存放调用方身份用的key
+type principalKey struct {
+}
+
+//Principal 认证通过后的调用方身份
+type Principal struct {
+	//调用方标识. apikey/hmac为<key id="">, jwt为sub
+	ID string
+	//通过了哪种认证, 如 apikey
+	Scheme string
+	Roles  []string
+	//jwt的全部claims, 其他方式为nil
+	Claims map[string]interface{}
+}
+
+//HasRole 是否拥有某个角色
+func (me *Principal) HasRole(role string) bool {
+	for _, r := range me.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//FromContext 从context中获取调用方身份, 在handler中使用
+func FromContext(ctx context.Context) (principal *Principal, ok bool) {
+	principal, ok = ctx.Value(principalKey{}).(*Principal)
+	return
+}
+
+//ContextWithPrincipal 放入调用方身份
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+//Verifier 认证器
+//md: 客户端传过来的metadata
+//fullMethod: 调用的接口名, 如 /login.loginService/login
+type Verifier interface {
+	Verify(ctx context.Context, md metadata.MD, fullMethod string) (*Principal, error)
+}
+
+//Register 注册认证器, 在<service auth="name">中通过name引用
+func Register(name string, verifier Verifier) error {
+	verifierMu.Lock()
+	defer verifierMu.Unlock()
+	if _, ok := verifierMap[name]; ok {
+		return fmt.Errorf("verifier %s exist already", name)
+	}
+	verifierMap[name] = verifier
+	return nil
+}
+
+//RegisterByConfig 根据xml配置创建并注册认证器
+func RegisterByConfig(info *util.AuthInfo) error {
+	verifier, err := NewVerifier(info)
+	if err != nil {
+		return err
+	}
+	return Register(info.Name, verifier)
+}
+
+//Get 获取认证器
+func Get(name string) (Verifier, bool) {
+	verifierMu.RLock()
+	defer verifierMu.RUnlock()
+	verifier, ok := verifierMap[name]
+	return verifier, ok
+}
+
+//NewVerifier 根据xml配置创建认证器
+func NewVerifier(info *util.AuthInfo) (Verifier, error) {
+	switch strings.ToLower(info.Type) {
+	case TYPE_APIKEY:
+		verifier := NewAPIKeyVerifier(info.Header, info.KeyList)
+		verifier.SetQuery(info.Query)
+		return verifier, nil
+	case TYPE_HMAC, TYPE_SIGN:
+		window := 5 * time.Minute
+		if len(info.Window) > 0 {
+			d, err := time.ParseDuration(info.Window)
+			if err != nil {
+				return nil, fmt.Errorf("auth %s: invalid window %s", info.Name, info.Window)
+			}
+			window = d
+		}
+		if strings.ToLower(info.Type) == TYPE_HMAC {
+			return NewHMACVerifier(info.KeyList, window), nil
+		}
+		verifier, err := NewSignVerifier(info.KeyList, info.Alg, window)
+		if err != nil {
+			return nil, fmt.Errorf("auth %s: %s", info.Name, err)
+		}
+		return verifier, nil
+	case TYPE_JWT:
+		return NewJWTVerifierByConfig(info)
+	case TYPE_FORWARDED:
+		return &ForwardedVerifier{}, nil
+	default:
+		return nil, fmt.Errorf("auth %s: unknown type %s", info.Name, info.Type)
+	}
+}
+
+//Verify 依次尝试names中的认证器, 任意一个通过即返回
+func Verify(ctx context.Context, names []string, md metadata.MD, fullMethod string) (*Principal, error) {
+	var lastErr error = ErrNoCredentials
+	for _, name := range names {
+		verifier, ok := Get(name)
+		if !ok {
+			lastErr = fmt.Errorf("verifier %s not found", name)
+			continue
+		}
+		principal, err := verifier.Verify(ctx, md, fullMethod)
+		if err == nil {
+			return principal, nil
+		}
+		//没带凭证时保留更有意义的错误
+		if err != ErrNoCredentials || lastErr == ErrNoCredentials {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+//SplitNames 把"a, b"拆成[a b]
+func SplitNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+//取metadata中的第一个值
+func first(md metadata.MD, key string) string {
+	if list := md[strings.ToLower(key)]; len(list) > 0 {
+		return list[0]
+	}
+	return ""
+}
+
+func keyMap(keyList []*util.AuthKey) map[string]*util.AuthKey {
+	m := make(map[string]*util.AuthKey)
+	for _, key := range keyList {
+		m[key.ID] = key
+	}
+	return m
+}
@@ -0,0 +1,366 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"local/sndaRpc/util"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const testMethod = "/login.loginService/login"
+
+func TestAPIKey(t *testing.T) {
+	verifier := NewAPIKeyVerifier("", []*util.AuthKey{{ID: "gateway", Roles: "internal", Value: "k1"}})
+	principal, err := verifier.Verify(context.Background(), metadata.Pairs(APIKEY_HEADER, "k1"), testMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.ID != "gateway" || !principal.HasRole("internal") {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+	if _, err := verifier.Verify(context.Background(), metadata.Pairs(APIKEY_HEADER, "k2"), testMethod); err == nil {
+		t.Error("wrong key should be rejected")
+	}
+	if _, err := verifier.Verify(context.Background(), metadata.MD{}, testMethod); err != ErrNoCredentials {
+		t.Errorf("expect ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	verifier := NewHMACVerifier([]*util.AuthKey{{ID: "app1", Value: "secret"}}, time.Minute)
+	md := HMACSign("app1", "secret", testMethod)
+	principal, err := verifier.Verify(context.Background(), md, testMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.ID != "app1" {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+	if _, err := verifier.Verify(context.Background(), md, testMethod); err == nil {
+		t.Error("replayed nonce should be rejected")
+	}
+	if _, err := verifier.Verify(context.Background(), HMACSign("app1", "secret", testMethod), "/login.loginService/logout"); err == nil {
+		t.Error("signature of another method should be rejected")
+	}
+	if _, err := verifier.Verify(context.Background(), HMACSign("app1", "wrong", testMethod), testMethod); err == nil {
+		t.Error("wrong secret should be rejected")
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	md = metadata.Pairs(
+		HMAC_APP_ID, "app1",
+		HMAC_TIMESTAMP, old,
+		HMAC_NONCE, "n1",
+		HMAC_SIGNATURE, util.GetHmacSha256String("secret", HMACSignString("app1", testMethod, old, "n1")),
+	)
+	if _, err := verifier.Verify(context.Background(), md, testMethod); err == nil {
+		t.Error("expired timestamp should be rejected")
+	}
+
+	//未来的timestamp在timestamp+window之前都有效, nonce要记录到那时
+	future := time.Now().Add(50 * time.Second)
+	ts := strconv.FormatInt(future.Unix(), 10)
+	md = metadata.Pairs(
+		HMAC_APP_ID, "app1",
+		HMAC_TIMESTAMP, ts,
+		HMAC_NONCE, "n2",
+		HMAC_SIGNATURE, util.GetHmacSha256String("secret", HMACSignString("app1", testMethod, ts, "n2")),
+	)
+	if _, err := verifier.Verify(context.Background(), md, testMethod); err != nil {
+		t.Fatal(err)
+	}
+	if expire := verifier.nonces.nonces["app1:n2"]; expire.Before(time.Unix(future.Unix(), 0).Add(time.Minute)) {
+		t.Errorf("nonce should be kept until timestamp+window, got %v", expire)
+	}
+}
+
+func makeToken(t *testing.T, alg string, claims map[string]interface{}, sign func(string) []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+func TestJWTHS256(t *testing.T) {
+	verifier, err := NewJWTVerifierByConfig(&util.AuthInfo{Name: "token", Alg: "HS256", Secret: "secret", Issuer: "passport"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := func(secret string) func(string) []byte {
+		return func(s string) []byte {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(s))
+			return mac.Sum(nil)
+		}
+	}
+	claims := map[string]interface{}{
+		"sub":   "tommy",
+		"iss":   "passport",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"roles": []string{"admin"},
+	}
+	token := makeToken(t, "HS256", claims, hs("secret"))
+	principal, err := verifier.Verify(context.Background(), metadata.Pairs(AUTHORIZATION, "Bearer "+token), testMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.ID != "tommy" || !principal.HasRole("admin") {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+
+	if _, err := verifier.VerifyToken(makeToken(t, "HS256", claims, hs("wrong"))); err == nil {
+		t.Error("wrong signature should be rejected")
+	}
+	if _, err := verifier.VerifyToken(makeToken(t, "none", claims, func(string) []byte { return nil })); err == nil {
+		t.Error("alg none should be rejected")
+	}
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	if _, err := verifier.VerifyToken(makeToken(t, "HS256", claims, hs("secret"))); err == nil {
+		t.Error("expired token should be rejected")
+	}
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	claims["iss"] = "other"
+	if _, err := verifier.VerifyToken(makeToken(t, "HS256", claims, hs("secret"))); err == nil {
+		t.Error("wrong issuer should be rejected")
+	}
+}
+
+func TestJWTRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewJWTVerifier("RS256", &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := makeToken(t, "RS256", map[string]interface{}{"sub": "alice"}, func(s string) []byte {
+		h := sha256.Sum256([]byte(s))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	})
+	principal, err := verifier.VerifyToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.ID != "alice" {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+}
+
+func TestJWTMissingKey(t *testing.T) {
+	for _, info := range []*util.AuthInfo{
+		{Name: "token", Alg: "HS256"},
+		{Name: "token", Alg: "RS256"},
+	} {
+		if _, err := NewJWTVerifierByConfig(info); err == nil {
+			t.Errorf("%s without key should be rejected", info.Alg)
+		}
+	}
+	var key *rsa.PublicKey
+	if _, err := NewJWTVerifier("RS256", key); err == nil {
+		t.Error("nil public key should be rejected")
+	}
+}
+
+func TestVerifyByName(t *testing.T) {
+	if err := RegisterByConfig(&util.AuthInfo{Name: "test-inner", Type: TYPE_APIKEY, KeyList: []*util.AuthKey{{ID: "svc", Value: "k"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterByConfig(&util.AuthInfo{Name: "test-inner", Type: TYPE_APIKEY}); err == nil {
+		t.Error("duplicate name should fail")
+	}
+	principal, err := Verify(context.Background(), SplitNames("test-inner"), metadata.Pairs(APIKEY_HEADER, "k"), testMethod)
+	if err != nil || principal.ID != "svc" {
+		t.Errorf("unexpected result %+v %v", principal, err)
+	}
+	if _, err := Verify(context.Background(), []string{"missing"}, metadata.MD{}, testMethod); err == nil {
+		t.Error("unknown verifier should fail")
+	}
+	//前面的认证方式不存在时继续尝试后面的
+	if principal, err := Verify(context.Background(), []string{"missing", "test-inner"}, metadata.Pairs(APIKEY_HEADER, "k"), testMethod); err != nil || principal.ID != "svc" {
+		t.Errorf("unexpected result %+v %v", principal, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	serverInfo := &util.ServerInfo{
+		Name:      "/login.loginService",
+		AllowList: []*util.ACLRule{{Principal: "*"}},
+		DenyList:  []*util.ACLRule{{CIDR: "192.168.1.0/24"}},
+	}
+	methodInfo := &util.MethodInfo{
+		Name:      "logout",
+		AllowList: []*util.ACLRule{{Role: "admin", CIDR: "10.0.0.0/8,127.0.0.1"}},
+	}
+	admin := &Principal{ID: "tommy", Roles: []string{"admin"}}
+	user := &Principal{ID: "alice"}
+
+	policy, err := NewPolicy(serverInfo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := policy.Evaluate(user, net.ParseIP("10.1.1.1")); !ok {
+		t.Error("authenticated caller should be allowed by service rule")
+	}
+	if ok, _ := policy.Evaluate(nil, net.ParseIP("10.1.1.1")); ok {
+		t.Error("anonymous caller should be denied")
+	}
+	if ok, _ := policy.Evaluate(user, net.ParseIP("192.168.1.7")); ok {
+		t.Error("denied cidr should be rejected")
+	}
+
+	policy, err = NewPolicy(serverInfo, methodInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := policy.Evaluate(admin, net.ParseIP("127.0.0.1")); !ok {
+		t.Error("admin from loopback should be allowed")
+	}
+	if ok, _ := policy.Evaluate(user, net.ParseIP("127.0.0.1")); ok {
+		t.Error("method allow rule should replace service allow rule")
+	}
+	if ok, _ := policy.Evaluate(admin, net.ParseIP("172.16.0.1")); ok {
+		t.Error("admin from other network should be denied")
+	}
+
+	if _, err := NewPolicy(&util.ServerInfo{DenyList: []*util.ACLRule{{CIDR: "10.0.0.0/33"}}}, nil); err == nil {
+		t.Error("invalid cidr should fail")
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	for _, alg := range []string{SIGN_MD5, SIGN_SHA256} {
+		verifier, err := NewSignVerifier([]*util.AuthKey{{ID: "app1", Roles: "web", Value: "secret"}}, alg, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newRequest := func(body string) *http.Request {
+			r := httptest.NewRequest("POST", "/users/tommy?b=2&a=1&a=0", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			return r
+		}
+		r := newRequest(`{"password":"p"}`)
+		if err := SignRequest(r, "app1", "secret", alg); err != nil {
+			t.Fatal(err)
+		}
+		principal, err := verifier.VerifyRequest(r, testMethod)
+		if err != nil || principal.ID != "app1" || principal.Scheme != TYPE_SIGN || !principal.HasRole("web") {
+			t.Fatalf("%s: unexpected result %+v %v", alg, principal, err)
+		}
+		//body读取后放回
+		if b, _ := ioutil.ReadAll(r.Body); string(b) != `{"password":"p"}` {
+			t.Errorf("body lost: %s", b)
+		}
+		if _, err := verifier.VerifyRequest(r, testMethod); err == nil {
+			t.Error("replayed nonce should be rejected")
+		}
+
+		signed := newRequest(`{"password":"p"}`)
+		SignRequest(signed, "app1", "secret", alg)
+		for name, tamper := range map[string]func(r *http.Request){
+			"query":  func(r *http.Request) { r.URL.RawQuery = "a=1&b=3" },
+			"body":   func(r *http.Request) { r.Body = ioutil.NopCloser(strings.NewReader(`{"password":"x"}`)) },
+			"method": func(r *http.Request) { r.Method = "DELETE" },
+			"secret": func(r *http.Request) { SignRequest(r, "app1", "wrong", alg) },
+		} {
+			r := signed.Clone(context.Background())
+			r.Body = ioutil.NopCloser(strings.NewReader(`{"password":"p"}`))
+			tamper(r)
+			if _, err := verifier.VerifyRequest(r, testMethod); err == nil || err == ErrNoCredentials {
+				t.Errorf("%s: tampered %s should be rejected, got %v", alg, name, err)
+			}
+		}
+		if _, err := verifier.Verify(context.Background(), metadata.MD{}, testMethod); err != ErrNoCredentials {
+			t.Errorf("grpc metadata is not supported, got %v", err)
+		}
+	}
+	verifier, _ := NewSignVerifier([]*util.AuthKey{{ID: "app1", Value: "secret"}}, "", time.Minute)
+	//大的body写入临时文件, 不全部放在内存中
+	large := strings.Repeat("x", SIGN_BODY_MEMORY+10)
+	r := httptest.NewRequest("POST", "/upload", strings.NewReader(large))
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	if err := SignRequest(r, "app1", "secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	r.Body = ioutil.NopCloser(strings.NewReader(large))
+	if _, err := verifier.VerifyRequest(r, testMethod); err != nil {
+		t.Fatal(err)
+	}
+	spooled, ok := r.Body.(*spooledBody)
+	if !ok {
+		t.Fatalf("large body should be spooled to a temp file, got %T", r.Body)
+	}
+	name := spooled.file.Name()
+	if b, _ := ioutil.ReadAll(r.Body); string(b) != large {
+		t.Errorf("large body lost: %d bytes", len(b))
+	}
+	r.Body.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("temp file should be removed, got %v", err)
+	}
+	//未来的timestamp, nonce要记录到timestamp+window
+	r = httptest.NewRequest("GET", "/users", nil)
+	future := time.Now().Add(50 * time.Second)
+	ts := strconv.FormatInt(future.Unix(), 10)
+	signString, _ := RequestSignString(r, "app1", ts, "n1")
+	r.Header.Set(HMAC_APP_ID, "app1")
+	r.Header.Set(HMAC_TIMESTAMP, ts)
+	r.Header.Set(HMAC_NONCE, "n1")
+	r.Header.Set(HMAC_SIGNATURE, sign(SIGN_SHA256, "secret", signString))
+	if _, err := verifier.VerifyRequest(r, testMethod); err != nil {
+		t.Fatal(err)
+	}
+	if expire := verifier.nonces.nonces["app1:n1"]; expire.Before(time.Unix(future.Unix(), 0).Add(time.Minute)) {
+		t.Errorf("nonce should be kept until timestamp+window, got %v", expire)
+	}
+	if _, err := NewSignVerifier(nil, "sha1", time.Minute); err == nil {
+		t.Error("unsupported alg should fail")
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	RegisterByConfig(&util.AuthInfo{Name: "test-query", Type: TYPE_APIKEY, Query: "api_key", KeyList: []*util.AuthKey{{ID: "web", Value: "k"}}})
+	r := httptest.NewRequest("GET", "/users?api_key=k", nil)
+	principal, err := VerifyRequest(r, []string{"test-query"}, testMethod)
+	if err != nil || principal.ID != "web" {
+		t.Errorf("unexpected result %+v %v", principal, err)
+	}
+	r = httptest.NewRequest("GET", "/users", nil)
+	r.Header.Set(APIKEY_HEADER, "k")
+	if _, err := VerifyRequest(r, []string{"test-query"}, testMethod); err != nil {
+		t.Error(err)
+	}
+	if _, err := VerifyRequest(httptest.NewRequest("GET", "/users", nil), []string{"test-query"}, testMethod); err != ErrNoCredentials {
+		t.Errorf("expect ErrNoCredentials, got %v", err)
+	}
+
+	//网关转发的身份
+	md := PrincipalMD(&Principal{ID: "web", Scheme: TYPE_APIKEY, Roles: []string{"a", "b"}})
+	forwarded, err := (&ForwardedVerifier{}).Verify(context.Background(), md, testMethod)
+	if err != nil || forwarded.ID != "web" || forwarded.Scheme != TYPE_APIKEY || !forwarded.HasRole("b") {
+		t.Errorf("unexpected forwarded %+v %v", forwarded, err)
+	}
+}
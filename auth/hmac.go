@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"local/sndaRpc/util"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	//hmac签名需要的metadata
+	HMAC_APP_ID    = "x-app-id"
+	HMAC_TIMESTAMP = "x-timestamp"
+	HMAC_NONCE     = "x-nonce"
+	HMAC_SIGNATURE = "x-signature"
+)
+
+//HMACVerifier 请求签名认证.
+//签名串为 appID\nfullMethod\ntimestamp\nnonce, 用该app的密钥做hmac-sha256, 16进制输出.
+//timestamp为秒级unix时间, 与服务器偏差超过window拒绝; nonce在window内不允许重复.
+//nonce只记录在本进程内, 多个实例之间不共享
+type HMACVerifier struct {
+	keys   map[string]*util.AuthKey
+	window time.Duration
+	nonces *nonceCache
+}
+
+//NewHMACVerifier 创建HMACVerifier
+func NewHMACVerifier(keyList []*util.AuthKey, window time.Duration) *HMACVerifier {
+	return &HMACVerifier{
+		keys:   keyMap(keyList),
+		window: window,
+		nonces: newNonceCache(),
+	}
+}
+
+//HMACSignString 生成待签名串
+func HMACSignString(appID, fullMethod, timestamp, nonce string) string {
+	return appID + "\n" + fullMethod + "\n" + timestamp + "\n" + nonce
+}
+
+//HMACSign 客户端生成签名用的metadata
+func HMACSign(appID, secret, fullMethod string) metadata.MD {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := util.NewGuid()
+	return metadata.Pairs(
+		HMAC_APP_ID, appID,
+		HMAC_TIMESTAMP, timestamp,
+		HMAC_NONCE, nonce,
+		HMAC_SIGNATURE, util.GetHmacSha256String(secret, HMACSignString(appID, fullMethod, timestamp, nonce)),
+	)
+}
+
+//Verify 校验签名
+func (me *HMACVerifier) Verify(ctx context.Context, md metadata.MD, fullMethod string) (*Principal, error) {
+	appID := first(md, HMAC_APP_ID)
+	signature := first(md, HMAC_SIGNATURE)
+	if len(appID) == 0 || len(signature) == 0 {
+		return nil, ErrNoCredentials
+	}
+	key, ok := me.keys[appID]
+	if !ok {
+		return nil, fmt.Errorf("unknown app id %s", appID)
+	}
+	timestamp := first(md, HMAC_TIMESTAMP)
+	nonce := first(md, HMAC_NONCE)
+	if len(nonce) == 0 {
+		return nil, errors.New("nonce required")
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid timestamp")
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(sec, 0)); skew > me.window || skew < -me.window {
+		return nil, errors.New("timestamp expired")
+	}
+	expect := util.GetHmacSha256String(key.Value, HMACSignString(appID, fullMethod, timestamp, nonce))
+	if subtle.ConstantTimeCompare([]byte(expect), []byte(signature)) != 1 {
+		return nil, errors.New("invalid signature")
+	}
+	//签名正确后再记录nonce, 避免伪造请求把合法nonce占掉.
+	//timestamp可以比服务器快window, 按timestamp计算过期, 否则nonce过期后请求仍然有效
+	if !me.nonces.add(appID+":"+nonce, time.Unix(sec, 0).Add(me.window)) {
+		return nil, errors.New("nonce replayed")
+	}
+	return &Principal{ID: appID, Scheme: TYPE_HMAC, Roles: SplitNames(key.Roles)}, nil
+}
+
+//nonceCache 记录window内出现过的nonce
+type nonceCache struct {
+	mu      sync.Mutex
+	nonces  map[string]time.Time
+	lastGC  time.Time
+	gcEvery time.Duration
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{nonces: make(map[string]time.Time), gcEvery: time.Minute}
+}
+
+//add 返回false表示nonce在有效期内已经出现过
+func (me *nonceCache) add(nonce string, expire time.Time) bool {
+	now := time.Now()
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if now.Sub(me.lastGC) > me.gcEvery {
+		for k, t := range me.nonces {
+			if now.After(t) {
+				delete(me.nonces, k)
+			}
+		}
+		me.lastGC = now
+	}
+	if t, ok := me.nonces[nonce]; ok && now.Before(t) {
+		return false
+	}
+	me.nonces[nonce] = expire
+	return true
+}
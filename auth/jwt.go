@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"local/sndaRpc/util"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// AUTHORIZATION 携带jwt的metadata key, 值为 "Bearer <token>"
+	AUTHORIZATION = "authorization"
+)
+
+var (
+	jwtHashes = map[string]crypto.Hash{
+		"HS256": crypto.SHA256,
+		"HS384": crypto.SHA384,
+		"HS512": crypto.SHA512,
+		"RS256": crypto.SHA256,
+		"RS384": crypto.SHA384,
+		"RS512": crypto.SHA512,
+	}
+)
+
+//JWTVerifier jwt认证, 支持HS和RS系列算法
+type JWTVerifier struct {
+	alg       string
+	hash      crypto.Hash
+	secret    []byte
+	publicKey *rsa.PublicKey
+	issuer    string
+	audience  string
+	roleClaim string
+}
+
+//NewJWTVerifier 创建jwt认证器
+//alg: HS256/HS384/HS512时key为[]byte密钥, RS256/RS384/RS512时key为*rsa.PublicKey
+func NewJWTVerifier(alg string, key interface{}) (*JWTVerifier, error) {
+	alg = strings.ToUpper(alg)
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported jwt alg %s", alg)
+	}
+	verifier := &JWTVerifier{alg: alg, hash: hash, roleClaim: "roles"}
+	switch k := key.(type) {
+	case []byte:
+		if alg[:2] != "HS" {
+			return nil, fmt.Errorf("%s requires a rsa public key", alg)
+		}
+		//空密钥任何人都可以签发token
+		if len(k) == 0 {
+			return nil, fmt.Errorf("%s requires a non-empty secret", alg)
+		}
+		verifier.secret = k
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			return nil, fmt.Errorf("%s requires a secret", alg)
+		}
+		if k == nil {
+			return nil, fmt.Errorf("%s requires a rsa public key", alg)
+		}
+		verifier.publicKey = k
+	default:
+		return nil, fmt.Errorf("invalid key type %T", key)
+	}
+	return verifier, nil
+}
+
+//NewJWTVerifierByConfig 通过xml配置创建jwt认证器
+func NewJWTVerifierByConfig(info *util.AuthInfo) (*JWTVerifier, error) {
+	var key interface{} = []byte(info.Secret)
+	if strings.HasPrefix(strings.ToUpper(info.Alg), "RS") {
+		if len(info.PublicKey) == 0 {
+			return nil, fmt.Errorf("auth %s: public-key is required for %s", info.Name, info.Alg)
+		}
+		b, err := ioutil.ReadFile(info.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("auth %s: %s", info.Name, err)
+		}
+		if key, err = ParseRSAPublicKey(b); err != nil {
+			return nil, fmt.Errorf("auth %s: %s", info.Name, err)
+		}
+	}
+	verifier, err := NewJWTVerifier(info.Alg, key)
+	if err != nil {
+		return nil, fmt.Errorf("auth %s: %s", info.Name, err)
+	}
+	verifier.issuer = info.Issuer
+	verifier.audience = info.Audience
+	if len(info.RoleClaim) > 0 {
+		verifier.roleClaim = info.RoleClaim
+	}
+	return verifier, nil
+}
+
+//ParseRSAPublicKey 解析PEM格式的公钥(PKIX或PKCS1)
+func ParseRSAPublicKey(b []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+	if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+			return rsaPub, nil
+		}
+		return nil, errors.New("not a rsa public key")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+//Verify 从metadata的authorization中取出token并校验
+func (me *JWTVerifier) Verify(ctx context.Context, md metadata.MD, fullMethod string) (*Principal, error) {
+	token := first(md, AUTHORIZATION)
+	if len(token) < 7 || !strings.EqualFold(token[:7], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	return me.VerifyToken(strings.TrimSpace(token[7:]))
+}
+
+//VerifyToken 校验token签名和exp/nbf/iss/aud, 通过后返回调用方身份
+func (me *JWTVerifier) VerifyToken(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	//只接受配置的算法, 防止alg被篡改成none或HS
+	if header.Alg != me.alg {
+		return nil, fmt.Errorf("unexpected alg %s", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	if err := me.verifySignature(parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	if err := me.verifyClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	principal := &Principal{Scheme: TYPE_JWT, Claims: claims}
+	principal.ID, _ = claims["sub"].(string)
+	switch roles := claims[me.roleClaim].(type) {
+	case string:
+		principal.Roles = SplitNames(roles)
+	case []interface{}:
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, s)
+			}
+		}
+	}
+	return principal, nil
+}
+
+func (me *JWTVerifier) verifySignature(signed string, sig []byte) error {
+	h := me.hash.New()
+	if me.publicKey != nil {
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(me.publicKey, me.hash, h.Sum(nil), sig); err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	mac := hmac.New(me.hash.New, me.secret)
+	mac.Write([]byte(signed))
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return errors.New("invalid token signature")
+	}
+	return nil
+}
+
+func (me *JWTVerifier) verifyClaims(claims map[string]interface{}, now time.Time) error {
+	unix := float64(now.Unix())
+	if exp, ok := claims["exp"].(float64); ok && unix >= exp {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && unix < nbf {
+		return errors.New("token not valid yet")
+	}
+	if len(me.issuer) > 0 && claims["iss"] != me.issuer {
+		return errors.New("invalid token issuer")
+	}
+	if len(me.audience) > 0 {
+		matched := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			matched = aud == me.audience
+		case []interface{}:
+			for _, a := range aud {
+				if a == me.audience {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return errors.New("invalid token audience")
+		}
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"local/sndaRpc/util"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	TYPE_SIGN      = "sign"
+	TYPE_FORWARDED = "forwarded"
+
+	//签名算法
+	SIGN_MD5    = "md5"
+	SIGN_SHA256 = "sha256"
+
+	//网关把调用方身份转发给后端服务用的metadata
+	CALLER_ID     = "x-caller-id"
+	CALLER_SCHEME = "x-caller-scheme"
+	CALLER_ROLES  = "x-caller-roles"
+
+	//SIGN_BODY_MEMORY 计算body的md5时在内存中保留的最大字节数, 超过的写入临时文件, body关闭时删除
+	SIGN_BODY_MEMORY = 1024 * 1024
+)
+
+//RequestVerifier 可以直接校验http请求的认证器, 如需要读取query参数或body的认证方式. 在网关中优先使用
+type RequestVerifier interface {
+	VerifyRequest(r *http.Request, fullMethod string) (*Principal, error)
+}
+
+//HeaderMD http header转成metadata, 复用grpc的认证器
+func HeaderMD(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, v := range header {
+		md[strings.ToLower(k)] = v
+	}
+	return md
+}
+
+//VerifyRequest 与Verify相同, 用于http请求: 实现了RequestVerifier的认证器直接校验请求, 其他的校验header
+func VerifyRequest(r *http.Request, names []string, fullMethod string) (*Principal, error) {
+	var lastErr error = ErrNoCredentials
+	md := HeaderMD(r.Header)
+	for _, name := range names {
+		verifier, ok := Get(name)
+		if !ok {
+			return nil, fmt.Errorf("verifier %s not found", name)
+		}
+		var principal *Principal
+		var err error
+		if rv, ok := verifier.(RequestVerifier); ok {
+			principal, err = rv.VerifyRequest(r, fullMethod)
+		} else {
+			principal, err = verifier.Verify(r.Context(), md, fullMethod)
+		}
+		if err == nil {
+			return principal, nil
+		}
+		if err != ErrNoCredentials || lastErr == ErrNoCredentials {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+//VerifyRequest apikey可以放在header或者query参数中
+func (me *APIKeyVerifier) VerifyRequest(r *http.Request, fullMethod string) (*Principal, error) {
+	md := HeaderMD(r.Header)
+	if len(first(md, me.header)) == 0 && len(me.query) > 0 {
+		md[me.header] = []string{r.URL.Query().Get(me.query)}
+	}
+	return me.Verify(r.Context(), md, fullMethod)
+}
+
+//SignVerifier http请求签名认证, 只能在网关使用.
+//签名串为 appID\nMETHOD\npath\n排序后的参数\nbody的md5\ntimestamp\nnonce,
+//参数为query和form参数按key排序后url编码的k=v用&连接; body不是form时为body的md5, 否则为空.
+//sha256: 用该app的密钥做hmac-sha256; md5: md5(签名串 + "\n" + 密钥), 都是16进制输出.
+//appID, timestamp, nonce, signature放在x-app-id, x-timestamp, x-nonce, x-signature header中.
+//nonce只记录在本进程内, 多个网关实例之间不共享, 同一个请求在window内发到其他实例仍会通过,
+//需要防重放的接口应缩短window或配合幂等配置使用
+type SignVerifier struct {
+	keys   map[string]*util.AuthKey
+	alg    string
+	window time.Duration
+	nonces *nonceCache
+}
+
+//NewSignVerifier 创建SignVerifier, alg为md5或sha256, 为空时用sha256
+func NewSignVerifier(keyList []*util.AuthKey, alg string, window time.Duration) (*SignVerifier, error) {
+	alg = strings.ToLower(alg)
+	switch alg {
+	case "":
+		alg = SIGN_SHA256
+	case SIGN_MD5, SIGN_SHA256:
+	default:
+		return nil, fmt.Errorf("unsupported sign alg %s", alg)
+	}
+	return &SignVerifier{keys: keyMap(keyList), alg: alg, window: window, nonces: newNonceCache()}, nil
+}
+
+//Verify 签名需要http请求的内容, 只能通过VerifyRequest校验
+func (me *SignVerifier) Verify(ctx context.Context, md metadata.MD, fullMethod string) (*Principal, error) {
+	return nil, ErrNoCredentials
+}
+
+//VerifyRequest 校验http请求签名
+func (me *SignVerifier) VerifyRequest(r *http.Request, fullMethod string) (*Principal, error) {
+	appID := r.Header.Get(HMAC_APP_ID)
+	signature := r.Header.Get(HMAC_SIGNATURE)
+	if len(appID) == 0 || len(signature) == 0 {
+		return nil, ErrNoCredentials
+	}
+	key, ok := me.keys[appID]
+	if !ok {
+		return nil, fmt.Errorf("unknown app id %s", appID)
+	}
+	timestamp := r.Header.Get(HMAC_TIMESTAMP)
+	nonce := r.Header.Get(HMAC_NONCE)
+	if len(nonce) == 0 {
+		return nil, errors.New("nonce required")
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid timestamp")
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(sec, 0)); skew > me.window || skew < -me.window {
+		return nil, errors.New("timestamp expired")
+	}
+	signString, err := RequestSignString(r, appID, timestamp, nonce)
+	if err != nil {
+		return nil, err
+	}
+	expect := sign(me.alg, key.Value, signString)
+	if subtle.ConstantTimeCompare([]byte(expect), []byte(strings.ToLower(signature))) != 1 {
+		return nil, errors.New("invalid signature")
+	}
+	//与HMACVerifier相同, 按timestamp计算nonce的过期时间
+	if !me.nonces.add(appID+":"+nonce, time.Unix(sec, 0).Add(me.window)) {
+		return nil, errors.New("nonce replayed")
+	}
+	return &Principal{ID: appID, Scheme: TYPE_SIGN, Roles: SplitNames(key.Roles)}, nil
+}
+
+func sign(alg, secret, signString string) string {
+	if alg == SIGN_MD5 {
+		return util.GetMd5String(signString + "\n" + secret)
+	}
+	return util.GetHmacSha256String(secret, signString)
+}
+
+//RequestSignString 生成http请求的待签名串. 会读取body, 读取后放回, 不影响后续处理.
+//body超过SIGN_BODY_MEMORY时写入临时文件, 调用方处理完请求后需要关闭r.Body
+func RequestSignString(r *http.Request, appID, timestamp, nonce string) (string, error) {
+	params := url.Values{}
+	for k, v := range r.URL.Query() {
+		params[k] = v
+	}
+	bodyMD5 := ""
+	if r.Body != nil {
+		ct := r.Header.Get("Content-Type")
+		if strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+			if err := r.ParseForm(); err != nil {
+				return "", err
+			}
+			for k, v := range r.PostForm {
+				params[k] = append(params[k], v...)
+			}
+		} else {
+			var err error
+			if bodyMD5, err = hashBody(r); err != nil {
+				return "", err
+			}
+		}
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), params[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join([]string{appID, r.Method, r.URL.EscapedPath(), strings.Join(pairs, "&"), bodyMD5, timestamp, nonce}, "\n"), nil
+}
+
+//hashBody 边读边计算body的md5, 读取的内容放回r.Body, body为空时返回空串
+func hashBody(r *http.Request) (string, error) {
+	h := md5.New()
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.TeeReader(io.LimitReader(r.Body, SIGN_BODY_MEMORY+1), h))
+	if err != nil {
+		return "", err
+	}
+	var body io.ReadCloser = ioutil.NopCloser(&buf)
+	if n > SIGN_BODY_MEMORY {
+		f, err := ioutil.TempFile("", "sign-body-")
+		if err != nil {
+			return "", err
+		}
+		spooled := &spooledBody{Reader: f, file: f}
+		if _, err = buf.WriteTo(f); err == nil {
+			if _, err = io.Copy(f, io.TeeReader(r.Body, h)); err == nil {
+				_, err = f.Seek(0, io.SeekStart)
+			}
+		}
+		if err != nil {
+			spooled.Close()
+			return "", err
+		}
+		body = spooled
+	}
+	r.Body.Close()
+	r.Body = body
+	if n == 0 {
+		return "", nil
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//spooledBody 写入临时文件的body, 关闭时删除临时文件
+type spooledBody struct {
+	io.Reader
+	file *os.File
+}
+
+func (me *spooledBody) Close() error {
+	if me.file == nil {
+		return nil
+	}
+	f := me.file
+	me.file = nil
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+//SignRequest 客户端给http请求签名, 设置签名用的header
+func SignRequest(r *http.Request, appID, secret, alg string) error {
+	if len(alg) == 0 {
+		alg = SIGN_SHA256
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := util.NewGuid()
+	signString, err := RequestSignString(r, appID, timestamp, nonce)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(HMAC_APP_ID, appID)
+	r.Header.Set(HMAC_TIMESTAMP, timestamp)
+	r.Header.Set(HMAC_NONCE, nonce)
+	r.Header.Set(HMAC_SIGNATURE, sign(strings.ToLower(alg), secret, signString))
+	return nil
+}
+
+//PrincipalMD 网关转发调用方身份用的metadata
+func PrincipalMD(principal *Principal) metadata.MD {
+	return metadata.Pairs(
+		CALLER_ID, principal.ID,
+		CALLER_SCHEME, principal.Scheme,
+		CALLER_ROLES, strings.Join(principal.Roles, ","),
+	)
+}
+
+//ForwardedVerifier 信任网关转发的调用方身份. metadata可以被任意调用方设置,
+//只能用于只有网关能访问的服务, 或者和<allow cidr="">一起使用
+type ForwardedVerifier struct {
+}
+
+//Verify 读取网关转发的调用方身份, Scheme为网关上的认证方式
+func (me *ForwardedVerifier) Verify(ctx context.Context, md metadata.MD, fullMethod string) (*Principal, error) {
+	id := first(md, CALLER_ID)
+	if len(id) == 0 {
+		return nil, ErrNoCredentials
+	}
+	return &Principal{ID: id, Scheme: first(md, CALLER_SCHEME), Roles: SplitNames(first(md, CALLER_ROLES))}, nil
+}
@@ -1,8 +1,10 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"local/sndaRpc/trace"
 	"os"
 
 	"github.com/go-kit/kit/log"
@@ -66,6 +68,33 @@ func (me *RedisManager) Get(redisName string) (*redis.Client, error) {
 	return nil, fmt.Errorf("can not found client named %s", redisName)
 }
 
+//GetContext 获取绑定了ctx的client, 通过它执行的命令都会以ctx中的span为父span记录耗时
+func (me *RedisManager) GetContext(ctx context.Context, redisName string) (*redis.Client, error) {
+	client, err := me.Get(redisName)
+	if err != nil {
+		return nil, err
+	}
+	client = client.WithContext(ctx)
+	client.WrapProcess(traceProcess(ctx, redisName))
+	return client, nil
+}
+
+//traceProcess 为每个redis命令创建client span
+func traceProcess(ctx context.Context, redisName string) func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+	return func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			_, span := trace.StartSpan(ctx, "redis."+cmd.Name(), trace.KIND_CLIENT)
+			span.SetAttribute("redis", redisName)
+			err := oldProcess(cmd)
+			if err != redis.Nil {
+				span.SetError(err)
+			}
+			span.Finish()
+			return err
+		}
+	}
+}
+
 //SetLogger 设置logger
 func (me *RedisManager) SetLogger(logger log.Logger) error {
 	if logger == nil {
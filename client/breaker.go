@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/sony/gobreaker"
+)
+
+//breaker 可以重置的断路器. gobreaker没有重置的方法, 重置时换一个新的
+type breaker struct {
+	name     string //接口名
+	addr     string
+	settings gobreaker.Settings
+	mu       sync.RWMutex
+	cb       *gobreaker.CircuitBreaker
+}
+
+func newBreaker(name, addr string) *breaker {
+	me := &breaker{
+		name: name,
+		addr: addr,
+		settings: gobreaker.Settings{
+			Name:    name + "@" + addr,
+			Timeout: 30 * time.Second,
+		},
+	}
+	me.reset()
+	return me
+}
+
+func (me *breaker) reset() {
+	cb := gobreaker.NewCircuitBreaker(me.settings)
+	me.mu.Lock()
+	me.cb = cb
+	me.mu.Unlock()
+}
+
+func (me *breaker) current() *gobreaker.CircuitBreaker {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.cb
+}
+
+//middleware 同circuitbreaker.Gobreaker, 每次请求使用当前的断路器
+func (me *breaker) middleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return me.current().Execute(func() (interface{}, error) { return next(ctx, request) })
+	}
+}
+
+//BreakerState 断路器状态
+type BreakerState struct {
+	Interface string `json:"interface"`
+	Addr      string `json:"addr"`
+	State     string `json:"state"` //closed, half-open, open
+}
+
+//Breakers 返回全部接口每个连接的断路器状态, 按接口名和地址排序
+func (me *GRPCClient) Breakers() []*BreakerState {
+	me.breakerMu.RLock()
+	list := make([]*BreakerState, 0, len(me.breakers))
+	for _, b := range me.breakers {
+		list = append(list, &BreakerState{Interface: b.name, Addr: b.addr, State: b.current().State().String()})
+	}
+	me.breakerMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Interface != list[j].Interface {
+			return list[i].Interface < list[j].Interface
+		}
+		return list[i].Addr < list[j].Addr
+	})
+	return list
+}
+
+//ResetBreaker 把接口name的断路器恢复到closed, name为空时重置全部
+func (me *GRPCClient) ResetBreaker(name string) error {
+	me.breakerMu.RLock()
+	defer me.breakerMu.RUnlock()
+	var found bool
+	for _, b := range me.breakers {
+		if len(name) == 0 || b.name == name {
+			b.reset()
+			found = true
+		}
+	}
+	if !found && len(name) > 0 {
+		return fmt.Errorf("interface %s not found", name)
+	}
+	return nil
+}
@@ -5,15 +5,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"local/sndaRpc/auth"
 	"local/sndaRpc/constant"
+	"local/sndaRpc/idempotency"
 	"local/sndaRpc/logHelper"
+	"local/sndaRpc/trace"
 	"local/sndaRpc/util"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -24,7 +27,6 @@ import (
 	"github.com/go-stack/stack"
 	"github.com/golang/protobuf/proto"
 	jujuratelimit "github.com/juju/ratelimit"
-	"github.com/sony/gobreaker"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
@@ -41,6 +43,12 @@ type GRPCClient struct {
 	qps             int
 	maxAttempts     int           //每个请求重试次数(maxTime最多重试maxAttempts次)
 	maxTime         time.Duration //总重试时间
+	dialOpts        dialOptions   //默认连接参数
+	conns           []*grpc.ClientConn
+	breakerMu       sync.RWMutex
+	breakers        []*breaker
+	streamConns     map[string][]*grpc.ClientConn //<流式接口, 连接>
+	streamNext      uint32                        //流式接口轮流使用连接
 }
 
 // NewGRPCClient 创建新的 GRPCClient
@@ -49,6 +57,7 @@ func NewGRPCClient() *GRPCClient {
 		logger:          log.NewLogfmtLogger(os.Stderr),
 		clientEndpoints: make(map[string]endpoint.Endpoint),
 		clientInfo:      make(map[string]*util.InterfaceInfo),
+		streamConns:     make(map[string][]*grpc.ClientConn),
 		qps:             1000,
 		maxAttempts:     3,
 		maxTime:         3 * time.Second,
@@ -60,6 +69,12 @@ func init() {
 	defaultGRPCClient = NewGRPCClient()
 }
 
+//dial 连接远程服务, 支持 unix:///path/to.sock
+func dial(addr string, opts *dialOptions, callOptions map[string][]grpc.CallOption) (*grpc.ClientConn, error) {
+	network, address := util.ParseAddr(addr)
+	return grpc.Dial(address, opts.grpcOptions(network, address, callOptions)...)
+}
+
 // DefaultGRPCClient 返回默认的GRPCClient实例
 func DefaultGRPCClient() *GRPCClient {
 	return defaultGRPCClient
@@ -75,6 +90,20 @@ func (me *GRPCClient) SetLogger(lg log.Logger) error {
 	return nil
 }
 
+var _ Closer = (*GRPCClient)(nil)
+
+//Close 关闭全部连接, 关闭后不能再调用
+func (me *GRPCClient) Close() error {
+	var firstErr error
+	for _, conn := range me.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	me.conns = nil
+	return firstErr
+}
+
 //InterfaceInfo 通过接口名查询接口的相关配置信息
 func (me *GRPCClient) InterfaceInfo(name string) *util.InterfaceInfo {
 	return me.clientInfo[name]
@@ -89,22 +118,38 @@ func (me *GRPCClient) Register(clientInfo *util.ClientInfo) error {
 }
 
 func (me *GRPCClient) register(clientInfo *util.ClientInfo) error {
-	var connList []*grpc.ClientConn
+	opts, err := me.dialOpts.merge(clientInfo)
+	if err != nil {
+		return err
+	}
+	callOptions, err := opts.callOptions(clientInfo)
+	if err != nil {
+		return err
+	}
+	var (
+		connList []*grpc.ClientConn
+		addrList []string
+	)
 	for _, addr := range clientInfo.Addr {
-		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+		conn, err := dial(addr, opts, callOptions)
 		if err != nil {
 			level.Warn(me.logger).Log("msg", "connect error", "address", addr, "reason", err)
 			continue
 		}
 		connList = append(connList, conn)
+		addrList = append(addrList, addr)
 	}
 	if 0 == len(connList) {
 		return errors.New("all of the address is unavalibale")
 	}
+	me.conns = append(me.conns, connList...)
 	for _, interfaceInfo := range clientInfo.InterfaceList {
-		if _, ok := me.clientEndpoints[interfaceInfo.Name]; ok {
+		if _, ok := me.clientInfo[interfaceInfo.Name]; ok {
 			return fmt.Errorf("%s exist already", interfaceInfo.Name)
 		}
+		if err := checkStream(interfaceInfo.Stream); err != nil {
+			return fmt.Errorf("%s: %s", interfaceInfo.Name, err)
+		}
 		idx := strings.LastIndex(interfaceInfo.Name, "/")
 		if 2 > idx {
 			return fmt.Errorf("Invalid method name: %s ", interfaceInfo.Name)
@@ -118,13 +163,18 @@ func (me *GRPCClient) register(clientInfo *util.ClientInfo) error {
 			return fmt.Errorf("invalid responseType %s", interfaceInfo.RspType)
 		}
 		me.clientInfo[interfaceInfo.Name] = interfaceInfo
+		//流式接口通过NewStream直接使用连接
+		if len(interfaceInfo.Stream) > 0 {
+			me.streamConns[interfaceInfo.Name] = connList
+			continue
+		}
 		rspType = rspType.Elem()
 		out := reflect.New(rspType).Interface()
 		var endpoints sd.FixedEndpointer
 		options := []grpctransport.ClientOption{
-			grpctransport.ClientBefore(setFlowID()),
+			grpctransport.ClientBefore(setFlowID(), setTraceparent(), setIdempotencyKey(interfaceInfo.IdempotencyHeader), setPrincipal()),
 		}
-		for _, conn := range connList {
+		for i, conn := range connList {
 			ep := grpctransport.NewClient(
 				conn,
 				serviceName,
@@ -135,10 +185,11 @@ func (me *GRPCClient) register(clientInfo *util.ClientInfo) error {
 				options...,
 			).Endpoint()
 			//断路器放在限流器前面,免得断路器检测到限流器误判服务有问题
-			ep = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-				Name:    methodName,
-				Timeout: 30 * time.Second,
-			}))(ep)
+			cb := newBreaker(interfaceInfo.Name, addrList[i])
+			me.breakerMu.Lock()
+			me.breakers = append(me.breakers, cb)
+			me.breakerMu.Unlock()
+			ep = cb.middleware(ep)
 			//rate应该是 rate个令牌/ms
 			limiter := ratelimit.NewTokenBucketLimiter(jujuratelimit.NewBucketWithRate(float64(me.qps), int64(me.qps)))
 			ep = limiter(ep)
@@ -170,6 +221,8 @@ func (me *GRPCClient) invoke(ctx context.Context, method string, request interfa
 	if _, ok := me.clientEndpoints[method]; !ok {
 		return nil, fmt.Errorf("no matching method %s was found" + method)
 	}
+	ctx, span := trace.StartSpan(ctx, method, trace.KIND_CLIENT)
+	onceLogger = log.With(onceLogger, "traceID", span.TraceID, "spanID", span.SpanID)
 
 	b, err := json.Marshal(request)
 	if err == nil {
@@ -184,9 +237,15 @@ func (me *GRPCClient) invoke(ctx context.Context, method string, request interfa
 			onceLogger = log.With(onceLogger, "response", rspParams)
 		}
 		level.Info(onceLogger).Log("error", err, "took", time.Since(begin))
+		span.SetError(err)
+		span.Finish()
 	}(time.Now())
 
 	response, err = me.clientEndpoints[method](ctx, request)
+	//重试全部失败时返回最后一次的错误, 保留服务端返回的grpc status
+	if retryErr, ok := err.(lb.RetryError); ok && retryErr.Final != nil {
+		err = retryErr.Final
+	}
 	return
 }
 
@@ -221,3 +280,36 @@ func setFlowID() grpctransport.ClientRequestFunc {
 		return ctx
 	}
 }
+
+//setTraceparent 把当前span通过metadata传给服务端
+func setTraceparent() grpctransport.ClientRequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if traceparent := trace.Traceparent(ctx); len(traceparent) > 0 {
+			(*md)[trace.HEADER] = []string{traceparent}
+		}
+		return ctx
+	}
+}
+
+//setIdempotencyKey 把ctx中的幂等key通过metadata传给服务端, header为<interface idempotency-header="">
+func setIdempotencyKey(header string) grpctransport.ClientRequestFunc {
+	header = idempotency.HeaderName(header)
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if key := idempotency.KeyFromContext(ctx); len(key) > 0 {
+			(*md)[header] = []string{key}
+		}
+		return ctx
+	}
+}
+
+//setPrincipal 网关认证通过的调用方身份通过metadata传给服务端
+func setPrincipal() grpctransport.ClientRequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if principal, ok := auth.FromContext(ctx); ok {
+			for k, v := range auth.PrincipalMD(principal) {
+				(*md)[k] = v
+			}
+		}
+		return ctx
+	}
+}
@@ -0,0 +1,163 @@
+package clienttest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"local/sndaRpc/pb/login"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	loginMethod  = "/login.loginService/login"
+	logoutMethod = "/login.loginService/logout"
+)
+
+func TestMockClient(t *testing.T) {
+	mock := NewMockClient().
+		Return(loginMethod, &login.LoginReply{SessionId: "s1"}).
+		ReturnError(logoutMethod, status.Error(codes.Unavailable, "down"))
+
+	rsp, err := mock.Invoke(context.Background(), loginMethod, &login.LoginRequest{UserName: "tommy"})
+	if err != nil || rsp.(*login.LoginReply).GetSessionId() != "s1" {
+		t.Errorf("unexpected %v %v", rsp, err)
+	}
+	//修改返回值不影响之后的调用
+	rsp.(*login.LoginReply).SessionId = "changed"
+	rsp, _ = mock.Invoke(context.Background(), loginMethod, &login.LoginRequest{UserName: "alice"})
+	if rsp.(*login.LoginReply).GetSessionId() != "s1" {
+		t.Errorf("canned response was modified: %v", rsp)
+	}
+	if _, err := mock.Invoke(context.Background(), logoutMethod, &login.LogoutRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expect Unavailable, got %v", err)
+	}
+	if _, err := mock.Invoke(context.Background(), "/login.loginService/missing", &login.LoginRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expect Unimplemented, got %v", err)
+	}
+
+	mock.Handle(loginMethod, func(ctx context.Context, request interface{}) (interface{}, error) {
+		return &login.LoginReply{SessionId: request.(*login.LoginRequest).GetUserName()}, nil
+	})
+	rsp, _ = mock.InvokeTimeout(context.Background(), loginMethod, &login.LoginRequest{UserName: "bob"}, 0)
+	if rsp.(*login.LoginReply).GetSessionId() != "bob" {
+		t.Errorf("unexpected %v", rsp)
+	}
+
+	calls := mock.Calls(loginMethod)
+	if len(calls) != 3 || calls[1].Request.(*login.LoginRequest).GetUserName() != "alice" {
+		t.Errorf("unexpected calls %v", calls)
+	}
+	if len(mock.Calls("")) != 5 {
+		t.Errorf("expect 5 calls, got %d", len(mock.Calls("")))
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "login.jsonl")
+
+	var n int
+	downstream := NewMockClient().Handle(loginMethod, func(ctx context.Context, request interface{}) (interface{}, error) {
+		n++
+		if request.(*login.LoginRequest).GetUserName() == "" {
+			return nil, status.Error(codes.Unauthenticated, "empty user")
+		}
+		return &login.LoginReply{SessionId: request.(*login.LoginRequest).GetUserName() + string(rune('0'+n))}, nil
+	})
+	recorder, err := NewRecorder(downstream, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tommy", "tommy", "alice", ""} {
+		recorder.Invoke(context.Background(), loginMethod, &login.LoginRequest{UserName: name})
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name, session string
+	}{
+		{"alice", "alice3"},
+		{"tommy", "tommy1"},
+		{"tommy", "tommy2"},
+		//记录用完后重复最后一次
+		{"tommy", "tommy2"},
+	} {
+		rsp, err := replay.Invoke(context.Background(), loginMethod, &login.LoginRequest{UserName: c.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rsp.(*login.LoginReply).GetSessionId(); got != c.session {
+			t.Errorf("%s: expect %s, got %s", c.name, c.session, got)
+		}
+	}
+	if _, err := replay.Invoke(context.Background(), loginMethod, &login.LoginRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expect recorded Unauthenticated, got %v", err)
+	}
+	if _, err := replay.Invoke(context.Background(), loginMethod, &login.LoginRequest{UserName: "bob"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expect NotFound, got %v", err)
+	}
+	if n != 4 {
+		t.Errorf("replay should not call downstream, got %d calls", n)
+	}
+}
+
+func TestMockStream(t *testing.T) {
+	const chatMethod = "/login.loginService/chat"
+	mock := NewMockClient().HandleStream(chatMethod, func(ctx context.Context, stream ServerStream) error {
+		for {
+			request, err := stream.Recv()
+			if err == io.EOF {
+				return status.Error(codes.Aborted, "bye")
+			}
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&login.LoginReply{SessionId: request.(*login.LoginRequest).GetUserName()}); err != nil {
+				return err
+			}
+		}
+	})
+	stream, err := mock.NewStream(context.Background(), chatMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tommy", "alice"} {
+		stream.Send(&login.LoginRequest{UserName: name})
+		if rsp, err := stream.Recv(); err != nil || rsp.(*login.LoginReply).GetSessionId() != name {
+			t.Errorf("unexpected %v %v", rsp, err)
+		}
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); status.Code(err) != codes.Aborted {
+		t.Errorf("expect Aborted, got %v", err)
+	}
+	if calls := mock.Calls(chatMethod); len(calls) != 1 || status.Code(calls[0].Err) != codes.Aborted {
+		t.Errorf("unexpected calls %v", calls)
+	}
+
+	//取消时handler的ctx也取消
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, _ = mock.NewStream(ctx, chatMethod)
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("expect Canceled, got %v", err)
+	}
+	if _, err := mock.NewStream(context.Background(), "/login.loginService/missing"); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expect Unimplemented, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+//Package clienttest 测试用的client.Client实现, 不需要真实的下游服务.
+//MockClient 按接口名返回预设的结果; Recorder 包装真实的客户端把请求和响应写入文件, Replay 读取文件按请求回放
+package clienttest
+
+import (
+	"context"
+	"fmt"
+	"local/sndaRpc/client"
+	"local/sndaRpc/util"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Handler 处理一个接口的请求
+type Handler func(ctx context.Context, request interface{}) (interface{}, error)
+
+//Call 一次调用的记录, 用于断言
+type Call struct {
+	Method   string
+	Request  interface{}
+	Response interface{}
+	Err      error
+}
+
+//MockClient 按接口名返回预设的响应, 错误或者调用函数. 没有设置的接口返回Unimplemented
+type MockClient struct {
+	mu             sync.Mutex
+	handlers       map[string]Handler
+	streamHandlers map[string]StreamHandler
+	clientInfo     map[string]*util.InterfaceInfo
+	calls          []*Call
+}
+
+var (
+	_ client.Client       = (*MockClient)(nil)
+	_ client.Closer       = (*MockClient)(nil)
+	_ client.StreamClient = (*MockClient)(nil)
+)
+
+//NewMockClient 创建MockClient
+func NewMockClient() *MockClient {
+	return &MockClient{
+		handlers:       make(map[string]Handler),
+		streamHandlers: make(map[string]StreamHandler),
+		clientInfo:     make(map[string]*util.InterfaceInfo),
+	}
+}
+
+//Handle 接口method的请求交给fn处理, 如 /login.loginService/login
+func (me *MockClient) Handle(method string, fn Handler) *MockClient {
+	me.mu.Lock()
+	me.handlers[method] = fn
+	me.mu.Unlock()
+	return me
+}
+
+//Return 接口method总是返回response. 每次返回一份拷贝, 调用方修改结果不会影响之后的调用
+func (me *MockClient) Return(method string, response proto.Message) *MockClient {
+	return me.Handle(method, func(context.Context, interface{}) (interface{}, error) {
+		return proto.Clone(response), nil
+	})
+}
+
+//ReturnError 接口method总是返回err, 如 status.Error(codes.Unavailable, "")
+func (me *MockClient) ReturnError(method string, err error) *MockClient {
+	return me.Handle(method, func(context.Context, interface{}) (interface{}, error) {
+		return nil, err
+	})
+}
+
+//Calls 接口method的全部调用记录, method为空时返回全部接口的调用记录
+func (me *MockClient) Calls(method string) []*Call {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var list []*Call
+	for _, call := range me.calls {
+		if len(method) == 0 || call.Method == method {
+			list = append(list, call)
+		}
+	}
+	return list
+}
+
+//Reset 清空调用记录
+func (me *MockClient) Reset() {
+	me.mu.Lock()
+	me.calls = nil
+	me.mu.Unlock()
+}
+
+//SetLogger 没有日志, 只为实现client.Client
+func (me *MockClient) SetLogger(lg log.Logger) error {
+	if nil == lg {
+		return fmt.Errorf("nil logger not allow")
+	}
+	return nil
+}
+
+//SetOptions 没有连接, 忽略
+func (me *MockClient) SetOptions(opts ...client.Option) {}
+
+//Register 只保存接口信息, 网关通过InterfaceInfo查找请求类型
+func (me *MockClient) Register(clientInfo *util.ClientInfo) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, interfaceInfo := range clientInfo.InterfaceList {
+		if _, ok := me.clientInfo[interfaceInfo.Name]; ok {
+			return fmt.Errorf("%s exist already", interfaceInfo.Name)
+		}
+		me.clientInfo[interfaceInfo.Name] = interfaceInfo
+	}
+	return nil
+}
+
+//Invoke 调用method对应的Handler并记录
+func (me *MockClient) Invoke(ctx context.Context, method string, request interface{}) (interface{}, error) {
+	me.mu.Lock()
+	handler, ok := me.handlers[method]
+	me.mu.Unlock()
+	var (
+		response interface{}
+		err      error
+	)
+	if ok {
+		response, err = handler(ctx, request)
+	} else {
+		err = status.Errorf(codes.Unimplemented, "clienttest: no handler for %s", method)
+	}
+	me.mu.Lock()
+	me.calls = append(me.calls, &Call{Method: method, Request: request, Response: response, Err: err})
+	me.mu.Unlock()
+	return response, err
+}
+
+//InvokeTimeout 带超时调用, Handler可以通过ctx感知超时
+func (me *MockClient) InvokeTimeout(ctx context.Context, method string, request interface{}, duration time.Duration) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	return me.Invoke(ctx, method, request)
+}
+
+//InterfaceInfo 返回Register的接口信息
+func (me *MockClient) InterfaceInfo(name string) *util.InterfaceInfo {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.clientInfo[name]
+}
+
+//Close 没有连接, 忽略
+func (me *MockClient) Close() error {
+	return nil
+}
@@ -0,0 +1,212 @@
+package clienttest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"local/sndaRpc/client"
+	"local/sndaRpc/util"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Entry 记录文件中的一行
+type Entry struct {
+	Method       string          `json:"method"`
+	RequestType  string          `json:"requestType"`
+	Request      json.RawMessage `json:"request"`
+	ResponseType string          `json:"responseType,omitempty"`
+	Response     json.RawMessage `json:"response,omitempty"`
+	//调用失败时的grpc错误码和信息
+	Code    uint32 `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+var marshaler = jsonpb.Marshaler{}
+
+func marshalMessage(v interface{}) (string, json.RawMessage, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return "", nil, fmt.Errorf("clienttest: %T is not a proto.Message", v)
+	}
+	s, err := marshaler.MarshalToString(msg)
+	if err != nil {
+		return "", nil, err
+	}
+	return proto.MessageName(msg), json.RawMessage(s), nil
+}
+
+func unmarshalMessage(typeName string, data json.RawMessage) (proto.Message, error) {
+	tp := proto.MessageType(typeName)
+	if tp == nil {
+		return nil, fmt.Errorf("clienttest: unknown type %s", typeName)
+	}
+	msg := reflect.New(tp.Elem()).Interface().(proto.Message)
+	if err := jsonpb.Unmarshal(bytes.NewReader(data), msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+//Recorder 包装真实的客户端, 每次调用把请求和响应追加到JSON lines文件中, 用Replay回放
+type Recorder struct {
+	client client.Client
+	mu     sync.Mutex
+	file   *os.File
+}
+
+var _ client.Client = (*Recorder)(nil)
+
+//NewRecorder 记录clt的调用到path, 文件已存在时追加
+func NewRecorder(clt client.Client, path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{client: clt, file: file}, nil
+}
+
+func (me *Recorder) record(method string, request, response interface{}, err error) error {
+	entry := &Entry{Method: method}
+	var merr error
+	if entry.RequestType, entry.Request, merr = marshalMessage(request); merr != nil {
+		return merr
+	}
+	if err != nil {
+		st, ok := status.FromError(err)
+		if !ok {
+			st = status.New(codes.Unknown, err.Error())
+		}
+		entry.Code, entry.Message = uint32(st.Code()), st.Message()
+	} else if entry.ResponseType, entry.Response, merr = marshalMessage(response); merr != nil {
+		return merr
+	}
+	line, merr := json.Marshal(entry)
+	if merr != nil {
+		return merr
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	_, merr = me.file.Write(append(line, '\n'))
+	return merr
+}
+
+//Invoke 调用真实的客户端并记录, 记录失败时返回错误, 避免录制的文件不完整
+func (me *Recorder) Invoke(ctx context.Context, method string, request interface{}) (interface{}, error) {
+	response, err := me.client.Invoke(ctx, method, request)
+	if rerr := me.record(method, request, response, err); rerr != nil {
+		return nil, fmt.Errorf("clienttest: record %s: %s", method, rerr)
+	}
+	return response, err
+}
+
+//InvokeTimeout 带超时调用并记录
+func (me *Recorder) InvokeTimeout(ctx context.Context, method string, request interface{}, duration time.Duration) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	return me.Invoke(ctx, method, request)
+}
+
+func (me *Recorder) SetLogger(lg log.Logger) error {
+	return me.client.SetLogger(lg)
+}
+
+func (me *Recorder) SetOptions(opts ...client.Option) {
+	me.client.SetOptions(opts...)
+}
+
+func (me *Recorder) Register(clientInfo *util.ClientInfo) error {
+	return me.client.Register(clientInfo)
+}
+
+func (me *Recorder) InterfaceInfo(name string) *util.InterfaceInfo {
+	return me.client.InterfaceInfo(name)
+}
+
+//Close 关闭记录文件, 被包装的客户端由调用方关闭
+func (me *Recorder) Close() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.file.Close()
+}
+
+//Replay 读取Recorder记录的文件, 返回按接口名和请求内容回放的MockClient.
+//相同的请求记录了多次时按记录的顺序返回, 用完后一直返回最后一次的结果; 没有记录的请求返回NotFound
+func Replay(path string) (*MockClient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	//<接口名, <请求json, 记录列表>>
+	entries := make(map[string]map[string][]*Entry)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := new(Entry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, lineNo, err)
+		}
+		//用当前的jsonpb重新序列化, 保证和回放时的请求格式一致
+		request, err := unmarshalMessage(entry.RequestType, entry.Request)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: request: %s", path, lineNo, err)
+		}
+		_, key, _ := marshalMessage(request)
+		if entries[entry.Method] == nil {
+			entries[entry.Method] = make(map[string][]*Entry)
+		}
+		entries[entry.Method][string(key)] = append(entries[entry.Method][string(key)], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	mock := NewMockClient()
+	for method, byRequest := range entries {
+		mock.Handle(method, replayHandler(method, byRequest))
+	}
+	return mock, nil
+}
+
+func replayHandler(method string, byRequest map[string][]*Entry) Handler {
+	var mu sync.Mutex
+	next := make(map[string]int)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_, key, err := marshalMessage(request)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		list, ok := byRequest[string(key)]
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "clienttest: no recorded response for %s %s", method, key)
+		}
+		mu.Lock()
+		idx := next[string(key)]
+		if idx < len(list)-1 {
+			next[string(key)] = idx + 1
+		}
+		mu.Unlock()
+		entry := list[idx]
+		if entry.Code != uint32(codes.OK) {
+			return nil, status.Error(codes.Code(entry.Code), entry.Message)
+		}
+		response, err := unmarshalMessage(entry.ResponseType, entry.Response)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "clienttest: %s", err)
+		}
+		return response, nil
+	}
+}
@@ -0,0 +1,120 @@
+package clienttest
+
+import (
+	"context"
+	"io"
+	"local/sndaRpc/client"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//ServerStream 服务端看到的流式调用
+type ServerStream interface {
+	//Recv 客户端CloseSend后返回io.EOF
+	Recv() (request interface{}, err error)
+	Send(response interface{}) error
+}
+
+//StreamHandler 处理一次流式调用, 返回后流结束, 返回的错误由客户端的Recv收到
+type StreamHandler func(ctx context.Context, stream ServerStream) error
+
+//HandleStream 流式接口method交给fn处理
+func (me *MockClient) HandleStream(method string, fn StreamHandler) *MockClient {
+	me.mu.Lock()
+	me.streamHandlers[method] = fn
+	me.mu.Unlock()
+	return me
+}
+
+//NewStream 在新的goroutine中调用method对应的StreamHandler, 结束后记录. ctx取消时handler的ctx也取消
+func (me *MockClient) NewStream(ctx context.Context, method string) (client.Stream, error) {
+	me.mu.Lock()
+	handler, ok := me.streamHandlers[method]
+	me.mu.Unlock()
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "clienttest: no stream handler for %s", method)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream := &mockStream{
+		ctx:       ctx,
+		requests:  make(chan interface{}),
+		responses: make(chan interface{}),
+		finished:  make(chan struct{}),
+	}
+	go func() {
+		defer cancel()
+		err := handler(ctx, (*mockServerStream)(stream))
+		me.mu.Lock()
+		me.calls = append(me.calls, &Call{Method: method, Err: err})
+		me.mu.Unlock()
+		stream.err = err
+		close(stream.finished)
+	}()
+	return stream, nil
+}
+
+//mockStream 用channel连接客户端和StreamHandler, 都不带缓冲, handler返回时全部响应都已经被接收
+type mockStream struct {
+	ctx       context.Context
+	requests  chan interface{}
+	responses chan interface{}
+	finished  chan struct{}
+	err       error
+	closeOnce sync.Once
+}
+
+func (me *mockStream) Send(request interface{}) error {
+	select {
+	case me.requests <- request:
+		return nil
+	case <-me.finished:
+		return io.EOF
+	case <-me.ctx.Done():
+		return status.FromContextError(me.ctx.Err()).Err()
+	}
+}
+
+func (me *mockStream) CloseSend() error {
+	me.closeOnce.Do(func() {
+		close(me.requests)
+	})
+	return nil
+}
+
+func (me *mockStream) Recv() (interface{}, error) {
+	select {
+	case response := <-me.responses:
+		return response, nil
+	case <-me.finished:
+		if me.err != nil {
+			return nil, me.err
+		}
+		return nil, io.EOF
+	}
+}
+
+//mockServerStream handler一侧
+type mockServerStream mockStream
+
+func (me *mockServerStream) Recv() (interface{}, error) {
+	select {
+	case request, ok := <-me.requests:
+		if !ok {
+			return nil, io.EOF
+		}
+		return request, nil
+	case <-me.ctx.Done():
+		return nil, status.FromContextError(me.ctx.Err()).Err()
+	}
+}
+
+func (me *mockServerStream) Send(response interface{}) error {
+	select {
+	case me.responses <- response:
+		return nil
+	case <-me.ctx.Done():
+		return status.FromContextError(me.ctx.Err()).Err()
+	}
+}
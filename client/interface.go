@@ -11,8 +11,27 @@ import (
 // Client 客户端接口
 type Client interface {
 	SetLogger(lg log.Logger) error
+	SetOptions(opts ...Option)
 	Register(clientInfo *util.ClientInfo) error
 	Invoke(ctx context.Context, method string, request interface{}) (response interface{}, err error)
 	InvokeTimeout(ctx context.Context, method string, request interface{}, duration time.Duration) (response interface{}, err error)
 	InterfaceInfo(name string) *util.InterfaceInfo
 }
+
+// Closer 可以关闭连接的客户端, 通过类型断言使用
+type Closer interface {
+	Close() error
+}
+
+// StreamClient 支持流式接口的客户端, 接口需要在<interface>上配置stream
+type StreamClient interface {
+	NewStream(ctx context.Context, method string) (Stream, error)
+}
+
+// Stream 一次流式调用. 服务端流先Send一次入参再CloseSend, 然后Recv到io.EOF
+type Stream interface {
+	Send(request interface{}) error
+	CloseSend() error
+	//Recv 流正常结束时返回io.EOF
+	Recv() (response interface{}, err error)
+}
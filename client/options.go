@@ -0,0 +1,248 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"local/sndaRpc/codec"
+	"local/sndaRpc/util"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip" //注册gzip, 供grpc.UseCompressor使用
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	//COMPRESSION_GZIP 目前支持的压缩方式
+	COMPRESSION_GZIP = "gzip"
+	//COMPRESSION_NONE 不压缩, 用于<interface>上覆盖<client>的配置
+	COMPRESSION_NONE = "none"
+	//CODEC_PROTO 默认的protobuf编码, 用于<interface>上覆盖<client>的配置
+	CODEC_PROTO = "proto"
+)
+
+//Option 建立连接时的默认参数, <client>上的配置优先
+type Option func(*dialOptions)
+
+type dialOptions struct {
+	keepaliveTime       time.Duration
+	keepaliveTimeout    time.Duration
+	permitWithoutStream bool
+	maxRecvMsgSize      int
+	maxSendMsgSize      int
+	connectTimeout      time.Duration
+	compression         string
+	codec               string
+	dialer              func(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+//Keepalive 连接空闲interval后发送ping, timeout内没有收到ack时断开连接.
+//permitWithoutStream 没有进行中的请求时是否也发送, 需要服务端允许
+func Keepalive(interval, timeout time.Duration, permitWithoutStream bool) Option {
+	return func(opts *dialOptions) {
+		opts.keepaliveTime = interval
+		opts.keepaliveTimeout = timeout
+		opts.permitWithoutStream = permitWithoutStream
+	}
+}
+
+//MaxRecvMsgSize 最大接收消息大小(字节), grpc默认4MB
+func MaxRecvMsgSize(n int) Option {
+	return func(opts *dialOptions) {
+		opts.maxRecvMsgSize = n
+	}
+}
+
+//MaxSendMsgSize 最大发送消息大小(字节)
+func MaxSendMsgSize(n int) Option {
+	return func(opts *dialOptions) {
+		opts.maxSendMsgSize = n
+	}
+}
+
+//ConnectTimeout 建立连接的超时
+func ConnectTimeout(d time.Duration) Option {
+	return func(opts *dialOptions) {
+		opts.connectTimeout = d
+	}
+}
+
+//Compression 请求的压缩方式, 目前只支持gzip
+func Compression(name string) Option {
+	return func(opts *dialOptions) {
+		opts.compression = name
+	}
+}
+
+//Codec 请求的编码方式, proto(默认)或json. json时content-type为application/grpc+json
+func Codec(name string) Option {
+	return func(opts *dialOptions) {
+		opts.codec = name
+	}
+}
+
+//Dialer 自定义建立连接的方式, 如测试时连接内存中的服务. addr为<client>中配置的地址
+func Dialer(dialer func(addr string, timeout time.Duration) (net.Conn, error)) Option {
+	return func(opts *dialOptions) {
+		opts.dialer = dialer
+	}
+}
+
+//SetOptions 设置之后Register的<client>的默认连接参数
+func (me *GRPCClient) SetOptions(opts ...Option) {
+	for _, opt := range opts {
+		opt(&me.dialOpts)
+	}
+}
+
+//merge 用<client>上的配置覆盖默认参数, 错误信息中带上出错的属性名
+func (me dialOptions) merge(info *util.ClientInfo) (*dialOptions, error) {
+	var err error
+	if len(info.KeepaliveTime) > 0 {
+		if me.keepaliveTime, err = time.ParseDuration(info.KeepaliveTime); err != nil {
+			return nil, fmt.Errorf("client %s: invalid keepalive-time %s", info.Name, info.KeepaliveTime)
+		}
+	}
+	if len(info.KeepaliveTimeout) > 0 {
+		if me.keepaliveTimeout, err = time.ParseDuration(info.KeepaliveTimeout); err != nil {
+			return nil, fmt.Errorf("client %s: invalid keepalive-timeout %s", info.Name, info.KeepaliveTimeout)
+		}
+	}
+	if info.PermitWithoutStream {
+		me.permitWithoutStream = true
+	}
+	if len(info.MaxRecvMsgSize) > 0 {
+		if me.maxRecvMsgSize, err = util.ParseSize(info.MaxRecvMsgSize); err != nil {
+			return nil, fmt.Errorf("client %s: invalid max-recv-msg-size %s", info.Name, info.MaxRecvMsgSize)
+		}
+	}
+	if len(info.MaxSendMsgSize) > 0 {
+		if me.maxSendMsgSize, err = util.ParseSize(info.MaxSendMsgSize); err != nil {
+			return nil, fmt.Errorf("client %s: invalid max-send-msg-size %s", info.Name, info.MaxSendMsgSize)
+		}
+	}
+	if len(info.ConnectTimeout) > 0 {
+		if me.connectTimeout, err = time.ParseDuration(info.ConnectTimeout); err != nil {
+			return nil, fmt.Errorf("client %s: invalid connect-timeout %s", info.Name, info.ConnectTimeout)
+		}
+	}
+	if len(info.Compression) > 0 {
+		me.compression = info.Compression
+	}
+	if err := checkCompression(me.compression); err != nil {
+		return nil, fmt.Errorf("client %s: invalid compression %s", info.Name, me.compression)
+	}
+	if len(info.Codec) > 0 {
+		me.codec = info.Codec
+	}
+	if err := checkCodec(me.codec); err != nil {
+		return nil, fmt.Errorf("client %s: invalid codec %s", info.Name, me.codec)
+	}
+	return &me, nil
+}
+
+func checkCodec(name string) error {
+	switch name {
+	case "", CODEC_PROTO, codec.JSON:
+		return nil
+	}
+	return fmt.Errorf("unsupported codec %s", name)
+}
+
+func checkCompression(name string) error {
+	switch name {
+	case "", COMPRESSION_NONE, COMPRESSION_GZIP:
+		return nil
+	}
+	return fmt.Errorf("unsupported compression %s", name)
+}
+
+//callOptions 每个接口的压缩方式和编码方式, <interface>上的配置优先
+func (me *dialOptions) callOptions(info *util.ClientInfo) (map[string][]grpc.CallOption, error) {
+	callOptions := make(map[string][]grpc.CallOption)
+	for _, interfaceInfo := range info.InterfaceList {
+		var list []grpc.CallOption
+		compression := me.compression
+		if len(interfaceInfo.Compression) > 0 {
+			if err := checkCompression(interfaceInfo.Compression); err != nil {
+				return nil, fmt.Errorf("interface %s: invalid compression %s", interfaceInfo.Name, interfaceInfo.Compression)
+			}
+			compression = interfaceInfo.Compression
+		}
+		if compression == COMPRESSION_GZIP {
+			list = append(list, grpc.UseCompressor(compression))
+		}
+		codecName := me.codec
+		if len(interfaceInfo.Codec) > 0 {
+			if err := checkCodec(interfaceInfo.Codec); err != nil {
+				return nil, fmt.Errorf("interface %s: invalid codec %s", interfaceInfo.Name, interfaceInfo.Codec)
+			}
+			codecName = interfaceInfo.Codec
+		}
+		if codecName == codec.JSON {
+			list = append(list, grpc.CallContentSubtype(codecName))
+		}
+		if len(list) > 0 {
+			callOptions[interfaceInfo.Name] = list
+		}
+	}
+	return callOptions, nil
+}
+
+//grpcOptions 转成grpc.Dial的参数
+func (me *dialOptions) grpcOptions(network, address string, callOptions map[string][]grpc.CallOption) []grpc.DialOption {
+	connectTimeout, customDialer := me.connectTimeout, me.dialer
+	dialer := func(_ string, timeout time.Duration) (net.Conn, error) {
+		if connectTimeout > 0 && (timeout <= 0 || connectTimeout < timeout) {
+			timeout = connectTimeout
+		}
+		if customDialer != nil {
+			return customDialer(address, timeout)
+		}
+		return net.DialTimeout(network, address, timeout)
+	}
+	list := []grpc.DialOption{grpc.WithInsecure(), grpc.WithDialer(dialer)}
+	if me.keepaliveTime > 0 || me.keepaliveTimeout > 0 {
+		list = append(list, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                me.keepaliveTime,
+			Timeout:             me.keepaliveTimeout,
+			PermitWithoutStream: me.permitWithoutStream,
+		}))
+	}
+	var defaultCallOptions []grpc.CallOption
+	if me.maxRecvMsgSize > 0 {
+		defaultCallOptions = append(defaultCallOptions, grpc.MaxCallRecvMsgSize(me.maxRecvMsgSize))
+	}
+	if me.maxSendMsgSize > 0 {
+		defaultCallOptions = append(defaultCallOptions, grpc.MaxCallSendMsgSize(me.maxSendMsgSize))
+	}
+	if len(defaultCallOptions) > 0 {
+		list = append(list, grpc.WithDefaultCallOptions(defaultCallOptions...))
+	}
+	if len(callOptions) > 0 {
+		list = append(list, grpc.WithUnaryInterceptor(callOptionsInterceptor(callOptions)))
+		list = append(list, grpc.WithStreamInterceptor(streamCallOptionsInterceptor(callOptions)))
+	}
+	return list
+}
+
+//callOptionsInterceptor 按接口设置压缩和编码方式. 同一个连接上的接口可以有不同的配置, 所以不能用WithDefaultCallOptions
+func callOptionsInterceptor(callOptions map[string][]grpc.CallOption) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if list, ok := callOptions[method]; ok {
+			opts = append(opts, list...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+//streamCallOptionsInterceptor 流式接口的压缩和编码方式
+func streamCallOptionsInterceptor(callOptions map[string][]grpc.CallOption) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if list, ok := callOptions[method]; ok {
+			opts = append(opts, list...)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
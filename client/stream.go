@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"local/sndaRpc/trace"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-kit/kit/log/level"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//流式接口的类型, 对应<interface>的stream
+	STREAM_SERVER = "server"
+	STREAM_CLIENT = "client"
+	STREAM_BIDI   = "bidi"
+)
+
+var _ StreamClient = (*GRPCClient)(nil)
+
+//checkStream 检查<interface>的stream配置
+func checkStream(stream string) error {
+	switch stream {
+	case "", STREAM_SERVER, STREAM_CLIENT, STREAM_BIDI:
+		return nil
+	}
+	return fmt.Errorf("invalid stream %s", stream)
+}
+
+//NewStream 调用流式接口, ctx取消时结束调用. 多个地址时轮流使用, 不重试
+func (me *GRPCClient) NewStream(ctx context.Context, method string) (Stream, error) {
+	info := me.clientInfo[method]
+	conns := me.streamConns[method]
+	if info == nil || len(conns) == 0 {
+		return nil, status.Errorf(codes.Unimplemented, "no matching stream method %s was found", method)
+	}
+	rspType := proto.MessageType(info.RspType)
+	if rspType == nil {
+		return nil, status.Errorf(codes.Internal, "invalid responseType %s", info.RspType)
+	}
+	ctx, span := trace.StartSpan(ctx, method, trace.KIND_CLIENT)
+	//与普通接口相同的metadata
+	md := metadata.MD{}
+	for _, before := range []grpctransport.ClientRequestFunc{setFlowID(), setTraceparent(), setIdempotencyKey(info.IdempotencyHeader), setPrincipal()} {
+		ctx = before(ctx, &md)
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	desc := &grpc.StreamDesc{
+		StreamName:    method[strings.LastIndex(method, "/")+1:],
+		ServerStreams: info.Stream != STREAM_CLIENT,
+		ClientStreams: info.Stream != STREAM_SERVER,
+	}
+	conn := conns[atomic.AddUint32(&me.streamNext, 1)%uint32(len(conns))]
+	cs, err := conn.NewStream(ctx, desc, method)
+	if err != nil {
+		level.Error(me.logger).Log("method", method, "traceID", span.TraceID, "error", err)
+		span.SetError(err)
+		span.Finish()
+		return nil, err
+	}
+	return &grpcStream{ClientStream: cs, rspType: rspType.Elem(), span: span}, nil
+}
+
+//grpcStream 按接口的出参类型接收消息, 流结束时结束span
+type grpcStream struct {
+	grpc.ClientStream
+	rspType reflect.Type
+	span    *trace.Span
+	once    sync.Once
+}
+
+func (me *grpcStream) Send(request interface{}) error {
+	return me.SendMsg(request)
+}
+
+func (me *grpcStream) Recv() (interface{}, error) {
+	response := reflect.New(me.rspType).Interface()
+	if err := me.RecvMsg(response); err != nil {
+		me.once.Do(func() {
+			if err != io.EOF {
+				me.span.SetError(err)
+			}
+			me.span.Finish()
+		})
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,64 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	//JSON codec名称, 请求的content-type为 application/grpc+json
+	JSON = "json"
+)
+
+func init() {
+	encoding.RegisterCodec(NewJSONCodec())
+}
+
+//JSONCodec 按proto3的json映射编解码, 方便非go语言和调试工具直接调用
+type JSONCodec struct {
+	marshaler   jsonpb.Marshaler
+	unmarshaler jsonpb.Unmarshaler
+}
+
+//NewJSONCodec 创建JSONCodec. 解码时忽略不认识的字段, 与protobuf的兼容规则一致
+func NewJSONCodec() *JSONCodec {
+	return &JSONCodec{
+		unmarshaler: jsonpb.Unmarshaler{AllowUnknownFields: true},
+	}
+}
+
+//Marshal 编码proto message
+func (me *JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("json codec: %T is not a proto.Message", v)
+	}
+	var buf bytes.Buffer
+	if err := me.marshaler.Marshal(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//Unmarshal 解码到proto message
+func (me *JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("json codec: %T is not a proto.Message", v)
+	}
+	if len(data) == 0 {
+		//空消息编码后为{}, 也兼容空body
+		msg.Reset()
+		return nil
+	}
+	return me.unmarshaler.Unmarshal(bytes.NewReader(data), msg)
+}
+
+//Name 对应content-subtype
+func (me *JSONCodec) Name() string {
+	return JSON
+}
@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"local/sndaRpc/pb/login"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestJSONCodec(t *testing.T) {
+	c := encoding.GetCodec(JSON)
+	if c == nil {
+		t.Fatal("json codec not registered")
+	}
+	data, err := c.Marshal(&login.LoginReply{SessionId: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"sessionId":"s1"`) {
+		t.Errorf("unexpected json %s", data)
+	}
+
+	req := new(login.LoginRequest)
+	if err := c.Unmarshal([]byte(`{"userName":"tommy","unknown":1}`), req); err != nil {
+		t.Fatal(err)
+	}
+	if req.GetUserName() != "tommy" {
+		t.Errorf("unexpected request %v", req)
+	}
+	if err := c.Unmarshal(nil, req); err != nil || !proto.Equal(req, &login.LoginRequest{}) {
+		t.Errorf("empty body should decode to empty message: %v %v", req, err)
+	}
+	if _, err := c.Marshal("not a message"); err == nil {
+		t.Error("non proto value should fail")
+	}
+}
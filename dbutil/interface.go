@@ -1,6 +1,7 @@
 package dbutil
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/go-kit/kit/log"
@@ -11,6 +12,8 @@ type DBManager interface {
 	Register(dbName, dataSourceName string, maxIdleConns, maxOpenConns int) error
 	Query(dbName, sql string, args ...interface{}) ([]map[string]interface{}, error)
 	Exec(dbName string, sql string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, dbName, sql string, args ...interface{}) ([]map[string]interface{}, error)
+	ExecContext(ctx context.Context, dbName string, sql string, args ...interface{}) (sql.Result, error)
 	Prepare(dbName string, sql string) (*sql.Stmt, error)
 	Begin(dbName string) (*sql.Tx, error)
 	DB(dbName string) (db *sql.DB, ok bool)
@@ -1,10 +1,12 @@
 package dbutil
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"local/sndaRpc/logHelper"
+	"local/sndaRpc/trace"
 	"os"
 	"reflect"
 	"time"
@@ -73,12 +75,26 @@ func (me *MySQLManager) Register(dbName, dataSourceName string, maxIdleConns, ma
 //args: sql中需要填充的参数,跟问号的数量一致
 //@return []map[string]interface{}, error
 func (me *MySQLManager) Query(dbName, sql string, args ...interface{}) ([]map[string]interface{}, error) {
-	level.Info(me.logger).Log("ts", time.Now().Format("2006-01-02 15:04:05.000.000000"), "caller", stack.Caller(1), "sql", sql, "params", fmt.Sprint(args))
+	return me.query(context.Background(), dbName, sql, args...)
+}
+
+//QueryContext 同Query, ctx中的span作为本次查询span的父span
+func (me *MySQLManager) QueryContext(ctx context.Context, dbName, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	return me.query(ctx, dbName, sql, args...)
+}
+
+func (me *MySQLManager) query(ctx context.Context, dbName, sql string, args ...interface{}) (data []map[string]interface{}, err error) {
+	ctx, span := me.startSpan(ctx, "mysql.Query", dbName, sql)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+	level.Info(me.logger).Log("ts", time.Now().Format("2006-01-02 15:04:05.000.000000"), "caller", stack.Caller(2), "traceID", span.TraceID, "spanID", span.SpanID, "sql", sql, "params", fmt.Sprint(args))
 	db, ok := me.dbs[dbName]
 	if !ok {
 		return nil, fmt.Errorf("Can't find DB named %s ", dbName)
 	}
-	rows, err := db.Query(sql, args...)
+	rows, err := db.QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +106,7 @@ func (me *MySQLManager) Query(dbName, sql string, args ...interface{}) ([]map[st
 	colLen := len(colTypes)
 
 	//表
-	data := make([]map[string]interface{}, 0)
+	data = make([]map[string]interface{}, 0)
 	for rows.Next() {
 		//行table(key,value)
 		rowData := make(map[string]interface{})
@@ -129,11 +145,28 @@ func (me *MySQLManager) Query(dbName, sql string, args ...interface{}) ([]map[st
 //args: sql中需要填充的参数,跟问号的数量一致
 //@return sql.Result, error
 func (me *MySQLManager) Exec(dbName string, sql string, args ...interface{}) (sql.Result, error) {
+	return me.ExecContext(context.Background(), dbName, sql, args...)
+}
+
+//ExecContext 同Exec, ctx中的span作为本次执行span的父span
+func (me *MySQLManager) ExecContext(ctx context.Context, dbName string, sql string, args ...interface{}) (rst sql.Result, err error) {
+	ctx, span := me.startSpan(ctx, "mysql.Exec", dbName, sql)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
 	db, ok := me.dbs[dbName]
 	if !ok {
 		return nil, fmt.Errorf("Can't find DB named %s ", dbName)
 	}
-	return db.Exec(sql, args...)
+	return db.ExecContext(ctx, sql, args...)
+}
+
+func (me *MySQLManager) startSpan(ctx context.Context, name, dbName, sql string) (context.Context, *trace.Span) {
+	ctx, span := trace.StartSpan(ctx, name, trace.KIND_CLIENT)
+	span.SetAttribute("db", dbName)
+	span.SetAttribute("sql", sql)
+	return ctx, span
 }
 
 //Begin 在多语句执行,需要用到事务保证一致性时,该方法返回原始的事务,给用户自行处理事务
@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"fmt"
+	"local/sndaRpc/server"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	//google.api.http在MethodOptions中的扩展字段号
+	httpRuleField = 72295728
+)
+
+//httpRule google.api.HttpRule中网关用到的部分
+type httpRule struct {
+	verb string
+	path string
+	body string
+}
+
+//RegisterByProto 按proto文件中的google.api.http注解注册RESTful路由, 包括additional_bindings.
+//proto中不需要引入annotations的go包, 注解从文件描述中直接解析
+func (me *HTTPGateWay) RegisterByProto(protoName string) error {
+	fd, err := server.FileDescriptor(protoName)
+	if err != nil {
+		return err
+	}
+	for _, svc := range fd.GetService() {
+		serviceName := svc.GetName()
+		if len(fd.GetPackage()) > 0 {
+			serviceName = fd.GetPackage() + "." + serviceName
+		}
+		for _, md := range svc.GetMethod() {
+			if md.GetOptions() == nil {
+				continue
+			}
+			raw, err := proto.Marshal(md.GetOptions())
+			if err != nil {
+				return err
+			}
+			rules, err := httpRules(raw)
+			if err != nil {
+				return fmt.Errorf("%s %s.%s: google.api.http: %s", protoName, serviceName, md.GetName(), err)
+			}
+			for _, rule := range rules {
+				if err := me.RegisterRoute(rule.verb, rule.path, "/"+serviceName+"/"+md.GetName(), rule.body); err != nil {
+					return fmt.Errorf("%s %s.%s: %s", protoName, serviceName, md.GetName(), err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//httpRules 从序列化的MethodOptions中取出google.api.http, 没有注解时返回nil
+func httpRules(options []byte) ([]*httpRule, error) {
+	var rules []*httpRule
+	err := walkFields(options, func(num uint64, data []byte) error {
+		if num != httpRuleField {
+			return nil
+		}
+		list, err := parseHTTPRule(data)
+		rules = append(rules, list...)
+		return err
+	})
+	return rules, err
+}
+
+//parseHTTPRule 解析HttpRule, 返回它自己和additional_bindings
+func parseHTTPRule(b []byte) ([]*httpRule, error) {
+	rule := new(httpRule)
+	var additional []*httpRule
+	err := walkFields(b, func(num uint64, data []byte) error {
+		switch num {
+		case 2, 3, 4, 5, 6: //get, put, post, delete, patch
+			rule.verb = [...]string{2: "GET", 3: "PUT", 4: "POST", 5: "DELETE", 6: "PATCH"}[num]
+			rule.path = string(data)
+		case 7:
+			rule.body = string(data)
+		case 8: //custom: CustomHttpPattern{kind, path}
+			return walkFields(data, func(num uint64, data []byte) error {
+				switch num {
+				case 1:
+					rule.verb = string(data)
+				case 2:
+					rule.path = string(data)
+				}
+				return nil
+			})
+		case 11:
+			list, err := parseHTTPRule(data)
+			if err != nil {
+				return err
+			}
+			additional = append(additional, list...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rule.verb) == 0 || len(rule.path) == 0 {
+		return nil, fmt.Errorf("pattern required")
+	}
+	return append([]*httpRule{rule}, additional...), nil
+}
+
+//walkFields 遍历protobuf编码中的字段, 只把length-delimited类型的字段交给fn, 其他类型跳过
+func walkFields(b []byte, fn func(num uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("invalid field key")
+		}
+		b = b[n:]
+		num, wireType := key>>3, key&7
+		switch wireType {
+		case 0:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return fmt.Errorf("invalid varint field %d", num)
+			}
+			b = b[n:]
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(b) < size {
+				return fmt.Errorf("invalid fixed field %d", num)
+			}
+			b = b[size:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("invalid length of field %d", num)
+			}
+			data := b[n : n+int(size)]
+			b = b[n+int(size):]
+			if err := fn(num, data); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", wireType, num)
+		}
+	}
+	return nil
+}
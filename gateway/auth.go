@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"fmt"
+	"local/sndaRpc/auth"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+//SetRouteAuth 设置路由的认证方式, names对应auth.Register的name, 任意一个通过即可, 为空时不认证.
+//认证通过后调用方身份通过metadata转发给后端服务, 见auth.PrincipalMD
+func (me *HTTPGateWay) SetRouteAuth(name string, names []string) error {
+	for _, authName := range names {
+		if _, ok := auth.Get(authName); !ok {
+			return fmt.Errorf("route %s: auth %s not found", name, authName)
+		}
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.handlers[name]; !ok {
+		return fmt.Errorf("route %s not found", name)
+	}
+	if len(names) == 0 {
+		delete(me.routeAuth, name)
+	} else {
+		me.routeAuth[name] = names
+	}
+	return nil
+}
+
+//authHandler 认证失败返回401, 通过后把调用方身份放入context
+func (me *HTTPGateWay) authHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		me.mu.RLock()
+		names, rpc := me.routeAuth[name], me.handlers[name]
+		me.mu.RUnlock()
+		if len(names) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, err := auth.VerifyRequest(r, names, rpc)
+		if err == errBodyTooLarge {
+			me.writeError(r.Context(), w, http.StatusRequestEntityTooLarge, statusError(codes.ResourceExhausted, err.Error()))
+			return
+		}
+		if err != nil {
+			me.writeError(r.Context(), w, 0, statusError(codes.Unauthenticated, err.Error()))
+			return
+		}
+		//签名认证可能把body写入临时文件, 处理完后关闭删除
+		defer r.Body.Close()
+		next.ServeHTTP(w, r.WithContext(auth.ContextWithPrincipal(r.Context(), principal)))
+	})
+}
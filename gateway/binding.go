@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//jsonPath 把字段路径(proto字段名或json名, 用.分隔)转换成入参json中的key路径(proto字段名), 字段不存在时返回错误
+func jsonPath(tp reflect.Type, fieldPath string) ([]string, error) {
+	var keys []string
+	for _, name := range strings.Split(fieldPath, ".") {
+		for tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
+		}
+		if tp.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("field %s: %s is not a message", fieldPath, name)
+		}
+		field, ok := fieldByName(tp, name)
+		if !ok {
+			return nil, fmt.Errorf("field %s: %s not found in %s", fieldPath, name, tp.Name())
+		}
+		keys = append(keys, protoName(field))
+		tp = field.Type
+	}
+	return keys, nil
+}
+
+//fieldByName 按proto字段名, json=名或json tag查找字段
+func fieldByName(tp reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < tp.NumField(); i++ {
+		field := tp.Field(i)
+		tag := field.Tag.Get("protobuf")
+		if len(tag) == 0 {
+			continue
+		}
+		if jsonName(field) == name {
+			return field, true
+		}
+		for _, opt := range strings.Split(tag, ",") {
+			if opt == "name="+name || opt == "json="+name {
+				return field, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+//jsonName encoding/json使用的key
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if len(name) == 0 {
+		return field.Name
+	}
+	return name
+}
+
+//protoName proto中的原始字段名, jsonpb解码时可以识别
+func protoName(field reflect.StructField) string {
+	for _, opt := range strings.Split(field.Tag.Get("protobuf"), ",") {
+		if strings.HasPrefix(opt, "name=") {
+			return opt[len("name="):]
+		}
+	}
+	return jsonName(field)
+}
+
+//setPath 按key路径设置嵌套的map
+func setPath(m map[string]interface{}, keys []string, value interface{}) {
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[key] = child
+		}
+		m = child
+	}
+	m[keys[len(keys)-1]] = value
+}
+
+//checkRoute 注册时检查路径变量和body字段在入参中存在
+func checkRoute(rt *route, reqType reflect.Type) error {
+	for _, v := range rt.template.vars {
+		if _, err := jsonPath(reqType, v.field); err != nil {
+			return fmt.Errorf("route %s: %s", rt.key(), err)
+		}
+	}
+	if len(rt.body) > 0 && rt.body != "*" {
+		if _, err := jsonPath(reqType, rt.body); err != nil {
+			return fmt.Errorf("route %s: body %s", rt.key(), err)
+		}
+	}
+	return nil
+}
+
+//decodeRoute 与grpc-gateway一致: 路径变量 > body > query参数.
+//body为*时整个body是入参, 不读取query; body为字段路径时body是该字段, 其他字段从query中读取.
+//body可以是json或multipart/form-data, 见decodeMultipart
+func (me *HTTPGateWay) decodeRoute(rt *route) func(ctx context.Context, r *http.Request) (interface{}, error) {
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		info := me.client.InterfaceInfo(rt.rpc)
+		if info == nil {
+			return nil, status.Errorf(codes.NotFound, "can not find method %s", rt.rpc)
+		}
+		reqType := proto.MessageType(info.ReqType)
+		if reqType == nil {
+			return nil, status.Errorf(codes.Internal, "invalid request type %s", info.ReqType)
+		}
+		m := make(map[string]interface{})
+		if rt.body != "*" {
+			for key, values := range r.URL.Query() {
+				keys, err := jsonPath(reqType, key)
+				if err != nil {
+					//不认识的参数忽略
+					continue
+				}
+				if len(values) == 1 {
+					setPath(m, keys, values[0])
+				} else {
+					setPath(m, keys, values)
+				}
+			}
+		}
+		if len(rt.body) > 0 && r.Body != nil && isMultipart(r) {
+			parts, err := decodeMultipart(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			if rt.body == "*" {
+				appendMap(parts, m)
+			} else {
+				keys, _ := jsonPath(reqType, rt.body)
+				setPath(m, keys, parts)
+			}
+		} else if len(rt.body) > 0 && r.Body != nil {
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			if len(b) > 0 {
+				var body interface{}
+				if err := unmarshalJSON(b, &body); err != nil {
+					return nil, fmt.Errorf("invalid json body: %s", err)
+				}
+				if rt.body == "*" {
+					obj, ok := body.(map[string]interface{})
+					if !ok {
+						return nil, fmt.Errorf("invalid json body: should be an object")
+					}
+					appendMap(obj, m)
+				} else {
+					keys, _ := jsonPath(reqType, rt.body)
+					setPath(m, keys, body)
+				}
+			}
+		}
+		for field, value := range pathVarsFromContext(r.Context()) {
+			keys, err := jsonPath(reqType, field)
+			if err != nil {
+				return nil, err
+			}
+			setPath(m, keys, value)
+		}
+		m[MethodName] = rt.key()
+		return m, nil
+	}
+}
@@ -0,0 +1,238 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"local/sndaRpc/util"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	//NAMING_CAMEL 响应字段名使用json_name(lowerCamelCase), 默认
+	NAMING_CAMEL = "camelCase"
+	//NAMING_ORIGINAL 响应字段名使用proto中的原始字段名
+	NAMING_ORIGINAL = "original"
+)
+
+//Option 网关json编解码参数
+type Option func(*options)
+
+type options struct {
+	naming       string
+	emitDefaults bool
+	envelope     bool
+	errorFormat  func(ctx context.Context, body *ErrorBody) interface{}
+	docsTitle    string
+	docsVersion  string
+	docsUI       string
+	docsDisabled bool
+	docsAuth     []string
+	cors         map[string]*CORSPolicy //<策略名, 跨域策略>
+	maxBody      int64
+	headers      map[string]string //安全相关的响应头
+
+	requestIDHeader string
+	multipartMemory int64
+}
+
+//FieldNaming 响应的字段命名方式, NAMING_CAMEL或NAMING_ORIGINAL. 请求中两种命名都可以识别
+func FieldNaming(naming string) Option {
+	return func(opts *options) {
+		opts.naming = naming
+	}
+}
+
+//EmitDefaults 响应中是否输出值为默认值(0, "", false, 空列表)的字段
+func EmitDefaults(emit bool) Option {
+	return func(opts *options) {
+		opts.emitDefaults = emit
+	}
+}
+
+//OptionsByConfig 把<http><json>配置转成Option, 错误信息中带上出错的属性名
+func OptionsByConfig(info *util.HTTPJSONInfo) ([]Option, error) {
+	var list []Option
+	if info == nil {
+		return list, nil
+	}
+	if len(info.Naming) > 0 {
+		if err := checkNaming(info.Naming); err != nil {
+			return nil, fmt.Errorf("http json: invalid naming %s", info.Naming)
+		}
+		list = append(list, FieldNaming(info.Naming))
+	}
+	if len(info.EmitDefaults) > 0 {
+		emit, err := strconv.ParseBool(info.EmitDefaults)
+		if err != nil {
+			return nil, fmt.Errorf("http json: invalid emit-defaults %s", info.EmitDefaults)
+		}
+		list = append(list, EmitDefaults(emit))
+	}
+	if len(info.Envelope) > 0 {
+		enable, err := strconv.ParseBool(info.Envelope)
+		if err != nil {
+			return nil, fmt.Errorf("http json: invalid envelope %s", info.Envelope)
+		}
+		list = append(list, Envelope(enable))
+	}
+	return list, nil
+}
+
+func checkNaming(naming string) error {
+	switch naming {
+	case "", NAMING_CAMEL, NAMING_ORIGINAL:
+		return nil
+	}
+	return fmt.Errorf("unsupported naming %s", naming)
+}
+
+//SetOptions 设置json编解码参数
+func (me *HTTPGateWay) SetOptions(opts ...Option) error {
+	o := me.opts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := checkNaming(o.naming); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	me.opts = o
+	me.mu.Unlock()
+	return nil
+}
+
+func (me *HTTPGateWay) envelope() bool {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.opts.envelope
+}
+
+func (me *HTTPGateWay) marshaler() *jsonpb.Marshaler {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return &jsonpb.Marshaler{OrigName: me.opts.naming == NAMING_ORIGINAL, EmitDefaults: me.opts.emitDefaults}
+}
+
+//unmarshalRequest 把decode得到的map按入参字段类型转换后用jsonpb解码, 不认识的字段忽略
+func unmarshalRequest(m map[string]interface{}, msg proto.Message) error {
+	tp := reflect.TypeOf(msg)
+	b, err := json.Marshal(coerceMessage(tp, expandPaths(tp, m)))
+	if err != nil {
+		return err
+	}
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	return unmarshaler.Unmarshal(bytes.NewReader(b), msg)
+}
+
+//expandPaths 固定路径的form参数可以用字段路径设置嵌套字段, 如 inner.count=1
+func expandPaths(tp reflect.Type, m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		if !strings.Contains(key, ".") {
+			out[key] = value
+		}
+	}
+	for key, value := range m {
+		if !strings.Contains(key, ".") {
+			continue
+		}
+		keys, err := jsonPath(tp, key)
+		if err != nil {
+			out[key] = value
+			continue
+		}
+		setPath(out, keys, value)
+	}
+	return out
+}
+
+//coerceMessage query/form中的值都是字符串, 按字段类型转成json中对应的类型. 不认识的字段和转换失败的值原样保留, 由jsonpb报错
+func coerceMessage(tp reflect.Type, m map[string]interface{}) map[string]interface{} {
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if tp.Kind() != reflect.Struct {
+		return m
+	}
+	out := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		field, ok := fieldByName(tp, key)
+		if !ok {
+			out[key] = value
+			continue
+		}
+		out[key] = coerceField(field, value)
+	}
+	return out
+}
+
+func coerceField(field reflect.StructField, value interface{}) interface{} {
+	tp := field.Type
+	//[]byte是base64字符串
+	if tp.Kind() == reflect.Slice && tp.Elem().Kind() != reflect.Uint8 {
+		var list []interface{}
+		switch v := value.(type) {
+		case []interface{}:
+			list = v
+		case []string:
+			for _, s := range v {
+				list = append(list, s)
+			}
+		default:
+			//repeated字段只传了一个值
+			list = []interface{}{v}
+		}
+		out := make([]interface{}, len(list))
+		for i, item := range list {
+			out[i] = coerceValue(tp.Elem(), item)
+		}
+		return out
+	}
+	if tp.Kind() == reflect.Map {
+		return value
+	}
+	//非repeated字段传了多个值时取最后一个, 与grpc-gateway一致
+	if list, ok := value.([]string); ok && len(list) > 0 {
+		value = list[len(list)-1]
+	}
+	return coerceValue(tp, value)
+}
+
+func coerceValue(tp reflect.Type, value interface{}) interface{} {
+	if m, ok := value.(map[string]interface{}); ok {
+		return coerceMessage(tp, m)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case reflect.Int32:
+		//枚举可以是名字或者数字
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil {
+			return n
+		}
+	case reflect.Uint32:
+		if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+			return n
+		}
+	case reflect.Float32, reflect.Float64:
+		//NaN, Infinity保留字符串
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !strings.ContainsAny(s, "nN") {
+			return f
+		}
+	}
+	//int64/uint64在proto3 json中是字符串, 不需要转换
+	return s
+}
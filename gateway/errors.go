@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"local/sndaRpc/logHelper"
+	"local/sndaRpc/validate"
+	"net/http"
+
+	"github.com/go-kit/kit/ratelimit"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//ErrorBody 错误响应, 可以用ErrorFormat改成其他格式
+type ErrorBody struct {
+	Code    int32         `json:"code"` //grpc错误码
+	Message string        `json:"message"`
+	FlowID  string        `json:"flowID,omitempty"`
+	Details []interface{} `json:"details,omitempty"`
+}
+
+//envelope 统一的成功响应 {code, data}
+type envelope struct {
+	Code int32           `json:"code"`
+	Data json.RawMessage `json:"data"`
+}
+
+//ErrorFormat 自定义错误响应的内容, 返回值用encoding/json编码. http状态码由错误码决定
+func ErrorFormat(format func(ctx context.Context, body *ErrorBody) interface{}) Option {
+	return func(opts *options) {
+		opts.errorFormat = format
+	}
+}
+
+//Envelope 成功的响应是否包装成 {"code":0,"data":响应}
+func Envelope(enable bool) Option {
+	return func(opts *options) {
+		opts.envelope = enable
+	}
+}
+
+//HTTPStatusFromCode grpc错误码对应的http状态码, 与grpc-gateway一致
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		//nginx的 Client Closed Request
+		return 499
+	case codes.Unknown:
+		return http.StatusInternalServerError
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Aborted:
+		return http.StatusConflict
+	case codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Internal:
+		return http.StatusInternalServerError
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DataLoss:
+		return http.StatusInternalServerError
+	}
+	return http.StatusInternalServerError
+}
+
+//toStatus 把错误转成grpc status, 客户端断路器和限流的错误分别按Unavailable和ResourceExhausted处理,
+//其他不是grpc错误的按Unknown处理
+func toStatus(err error) *status.Status {
+	if verr, ok := err.(*validate.Error); ok {
+		return verr.Status()
+	}
+	switch err {
+	case context.Canceled:
+		return status.New(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.New(codes.DeadlineExceeded, err.Error())
+	case gobreaker.ErrOpenState, gobreaker.ErrTooManyRequests:
+		//客户端的断路器打开, 后端服务暂时不可用
+		return status.New(codes.Unavailable, err.Error())
+	case ratelimit.ErrLimited:
+		//客户端限流
+		return status.New(codes.ResourceExhausted, err.Error())
+	}
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	return status.New(codes.Unknown, err.Error())
+}
+
+//encodeError 按错误码返回http状态码和错误响应
+func (me *HTTPGateWay) encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	httpCode, body := me.errorBody(err)
+	me.writeError(ctx, w, httpCode, body)
+}
+
+//errorBody 错误对应的http状态码和错误响应. 入参校验失败时details是每个字段的错误
+func (me *HTTPGateWay) errorBody(err error) (int, *ErrorBody) {
+	if isTooLarge(err) {
+		return http.StatusRequestEntityTooLarge, statusError(codes.ResourceExhausted, err.Error())
+	}
+	st := toStatus(err)
+	body := &ErrorBody{Code: int32(st.Code()), Message: st.Message()}
+	if verr, ok := validate.FromError(err); ok {
+		for _, v := range verr.Violations {
+			body.Details = append(body.Details, v)
+		}
+	} else {
+		marshaler := me.marshaler()
+		for _, detail := range st.Proto().GetDetails() {
+			s, err := marshaler.MarshalToString(detail)
+			if err != nil {
+				//没有注册的类型忽略
+				continue
+			}
+			body.Details = append(body.Details, json.RawMessage(s))
+		}
+	}
+	return HTTPStatusFromCode(st.Code()), body
+}
+
+//writeError 写错误响应, httpCode为0时按错误码计算
+func (me *HTTPGateWay) writeError(ctx context.Context, w http.ResponseWriter, httpCode int, body *ErrorBody) {
+	if httpCode == 0 {
+		httpCode = HTTPStatusFromCode(codes.Code(body.Code))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(httpCode)
+	json.NewEncoder(w).Encode(me.formatError(ctx, body))
+}
+
+//formatError 带上flowID, 按ErrorFormat转换错误响应
+func (me *HTTPGateWay) formatError(ctx context.Context, body *ErrorBody) interface{} {
+	if logInfo, ok := logHelper.FromContext(ctx); ok {
+		body.FlowID = logInfo.FlowID
+	}
+	me.mu.RLock()
+	format := me.opts.errorFormat
+	me.mu.RUnlock()
+	if format != nil {
+		return format(ctx, body)
+	}
+	return body
+}
+
+//statusError 网关自己产生的错误
+func statusError(code codes.Code, msg string) *ErrorBody {
+	return &ErrorBody{Code: int32(code), Message: msg}
+}
@@ -1,18 +1,28 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"local/sndaRpc/auth"
 	"local/sndaRpc/client"
+	"local/sndaRpc/idempotency"
 	"local/sndaRpc/logHelper"
+	"local/sndaRpc/trace"
 	"local/sndaRpc/util"
+	"local/sndaRpc/validate"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -21,6 +31,8 @@ import (
 	"github.com/go-kit/kit/endpoint"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -43,6 +55,19 @@ type HTTPGateWay struct {
 	addr      string
 	handlers  map[string]string //<methodName,需要调用的内部处理接口>
 	serveMux  *http.ServeMux
+	client    client.Client //调用后端服务的客户端, 默认为client.DefaultGRPCClient()
+	mu        sync.RWMutex
+	disabled  map[string]bool //<路由, 是否被停用>, 通过admin接口修改
+	router    router          //RESTful路由
+	opts      options         //json编解码参数
+
+	routeCORS    map[string]string       //<路由, 跨域策略名>
+	routeMaxBody map[string]int64        //<路由, 最大请求body>
+	routeAuth    map[string][]string     //<路由, 认证方式>
+	routeLimits  map[string][]*RateLimit //<路由, 限流规则>
+	routeMaxFile map[string]int64        //<路由, 上传的每个文件的最大字节数>
+
+	memoryLimiter *MemoryRateLimiter //没有配置redis的限流规则使用
 }
 
 //New 创建对象
@@ -51,7 +76,17 @@ func NewHTTPGateway() *HTTPGateWay {
 	gw.SetLogger(log.NewLogfmtLogger(os.Stderr))
 	gw.serveMux = http.NewServeMux()
 	gw.handlers = make(map[string]string)
+	gw.disabled = make(map[string]bool)
+	gw.routeCORS = make(map[string]string)
+	gw.routeMaxBody = make(map[string]int64)
+	gw.routeAuth = make(map[string][]string)
+	gw.routeLimits = make(map[string][]*RateLimit)
+	gw.routeMaxFile = make(map[string]int64)
+	gw.memoryLimiter = NewMemoryRateLimiter()
+	gw.opts.maxBody = DEFAULT_MAX_BODY
+	gw.opts.headers = defaultSecurityHeaders()
 	gw.addr = ":80"
+	gw.client = client.DefaultGRPCClient()
 	return gw
 }
 
@@ -69,23 +104,256 @@ func (me *HTTPGateWay) SetLogger(lg log.Logger) error {
 	return nil
 }
 
-//Register 注册handler
+var _ ClientSetter = (*HTTPGateWay)(nil)
+
+//SetClient 设置调用后端服务的客户端, 如测试时连接内存中的服务
+func (me *HTTPGateWay) SetClient(clt client.Client) error {
+	if nil == clt {
+		return fmt.Errorf("nil client not allow")
+	}
+	me.client = clt
+	return nil
+}
+
+//Register 注册handler. 配置了verb时按RESTful路由注册, name为路径模板, 如 GET /users/{userName}/session
 func (me *HTTPGateWay) Register(infoList []*util.HTTPGateWayInfo) error {
 	for _, info := range infoList {
-		ep := me.makeHTTPEndpoint()
-		ep = me.logMeddleWare()(ep)
-		handler := kithttp.NewServer(
-			ep,
-			decodeRequest,
-			encodeResponse,
-		)
-		me.serveMux.Handle(info.Name, handler)
-		me.handlers[info.Name] = info.Method
-		level.Debug(me.logger).Log(":=", "register http gate way", "name", info.Name, "method", info.Method)
+		if len(info.Verb) > 0 {
+			if err := me.RegisterStreamRoute(info.Verb, info.Name, info.Method, info.Body, info.Stream); err != nil {
+				return err
+			}
+		} else {
+			dec := decodeFixedRoute(info.Name)
+			handler := me.newHandler(dec)
+			if len(info.Stream) > 0 {
+				if err := checkStreamMode(info.Stream); err != nil {
+					return fmt.Errorf("route %s: %s", info.Name, err)
+				}
+				//客户端已经注册了接口时检查, 否则在请求时检查
+				if method := me.client.InterfaceInfo(info.Method); method != nil {
+					if err := checkStreamMethod(info.Stream, method); err != nil {
+						return fmt.Errorf("route %s: %s", info.Name, err)
+					}
+				}
+				handler = me.newStreamHandler(info.Name, info.Stream, dec, false)
+			}
+			me.serveMux.Handle(info.Name, me.routeHandler(info.Name, handler))
+			me.mu.Lock()
+			me.handlers[info.Name] = info.Method
+			me.mu.Unlock()
+			level.Debug(me.logger).Log(":=", "register http gate way", "name", info.Name, "method", info.Method)
+		}
+		if err := me.setRouteOptions(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//setRouteOptions 路由单独配置的跨域策略, 最大请求body, 认证方式和限流
+func (me *HTTPGateWay) setRouteOptions(info *util.HTTPGateWayInfo) error {
+	name := info.Name
+	if len(info.Verb) > 0 {
+		name = strings.ToUpper(info.Verb) + " " + info.Name
+	}
+	if len(info.CORS) > 0 {
+		if err := me.SetRouteCORS(name, info.CORS); err != nil {
+			return err
+		}
+	}
+	if len(info.MaxBody) > 0 {
+		n, err := util.ParseSize(info.MaxBody)
+		if err != nil {
+			return fmt.Errorf("route %s: invalid max-body %s", name, info.MaxBody)
+		}
+		if err := me.SetRouteMaxBody(name, int64(n)); err != nil {
+			return err
+		}
+	}
+	if len(info.MaxFile) > 0 {
+		n, err := util.ParseSize(info.MaxFile)
+		if err != nil {
+			return fmt.Errorf("route %s: invalid max-file %s", name, info.MaxFile)
+		}
+		if err := me.SetRouteMaxFile(name, int64(n)); err != nil {
+			return err
+		}
+	}
+	if len(info.Auth) > 0 {
+		if err := me.SetRouteAuth(name, auth.SplitNames(info.Auth)); err != nil {
+			return err
+		}
+	}
+	if len(info.RateLimitList) > 0 {
+		limits, err := RateLimitsByConfig(info.RateLimitList)
+		if err != nil {
+			return fmt.Errorf("route %s: %v", name, err)
+		}
+		if err := me.SetRouteRateLimits(name, limits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//RegisterRoute 注册RESTful路由, 路径变量, query参数和body按grpc-gateway的规则映射到入参
+//verb: http method, 如 GET
+//path: 路径模板, 如 /users/{userName}/session, /v1/{name=messages/*}
+//method: rpc接口, 如 /login.loginService/login
+//body: *表示body是整个入参, 字段路径表示body是该字段, 空表示没有body
+func (me *HTTPGateWay) RegisterRoute(verb, path, method, body string) error {
+	return me.RegisterStreamRoute(verb, path, method, body, "")
+}
+
+//RegisterStreamRoute 与RegisterRoute相同, stream为STREAM_SSE或STREAM_WEBSOCKET时把流式接口桥接成sse或websocket, 为空时是普通接口
+func (me *HTTPGateWay) RegisterStreamRoute(verb, path, method, body, stream string) error {
+	if err := checkStreamMode(stream); err != nil {
+		return fmt.Errorf("route %s: %s", path, err)
+	}
+	template, err := parseTemplate(path)
+	if err != nil {
+		return err
+	}
+	rt := &route{verb: strings.ToUpper(verb), template: template, rpc: method, body: body}
+	//客户端已经注册了接口时检查字段和流式接口, 否则在请求时检查
+	if info := me.client.InterfaceInfo(method); info != nil {
+		if len(stream) > 0 {
+			if err := checkStreamMethod(stream, info); err != nil {
+				return fmt.Errorf("route %s: %s", path, err)
+			}
+		}
+		if tp := proto.MessageType(info.ReqType); tp != nil {
+			if err := checkRoute(rt, tp); err != nil {
+				return err
+			}
+		}
 	}
+	handler := me.newHandler(me.decodeRoute(rt))
+	if len(stream) > 0 {
+		handler = me.newStreamHandler(rt.key(), stream, me.decodeRoute(rt), len(body) > 0)
+	}
+	rt.handler = me.routeHandler(rt.key(), handler)
+	if err := me.router.add(rt); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	me.handlers[rt.key()] = method
+	me.mu.Unlock()
+	level.Debug(me.logger).Log(":=", "register http route", "route", rt.key(), "method", method, "body", body)
 	return nil
 }
 
+func (me *HTTPGateWay) newHandler(dec kithttp.DecodeRequestFunc) http.Handler {
+	ep := me.makeHTTPEndpoint()
+	ep = me.logMeddleWare()(ep)
+	ep = me.traceMeddleWare()(ep)
+	return kithttp.NewServer(
+		ep,
+		invalidArgument(dec),
+		me.encodeResponse,
+		kithttp.ServerBefore(getTraceparent(), getHeader()),
+		kithttp.ServerErrorEncoder(me.encodeError),
+	)
+}
+
+//invalidArgument 解析请求失败是调用方的问题, 返回400
+func invalidArgument(dec kithttp.DecodeRequestFunc) kithttp.DecodeRequestFunc {
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		request, err := dec(ctx, r)
+		if err != nil && !isTooLarge(err) {
+			if _, ok := status.FromError(err); !ok {
+				err = status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+		return request, err
+	}
+}
+
+//RouteStatus 网关路由和是否可用
+type RouteStatus struct {
+	Name    string `json:"name"`   //http路径, 如 /login
+	Method  string `json:"method"` //对应的rpc接口
+	Enabled bool   `json:"enabled"`
+}
+
+//Routes 返回已注册的全部路由, 按路径排序
+func (me *HTTPGateWay) Routes() []*RouteStatus {
+	me.mu.RLock()
+	list := make([]*RouteStatus, 0, len(me.handlers))
+	for name, method := range me.handlers {
+		list = append(list, &RouteStatus{Name: name, Method: method, Enabled: !me.disabled[name]})
+	}
+	me.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+//SetRouteEnabled 运行时停用或恢复一个路由, 停用后返回503
+func (me *HTTPGateWay) SetRouteEnabled(name string, enabled bool) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.handlers[name]; !ok {
+		return fmt.Errorf("route %s not found", name)
+	}
+	if enabled {
+		delete(me.disabled, name)
+	} else {
+		me.disabled[name] = true
+	}
+	return nil
+}
+
+//routeHandler 每个路由的处理顺序: 跨域, 停用, body大小限制, 认证, 限流. 按调用方限流需要先认证
+func (me *HTTPGateWay) routeHandler(name string, next http.Handler) http.Handler {
+	return me.corsHandler(name, me.switchHandler(name, me.limitHandler(name, me.authHandler(name, me.rateLimitHandler(name, next)))))
+}
+
+//switchHandler 被停用的路由返回503
+func (me *HTTPGateWay) switchHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		me.mu.RLock()
+		disabled := me.disabled[name]
+		me.mu.RUnlock()
+		if disabled {
+			me.writeError(r.Context(), w, 0, statusError(codes.Unavailable, "route "+name+" is disabled"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//Handler 返回网关的路由, 用于和其他服务共用一个端口
+func (me *HTTPGateWay) Handler() http.Handler {
+	return http.HandlerFunc(me.serveHTTP)
+}
+
+//serveHTTP 文档和RESTful路由优先, 没有匹配时按固定路径查找
+func (me *HTTPGateWay) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	//错误响应中需要flowID, 在这里生成
+	r = me.withRequestID(w, r)
+	me.writeSecurityHeaders(w)
+	if me.serveDocs(w, r) {
+		return
+	}
+	if isPreflight(r) && me.servePreflight(w, r) {
+		return
+	}
+	served, allowed := me.router.serve(w, r)
+	if served {
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		me.writeError(r.Context(), w, http.StatusMethodNotAllowed, statusError(codes.Unimplemented, "method "+r.Method+" not allowed"))
+		return
+	}
+	if _, pattern := me.serveMux.Handler(r); len(pattern) == 0 {
+		me.writeError(r.Context(), w, 0, statusError(codes.NotFound, "path "+r.URL.Path+" not found"))
+		return
+	}
+	me.serveMux.ServeHTTP(w, r)
+}
+
 //Serve 启动服务HTTP网关服务
 func (me *HTTPGateWay) Serve(addr string) error {
 	if len(addr) > 0 {
@@ -98,36 +366,19 @@ func (me *HTTPGateWay) Serve(addr string) error {
 	defer func() {
 		me.isRunning = false
 	}()
-	return http.ListenAndServe(me.addr, me.serveMux)
+	return http.ListenAndServe(me.addr, me.Handler())
 
 }
 
 func (me *HTTPGateWay) makeHTTPEndpoint() endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		reqMap := request.(map[string]interface{})
-		//这个是http的method
-		method := reqMap[MethodName].(string)
-		//这是映射到真正的rpc method
-		rpcMethod, ok := me.handlers[method]
-		if !ok {
-			return nil, fmt.Errorf("can not find method %s", method)
-		}
-		clt := client.DefaultGRPCClient()
-		info := clt.InterfaceInfo(rpcMethod)
-		if nil == info {
-			return nil, fmt.Errorf("can not find method %s", rpcMethod)
-		}
-		tp := proto.MessageType(info.ReqType)
-		reqObj := reflect.New(tp.Elem()).Interface()
-		b, err := json.Marshal(request)
+		rpcMethod, reqObj, err := me.rpcRequest(request.(map[string]interface{}))
 		if err != nil {
 			return nil, err
 		}
-		err = json.Unmarshal(b, reqObj)
-		if err != nil {
-			return nil, err
-		}
-		rsp, err := clt.InvokeTimeout(ctx, rpcMethod, reqObj, time.Second*3)
+		header, _ := ctx.Value(headerKey{}).(http.Header)
+		ctx = me.withIdempotencyKey(ctx, header, rpcMethod)
+		rsp, err := me.client.InvokeTimeout(ctx, rpcMethod, reqObj, time.Second*3)
 		if err != nil {
 			level.Error(me.logger).Log("error", err)
 			return nil, err
@@ -136,14 +387,58 @@ func (me *HTTPGateWay) makeHTTPEndpoint() endpoint.Endpoint {
 	}
 }
 
+//rpcRequest 按路由找到rpc接口, 把请求参数转成入参
+func (me *HTTPGateWay) rpcRequest(reqMap map[string]interface{}) (string, proto.Message, error) {
+	//这个是http的method
+	method, ok := reqMap[MethodName].(string)
+	if !ok {
+		return "", nil, status.Errorf(codes.InvalidArgument, "invalid %s %v", MethodName, reqMap[MethodName])
+	}
+	//这是映射到真正的rpc method
+	me.mu.RLock()
+	rpcMethod, found := me.handlers[method]
+	me.mu.RUnlock()
+	if !found {
+		return "", nil, status.Errorf(codes.NotFound, "can not find method %s", method)
+	}
+	reqObj, err := me.newRequest(rpcMethod, reqMap)
+	return rpcMethod, reqObj, err
+}
+
+//newRequest 把请求参数转成rpc接口的入参并校验
+func (me *HTTPGateWay) newRequest(rpcMethod string, reqMap map[string]interface{}) (proto.Message, error) {
+	info := me.client.InterfaceInfo(rpcMethod)
+	if nil == info {
+		return nil, status.Errorf(codes.NotFound, "can not find method %s", rpcMethod)
+	}
+	tp := proto.MessageType(info.ReqType)
+	if tp == nil {
+		return nil, status.Errorf(codes.Internal, "invalid request type %s", info.ReqType)
+	}
+	reqObj := reflect.New(tp.Elem()).Interface().(proto.Message)
+	if err := unmarshalRequest(reqMap, reqObj); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid request: %s", err)
+	}
+	//入参不合法时不用再调用后端服务
+	if err := validate.Validate(rpcMethod, reqObj); err != nil {
+		return nil, err
+	}
+	return reqObj, nil
+}
+
 func (me *HTTPGateWay) logMeddleWare() endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (interface{}, error) {
-			ctx = logHelper.ContextWithNewLogInfo(ctx)
+			if _, ok := logHelper.FromContext(ctx); !ok {
+				ctx = logHelper.ContextWithNewLogInfo(ctx)
+			}
 			onceLogger := log.With(me.logger, "ts", log.TimestampFormat(time.Now().Local, "2006-01-02 15:04:05.000.000000"))
 			logInfo, _ := logHelper.FromContext(ctx)
 			onceLogger = log.With(onceLogger, "flowID", logInfo.FlowID)
-			b, err := json.Marshal(request)
+			if span, ok := trace.FromContext(ctx); ok {
+				onceLogger = log.With(onceLogger, "traceID", span.TraceID, "spanID", span.SpanID)
+			}
+			b, err := json.Marshal(logRequest(request))
 			if err == nil {
 				reqParams := string(b)
 				onceLogger = log.With(onceLogger, "request", reqParams)
@@ -164,6 +459,90 @@ func (me *HTTPGateWay) logMeddleWare() endpoint.Middleware {
 
 }
 
+//logRequest 上传的文件内容不写入日志, 只记录大小
+func logRequest(request interface{}) interface{} {
+	reqMap, ok := request.(map[string]interface{})
+	if !ok {
+		return request
+	}
+	redacted := make(map[string]interface{}, len(reqMap))
+	for k, v := range reqMap {
+		switch v := v.(type) {
+		case []byte:
+			redacted[k] = fmt.Sprintf("[%d bytes]", len(v))
+		case []interface{}:
+			list := make([]interface{}, len(v))
+			for i, item := range v {
+				if b, ok := item.([]byte); ok {
+					list[i] = fmt.Sprintf("[%d bytes]", len(b))
+				} else {
+					list[i] = item
+				}
+			}
+			redacted[k] = list
+		default:
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+//traceMeddleWare 为每个http请求创建server span
+func (me *HTTPGateWay) traceMeddleWare() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			name := ""
+			if reqMap, ok := request.(map[string]interface{}); ok {
+				name, _ = reqMap[MethodName].(string)
+			}
+			ctx, span := trace.StartSpan(ctx, name, trace.KIND_SERVER)
+			defer span.Finish()
+			response, err := next(ctx, request)
+			span.SetError(err)
+			return response, err
+		}
+	}
+}
+
+//getTraceparent 从http header中读取上游的traceparent
+func getTraceparent() kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		return trace.ContextWithTraceparent(ctx, r.Header.Get(trace.HEADER))
+	}
+}
+
+type headerKey struct {
+}
+
+//getHeader 把http header放入ctx, 找到rpc接口后再读取幂等key
+func getHeader() kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		return context.WithValue(ctx, headerKey{}, r.Header)
+	}
+}
+
+//withIdempotencyKey 从<interface idempotency-header="">同名的http header中读取幂等key, 调用后端服务时带上
+func (me *HTTPGateWay) withIdempotencyKey(ctx context.Context, header http.Header, rpcMethod string) context.Context {
+	var name string
+	if info := me.client.InterfaceInfo(rpcMethod); info != nil {
+		name = info.IdempotencyHeader
+	}
+	return idempotency.ContextWithKey(ctx, header.Get(idempotency.HeaderName(name)))
+}
+
+//decodeFixedRoute 与decodeRoute一样把请求绑定到注册的路由, 不能用请求中的method参数调用其他路由
+func decodeFixedRoute(name string) kithttp.DecodeRequestFunc {
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		request, err := decodeRequest(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		m := request.(map[string]interface{})
+		m[MethodName] = name
+		return m, nil
+	}
+}
+
 func decodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	method := r.Method
 	switch method {
@@ -176,10 +555,22 @@ func decodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	}
 }
 
-func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+//encodeResponse proto message按proto3的json映射编码, 字段命名, 是否输出默认值和是否包装见SetOptions
+func (me *HTTPGateWay) encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	header := w.Header()
 	header.Add("Content-Type", "application/json; charset=utf-8")
-	return json.NewEncoder(w).Encode(response)
+	msg, ok := response.(proto.Message)
+	if !ok {
+		return json.NewEncoder(w).Encode(response)
+	}
+	if !me.envelope() {
+		return me.marshaler().Marshal(w, msg)
+	}
+	data, err := me.marshaler().MarshalToString(msg)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(&envelope{Code: int32(codes.OK), Data: json.RawMessage(data)})
 }
 
 func decodeGETRequest(ctx context.Context, r *http.Request) (interface{}, error) {
@@ -210,24 +601,50 @@ func decodePOSTRequest(ctx context.Context, r *http.Request) (interface{}, error
 	if r.Body == nil {
 		return m, nil
 	}
-
-	ct := r.Header.Get("Content-Type")
-	if ct != "application/json" && ct != "text/json" {
+	if isMultipart(r) {
+		parts, err := decodeMultipart(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		appendMap(parts, m)
 		return m, nil
 	}
-	bodyReader := r.Body
-	if bodyReader == nil {
+
+	if !isJSON(r) {
 		return m, nil
 	}
-	b, err := ioutil.ReadAll(bodyReader)
+	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
 		return m, nil
 	}
 	jsonMap := make(map[string]interface{})
-	err = json.Unmarshal(b, &jsonMap)
+	if err := unmarshalJSON(b, &jsonMap); err != nil {
+		return nil, fmt.Errorf("invalid json body: %s", err)
+	}
 	appendMap(jsonMap, m)
 	return m, nil
+}
 
+//isJSON Content-Type是application/json或text/json, 可以带charset等参数
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || mediaType == "text/json")
+}
+
+//unmarshalJSON 与json.Unmarshal相同, 但数字解析成json.Number, 避免int64/uint64转成float64丢失精度
+func unmarshalJSON(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
 }
 func appendMap(from, to map[string]interface{}) {
 	for k, v := range from {
@@ -1,7 +1,38 @@
 package gateway
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"local/sndaRpc/auth"
+	"local/sndaRpc/client"
+	"local/sndaRpc/client/clienttest"
+	"local/sndaRpc/idempotency"
+	"local/sndaRpc/logHelper"
+	"local/sndaRpc/pb/login"
+	"local/sndaRpc/util"
+	"local/sndaRpc/validate"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/ratelimit"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestHttp(t *testing.T) {
@@ -13,3 +44,1130 @@ func TestHttp(t *testing.T) {
 	// http.Handle("/method", handler)
 	// log.Fatal(http.ListenAndServe(":8082", nil))
 }
+
+func TestPathTemplate(t *testing.T) {
+	cases := []struct {
+		template, path string
+		ok             bool
+		vars           map[string]string
+	}{
+		{"/users/{userName}/session", "/users/tommy/session", true, map[string]string{"userName": "tommy"}},
+		{"/users/{userName}/session", "/users/a%2Fb/session", true, map[string]string{"userName": "a/b"}},
+		{"/users/{userName}/session", "/users//session", false, nil},
+		{"/users/{userName}/session", "/users/tommy", false, nil},
+		{"/users/{userName}/session", "/users/tommy/session/x", false, nil},
+		{"/v1/{name=messages/*}", "/v1/messages/1", true, map[string]string{"name": "messages/1"}},
+		{"/v1/{name=messages/*}", "/v1/users/1", false, nil},
+		{"/files/{path=**}", "/files/a/b/c", true, map[string]string{"path": "a/b/c"}},
+		{"/users/{userName}:verify", "/users/tommy:verify", true, map[string]string{"userName": "tommy"}},
+		{"/users/{userName}:verify", "/users/tommy", false, nil},
+		{"/users/*/session", "/users/tommy/session", true, map[string]string{}},
+	}
+	for _, c := range cases {
+		tpl, err := parseTemplate(c.template)
+		if err != nil {
+			t.Fatalf("%s: %s", c.template, err)
+		}
+		vars, _, ok := tpl.match(c.path)
+		if ok != c.ok || (ok && !reflect.DeepEqual(vars, c.vars)) {
+			t.Errorf("%s %s: expect %v %v, got %v %v", c.template, c.path, c.ok, c.vars, ok, vars)
+		}
+	}
+	for _, bad := range []string{"users", "/users/{", "/users/{}", "/a/**/b", "/a//b", "/users/{id=}"} {
+		if _, err := parseTemplate(bad); err == nil {
+			t.Errorf("%s should be invalid", bad)
+		}
+	}
+}
+
+func newTestGateway(t *testing.T) (*HTTPGateWay, *clienttest.MockClient) {
+	mock := clienttest.NewMockClient()
+	mock.Register(&util.ClientInfo{InterfaceList: []*util.InterfaceInfo{
+		{Name: "/login.loginService/login", ReqType: "login.loginRequest", RspType: "login.loginReply"},
+		{Name: "/login.loginService/logout", ReqType: "login.logoutRequest", RspType: "login.logoutReply"},
+	}})
+	mock.Handle("/login.loginService/login", func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(*login.LoginRequest)
+		return &login.LoginReply{SessionId: req.GetUserName() + ":" + req.GetPassword()}, nil
+	})
+	mock.Handle("/login.loginService/logout", func(ctx context.Context, request interface{}) (interface{}, error) {
+		return &login.LogoutReply{Err: "bye " + request.(*login.LogoutRequest).GetSessionId()}, nil
+	})
+	gw := NewHTTPGateway()
+	gw.SetLogger(log.NewNopLogger())
+	gw.SetClient(mock)
+	return gw, mock
+}
+
+func serve(gw *HTTPGateWay, method, target, body string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if len(body) > 0 {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestRESTRoutes(t *testing.T) {
+	gw, _ := newTestGateway(t)
+	err := gw.Register([]*util.HTTPGateWayInfo{
+		{Name: "/users/{userName}/session", Verb: "GET", Method: "/login.loginService/login"},
+		{Name: "/users/{userName}/session", Verb: "POST", Method: "/login.loginService/login", Body: "*"},
+		{Name: "/users/me/session", Verb: "GET", Method: "/login.loginService/logout"},
+		{Name: "/sessions/{sessionId}", Verb: "delete", Method: "/login.loginService/logout"},
+		{Name: "/login", Method: "/login.loginService/login"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		method, target, body string
+		code                 int
+		expect               string
+	}{
+		{"GET", "/users/tommy/session?password=p1&unknown=1", "", 200, `"sessionId":"tommy:p1"`},
+		//路径变量优先于body
+		{"POST", "/users/tommy/session?password=ignored", `{"userName":"alice","password":"p2"}`, 200, `"sessionId":"tommy:p2"`},
+		//字面量多的路由优先
+		{"GET", "/users/me/session", "", 200, `"err":"bye "`},
+		{"DELETE", "/sessions/s1", "", 200, `"err":"bye s1"`},
+		{"PUT", "/sessions/s1", "", 405, ""},
+		{"GET", "/login?userName=bob", "", 200, `"sessionId":"bob:"`},
+		{"GET", "/nothing", "", 404, ""},
+	}
+	for _, c := range cases {
+		w := serve(gw, c.method, c.target, c.body)
+		if w.Code != c.code || !strings.Contains(w.Body.String(), c.expect) {
+			t.Errorf("%s %s: expect %d %s, got %d %s", c.method, c.target, c.code, c.expect, w.Code, w.Body)
+		}
+	}
+	if w := serve(gw, "PUT", "/sessions/s1", ""); w.Header().Get("Allow") != "DELETE" {
+		t.Errorf("unexpected Allow %s", w.Header().Get("Allow"))
+	}
+
+	routes := gw.Routes()
+	if len(routes) != 5 || routes[0].Name != "/login" || routes[1].Name != "DELETE /sessions/{sessionId}" {
+		b, _ := json.Marshal(routes)
+		t.Errorf("unexpected routes %s", b)
+	}
+	gw.SetRouteEnabled("DELETE /sessions/{sessionId}", false)
+	if w := serve(gw, "DELETE", "/sessions/s1", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expect 503, got %d", w.Code)
+	}
+}
+
+func TestRouteErrors(t *testing.T) {
+	gw, _ := newTestGateway(t)
+	for _, info := range []*util.HTTPGateWayInfo{
+		{Name: "/users/{missing}", Verb: "GET", Method: "/login.loginService/login"},
+		{Name: "/users", Verb: "POST", Method: "/login.loginService/login", Body: "missing"},
+		{Name: "users", Verb: "GET", Method: "/login.loginService/login"},
+	} {
+		if err := gw.Register([]*util.HTTPGateWayInfo{info}); err == nil {
+			t.Errorf("%s %s body=%s should fail", info.Verb, info.Name, info.Body)
+		}
+	}
+	gw.Register([]*util.HTTPGateWayInfo{{Name: "/users", Verb: "GET", Method: "/login.loginService/login"}})
+	if err := gw.Register([]*util.HTTPGateWayInfo{{Name: "/users", Verb: "GET", Method: "/login.loginService/login"}}); err == nil {
+		t.Error("duplicate route should fail")
+	}
+}
+
+//按protobuf编码构造MethodOptions
+func field(num int, data string) string {
+	key := uint64(num)<<3 | 2
+	var b []byte
+	for _, v := range []uint64{key, uint64(len(data))} {
+		for v >= 0x80 {
+			b = append(b, byte(v)|0x80)
+			v >>= 7
+		}
+		b = append(b, byte(v))
+	}
+	return string(b) + data
+}
+
+func TestHTTPRules(t *testing.T) {
+	rule := field(2, "/users/{userName}/session") +
+		field(11, field(4, "/users/{userName}/session")+field(7, "*")) +
+		field(11, field(8, field(1, "HEAD")+field(2, "/users/{userName}")))
+	//其他MethodOptions字段: deprecated=true(varint)
+	options := "\x88\x02\x01" + field(httpRuleField, rule)
+	rules, err := httpRules([]byte(options))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []httpRule{
+		{"GET", "/users/{userName}/session", ""},
+		{"POST", "/users/{userName}/session", "*"},
+		{"HEAD", "/users/{userName}", ""},
+	}
+	if len(rules) != len(expect) {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+	for i, r := range rules {
+		if *r != expect[i] {
+			t.Errorf("rule %d: expect %v, got %v", i, expect[i], *r)
+		}
+	}
+	if rules, err := httpRules([]byte("\x88\x02\x01")); err != nil || len(rules) != 0 {
+		t.Errorf("expect no rules, got %v %v", rules, err)
+	}
+	if _, err := httpRules([]byte(field(httpRuleField, field(7, "*")))); err == nil {
+		t.Error("rule without pattern should fail")
+	}
+	//loginService.proto没有注解
+	gw, _ := newTestGateway(t)
+	if err := gw.RegisterByProto("loginService.proto"); err != nil || len(gw.Routes()) != 0 {
+		t.Errorf("unexpected %v %v", err, gw.Routes())
+	}
+}
+
+type codecMessage struct {
+	Count int32           `protobuf:"varint,1,opt,name=count" json:"count,omitempty"`
+	Flags []bool          `protobuf:"varint,2,rep,packed,name=flags" json:"flags,omitempty"`
+	Big   int64           `protobuf:"varint,3,opt,name=big_num,json=bigNum" json:"big_num,omitempty"`
+	Ratio float64         `protobuf:"fixed64,4,opt,name=ratio" json:"ratio,omitempty"`
+	Inner *codecMessage   `protobuf:"bytes,5,opt,name=inner" json:"inner,omitempty"`
+	Tags  map[string]bool `protobuf:"bytes,6,rep,name=tags" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+}
+
+func (m *codecMessage) Reset()         { *m = codecMessage{} }
+func (m *codecMessage) String() string { return fmt.Sprintf("%+v", *m) }
+func (*codecMessage) ProtoMessage()    {}
+
+func TestUnmarshalRequest(t *testing.T) {
+	msg := new(codecMessage)
+	err := unmarshalRequest(map[string]interface{}{
+		"count":       []string{"1", "2"},
+		"flags":       "true",
+		"bigNum":      "9007199254740993",
+		"ratio":       "0.5",
+		"inner":       map[string]interface{}{"count": "3", "flags": []string{"true", "false"}},
+		"inner.ratio": "1e3",
+		"unknown":     "x",
+	}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Count != 2 || !reflect.DeepEqual(msg.Flags, []bool{true}) || msg.Big != 9007199254740993 || msg.Ratio != 0.5 ||
+		msg.Inner == nil || msg.Inner.Count != 3 || msg.Inner.Ratio != 1000 || !reflect.DeepEqual(msg.Inner.Flags, []bool{true, false}) {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	for _, m := range []map[string]interface{}{
+		{"count": "abc"},
+		{"count": "4294967296"},
+		{"big_num": "1.5"},
+	} {
+		if err := unmarshalRequest(m, new(codecMessage)); err == nil {
+			t.Errorf("%v should fail", m)
+		}
+	}
+}
+
+func TestDecodeJSONBody(t *testing.T) {
+	decode := func(ct, body string) (map[string]interface{}, error) {
+		r := httptest.NewRequest("POST", "/codec", strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		request, err := decodePOSTRequest(context.Background(), r)
+		if err != nil {
+			return nil, err
+		}
+		return request.(map[string]interface{}), nil
+	}
+	//带charset的json, int64不能按float64丢失精度
+	m, err := decode("application/json; charset=utf-8", `{"bigNum":9007199254740993,"count":2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(codecMessage)
+	if err := unmarshalRequest(m, msg); err != nil || msg.Big != 9007199254740993 || msg.Count != 2 {
+		t.Errorf("unexpected message %+v %v", msg, err)
+	}
+	for _, body := range []string{`{"bigNum":`, `{"count":1} x`} {
+		if _, err := decode("application/json", body); err == nil {
+			t.Errorf("%s should fail", body)
+		}
+	}
+	gw, _ := newTestGateway(t)
+	gw.Register([]*util.HTTPGateWayInfo{{Name: "/login", Method: "/login.loginService/login"}})
+	if w := serve(gw, "POST", "/login", `{"userName":`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":3`) {
+		t.Errorf("malformed json should be InvalidArgument, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestJSONOptions(t *testing.T) {
+	gw := NewHTTPGateway()
+	msg := &codecMessage{Big: 1}
+	s, _ := gw.marshaler().MarshalToString(msg)
+	if s != `{"bigNum":"1"}` {
+		t.Errorf("unexpected camelCase json %s", s)
+	}
+	if err := gw.SetOptions(FieldNaming(NAMING_ORIGINAL), EmitDefaults(true)); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = gw.marshaler().MarshalToString(msg)
+	if !strings.Contains(s, `"big_num":"1"`) || !strings.Contains(s, `"count":0`) {
+		t.Errorf("unexpected original json %s", s)
+	}
+	if err := gw.SetOptions(FieldNaming("snake")); err == nil {
+		t.Error("unsupported naming should fail")
+	}
+	for _, info := range []*util.HTTPJSONInfo{{Naming: "snake"}, {EmitDefaults: "yes!"}} {
+		if _, err := OptionsByConfig(info); err == nil {
+			t.Errorf("%+v should fail", info)
+		}
+	}
+	if opts, err := OptionsByConfig(&util.HTTPJSONInfo{Naming: NAMING_ORIGINAL, EmitDefaults: "true"}); err != nil || len(opts) != 2 {
+		t.Errorf("unexpected options %d %v", len(opts), err)
+	}
+
+	//通过网关: 响应输出默认值
+	gw, mock := newTestGateway(t)
+	gw.Register([]*util.HTTPGateWayInfo{{Name: "/sessions/{sessionId}", Verb: "DELETE", Method: "/login.loginService/logout"}})
+	gw.SetOptions(EmitDefaults(true))
+	mock.Return("/login.loginService/logout", &login.LogoutReply{})
+	if w := serve(gw, "DELETE", "/sessions/s1", ""); w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"err":""}` {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	gw, mock := newTestGateway(t)
+	gw.Register([]*util.HTTPGateWayInfo{
+		{Name: "/users/{userName}/session", Verb: "POST", Method: "/login.loginService/login", Body: "*"},
+		{Name: "/sessions/{sessionId}", Verb: "DELETE", Method: "/login.loginService/logout"},
+		{Name: "/appInfo", Method: "/common.commonService/appInfo"},
+	})
+	verr := &validate.Error{Violations: []*validate.FieldViolation{{Field: "userName", Description: "is required"}}}
+	cases := []struct {
+		method, target, body string
+		err                  error
+		code                 int
+		expect               string
+	}{
+		{"GET", "/nothing", "", nil, 404, `"code":5`},
+		{"PUT", "/sessions/s1", "", nil, 405, `"code":12`},
+		{"GET", "/appInfo", "", nil, 404, `"message":"can not find method /common.commonService/appInfo"`},
+		{"POST", "/users/tommy/session", "{", nil, 400, `"code":3`},
+		{"DELETE", "/sessions/s1", "", status.Error(codes.NotFound, "no session"), 404, `"message":"no session"`},
+		{"DELETE", "/sessions/s1", "", status.Error(codes.Unavailable, "down"), 503, `"code":14`},
+		{"DELETE", "/sessions/s1", "", errors.New("boom"), 500, `"code":2`},
+		{"DELETE", "/sessions/s1", "", gobreaker.ErrOpenState, 503, `"code":14`},
+		{"DELETE", "/sessions/s1", "", ratelimit.ErrLimited, 429, `"code":8`},
+		{"DELETE", "/sessions/s1", "", verr.Status().Err(), 400, `"details":[{"field":"userName","description":"is required"}]`},
+	}
+	for _, c := range cases {
+		if c.err != nil {
+			mock.ReturnError("/login.loginService/logout", c.err)
+		}
+		w := serve(gw, c.method, c.target, c.body)
+		body := new(ErrorBody)
+		json.Unmarshal(w.Body.Bytes(), body)
+		if w.Code != c.code || !strings.Contains(w.Body.String(), c.expect) || len(body.FlowID) == 0 {
+			t.Errorf("%s %s: expect %d %s, got %d %s", c.method, c.target, c.code, c.expect, w.Code, w.Body)
+		}
+	}
+
+	//自定义错误格式和成功的包装
+	gw.SetOptions(Envelope(true), ErrorFormat(func(ctx context.Context, body *ErrorBody) interface{} {
+		return map[string]interface{}{"errno": body.Code, "msg": body.Message}
+	}))
+	if w := serve(gw, "DELETE", "/sessions/s1", ""); w.Code != 400 || strings.TrimSpace(w.Body.String()) != `{"errno":3,"msg":"invalid argument: userName is required"}` {
+		t.Errorf("unexpected error %d %s", w.Code, w.Body)
+	}
+	mock.Return("/login.loginService/logout", &login.LogoutReply{Err: "bye"})
+	if w := serve(gw, "DELETE", "/sessions/s1", ""); w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"code":0,"data":{"err":"bye"}}` {
+		t.Errorf("unexpected envelope %d %s", w.Code, w.Body)
+	}
+	if HTTPStatusFromCode(codes.Unauthenticated) != 401 || HTTPStatusFromCode(codes.ResourceExhausted) != 429 {
+		t.Error("unexpected http status")
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	gw, _ := newTestGateway(t)
+	gw.Register([]*util.HTTPGateWayInfo{
+		{Name: "/users/{userName}/session", Verb: "GET", Method: "/login.loginService/login"},
+		{Name: "/users/{userName}/session", Verb: "POST", Method: "/login.loginService/login", Body: "*"},
+		{Name: "/login", Method: "/login.loginService/login"},
+	})
+	w := serve(gw, "GET", OPENAPI_PATH, "")
+	if w.Code != 200 {
+		t.Fatalf("unexpected code %d", w.Code)
+	}
+	doc := new(OpenAPI)
+	if err := json.Unmarshal(w.Body.Bytes(), doc); err != nil {
+		t.Fatal(err)
+	}
+	get := doc.Paths["/users/{userName}/session"]["get"]
+	if get == nil || len(get.Parameters) != 2 || get.Parameters[0].In != "path" || get.Parameters[1].Name != "password" || get.RequestBody != nil {
+		t.Errorf("unexpected get %s", w.Body)
+	}
+	if post := doc.Paths["/users/{userName}/session"]["post"]; post == nil || post.RequestBody == nil {
+		t.Errorf("unexpected post %s", w.Body)
+	}
+	if doc.Paths["/login"]["post"] == nil || doc.Info["title"] != "API" {
+		t.Errorf("unexpected doc %s", w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"login.loginRequest":{"properties":{"password":{"type":"string"},"userName":{"type":"string"}},"type":"object"}`) {
+		t.Errorf("unexpected schemas %s", w.Body)
+	}
+	if w := serve(gw, "GET", "/docs", ""); w.Code != 404 {
+		t.Errorf("docs page should be disabled, got %d", w.Code)
+	}
+	gw.SetOptions(DocsUI("/docs"), DocsInfo("login", "2.0"))
+	if w := serve(gw, "GET", "/docs", ""); w.Code != 200 || !strings.Contains(w.Body.String(), OPENAPI_PATH) {
+		t.Errorf("unexpected docs page %d", w.Code)
+	}
+	if info := gw.OpenAPI().Info; info["title"] != "login" || info["version"] != "2.0" {
+		t.Errorf("unexpected info %v", info)
+	}
+
+	template, _ := parseTemplate("/v1/{name=users/*}/files/{path=**}:download")
+	if path := openAPIPath(template); path != "/v1/{name}/files/{path}:download" {
+		t.Errorf("unexpected path %s", path)
+	}
+	//多段的路径变量说明实际格式
+	name, path := pathParameter(template, template.vars[0]), pathParameter(template, template.vars[1])
+	if name.Schema.(map[string]string)["pattern"] != "^users/[^/]+$" || len(name.Description) == 0 || path.Schema.(map[string]string)["pattern"] != "^.+$" {
+		t.Errorf("unexpected parameters %+v %+v", name, path)
+	}
+	if get.Parameters[0].Description != "" {
+		t.Errorf("single segment parameter should not have description %+v", get.Parameters[0])
+	}
+
+	//文档需要认证或关闭
+	auth.RegisterByConfig(&util.AuthInfo{Name: "docs-key", Type: auth.TYPE_APIKEY, KeyList: []*util.AuthKey{{ID: "dev", Value: "k1"}}})
+	gw.SetOptions(DocsAuth([]string{"docs-key"}))
+	if w := serve(gw, "GET", OPENAPI_PATH, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("docs without key should be rejected, got %d", w.Code)
+	}
+	r := httptest.NewRequest("GET", "/docs", nil)
+	r.Header.Set("X-Api-Key", "k1")
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Errorf("docs with key should pass, got %d", w.Code)
+	}
+	gw.SetOptions(DocsEnabled(false))
+	if w := serve(gw, "GET", OPENAPI_PATH, ""); w.Code != 404 {
+		t.Errorf("disabled docs should return 404, got %d", w.Code)
+	}
+	builder := &openAPIBuilder{schemas: make(map[string]interface{})}
+	builder.messageSchema(reflect.TypeOf(&codecMessage{}))
+	b, _ := json.Marshal(builder.schemas)
+	for _, expect := range []string{`"bigNum":{"format":"int64","type":"string"}`, `"flags":{"items":{"type":"boolean"},"type":"array"}`,
+		`"inner":{"$ref":"#/components/schemas/`, `"tags":{"additionalProperties":{"type":"boolean"},"type":"object"}`} {
+		if !strings.Contains(string(b), expect) {
+			t.Errorf("%s not in %s", expect, b)
+		}
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	gw, _ := newTestGateway(t)
+	opts, err := SecurityOptionsByConfig([]*util.CORSInfo{
+		{Origins: "https://web.example.com, *.example.org", Headers: "Content-Type", Credentials: "true", MaxAge: "10m"},
+		{Name: "public", Origins: "*", Methods: "GET"},
+	}, &util.HTTPSecurityInfo{MaxBody: "32", Headers: []*util.HeaderInfo{{Name: "X-Frame-Options"}, {Name: "Cache-Control", Value: "no-store"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw.SetOptions(opts...)
+	err = gw.Register([]*util.HTTPGateWayInfo{
+		{Name: "/users/{userName}/session", Verb: "POST", Method: "/login.loginService/login", Body: "*"},
+		{Name: "/sessions/{sessionId}", Verb: "DELETE", Method: "/login.loginService/logout", CORS: "public"},
+		{Name: "/login", Method: "/login.loginService/login", MaxBody: "1KB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("GET", "/nothing", "", nil)
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Cache-Control") != "no-store" || len(w.Header().Get("X-Frame-Options")) > 0 {
+		t.Errorf("unexpected security headers %v", w.Header())
+	}
+	w = request("POST", "/users/tommy/session", `{}`, map[string]string{"Origin": "https://web.example.com"})
+	if w.Code != 200 || w.Header().Get("Access-Control-Allow-Origin") != "https://web.example.com" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("unexpected cors %d %v", w.Code, w.Header())
+	}
+	w = request("POST", "/users/tommy/session", `{}`, map[string]string{"Origin": "https://evil.com"})
+	if w.Code != 200 || len(w.Header().Get("Access-Control-Allow-Origin")) > 0 {
+		t.Errorf("unexpected cors %d %v", w.Code, w.Header())
+	}
+	preflight := map[string]string{"Origin": "https://a.example.org", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "content-type"}
+	w = request("OPTIONS", "/users/tommy/session", "", preflight)
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "https://a.example.org" || w.Header().Get("Access-Control-Allow-Methods") != "POST" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "Content-Type" || w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("unexpected preflight %d %v", w.Code, w.Header())
+	}
+	preflight["Access-Control-Request-Method"] = "DELETE"
+	if w = request("OPTIONS", "/sessions/s1", "", preflight); w.Code != 403 {
+		t.Errorf("method not in policy, got %d %v", w.Code, w.Header())
+	}
+	preflight["Origin"], preflight["Access-Control-Request-Method"] = "https://evil.com", "POST"
+	if w = request("OPTIONS", "/users/tommy/session", "", preflight); w.Code != 403 {
+		t.Errorf("origin not allowed, got %d", w.Code)
+	}
+	if w = request("DELETE", "/sessions/s1", "", map[string]string{"Origin": "https://evil.com"}); w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("unexpected public cors %v", w.Header())
+	}
+	//子域名也要匹配scheme
+	if w = request("POST", "/users/tommy/session", `{}`, map[string]string{"Origin": "http://a.example.org"}); len(w.Header().Get("Access-Control-Allow-Origin")) > 0 {
+		t.Errorf("http origin should not match https subdomain, got %v", w.Header())
+	}
+	//任意Origin时不回显Origin, 也不带cookie
+	gw.SetOptions(CORS("any", &CORSPolicy{Origins: []string{"*"}, Credentials: true}))
+	if err := gw.SetRouteCORS("DELETE /sessions/{sessionId}", "any"); err != nil {
+		t.Fatal(err)
+	}
+	w = request("DELETE", "/sessions/s1", "", map[string]string{"Origin": "https://evil.com"})
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || len(w.Header().Get("Access-Control-Allow-Credentials")) > 0 {
+		t.Errorf("wildcard origin should not allow credentials, got %v", w.Header())
+	}
+	if err := gw.SetRouteCORS("DELETE /sessions/{sessionId}", "unknown"); err == nil {
+		t.Error("unknown cors policy should fail")
+	}
+	if err := gw.Register([]*util.HTTPGateWayInfo{{Name: "/logout", Method: "/login.loginService/logout", CORS: "unknown"}}); err == nil {
+		t.Error("interface with unknown cors policy should fail")
+	}
+
+	large := `{"userName":"` + strings.Repeat("a", 64) + `"}`
+	if w = request("POST", "/users/tommy/session", large, nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expect 413, got %d %s", w.Code, w.Body)
+	}
+	//没有Content-Length
+	r := httptest.NewRequest("POST", "/users/tommy/session", ioutil.NopCloser(strings.NewReader(large)))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":8`) {
+		t.Errorf("expect 413, got %d %s", w.Code, w.Body)
+	}
+	if w = request("POST", "/login", large, nil); w.Code != 200 {
+		t.Errorf("route max-body not applied, got %d %s", w.Code, w.Body)
+	}
+
+	for _, c := range []struct {
+		cors     []*util.CORSInfo
+		security *util.HTTPSecurityInfo
+	}{
+		{[]*util.CORSInfo{{Name: "x"}}, nil},
+		{[]*util.CORSInfo{{Origins: "*", MaxAge: "10"}}, nil},
+		{[]*util.CORSInfo{{Origins: "*", Credentials: "maybe"}}, nil},
+		{[]*util.CORSInfo{{Origins: "*", Credentials: "true"}}, nil},
+		{nil, &util.HTTPSecurityInfo{MaxBody: "-1"}},
+		{nil, &util.HTTPSecurityInfo{Headers: []*util.HeaderInfo{{Value: "x"}}}},
+	} {
+		if _, err := SecurityOptionsByConfig(c.cors, c.security); err == nil {
+			t.Errorf("%+v %+v should fail", c.cors, c.security)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	auth.RegisterByConfig(&util.AuthInfo{Name: "gw-key", Type: auth.TYPE_APIKEY, Query: "api_key", KeyList: []*util.AuthKey{{ID: "web", Roles: "user", Value: "k1"}}})
+	auth.RegisterByConfig(&util.AuthInfo{Name: "gw-sign", Type: auth.TYPE_SIGN, Alg: "md5", KeyList: []*util.AuthKey{{ID: "app1", Value: "secret"}}})
+	gw, mock := newTestGateway(t)
+	var principal *auth.Principal
+	mock.Handle("/login.loginService/login", func(ctx context.Context, request interface{}) (interface{}, error) {
+		principal, _ = auth.FromContext(ctx)
+		return &login.LoginReply{}, nil
+	})
+	err := gw.Register([]*util.HTTPGateWayInfo{
+		{Name: "/users/{userName}/session", Verb: "POST", Method: "/login.loginService/login", Body: "*", Auth: "gw-key, gw-sign"},
+		{Name: "/login", Method: "/login.loginService/login"},
+		{Name: "/secure", Method: "/login.loginService/logout", Auth: "gw-key"},
+		{Name: "/sessions/{sessionId}", Verb: "DELETE", Method: "/login.loginService/logout", Auth: "gw-key"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Register([]*util.HTTPGateWayInfo{{Name: "/logout", Method: "/login.loginService/logout", Auth: "missing"}}); err == nil {
+		t.Error("unknown auth should fail")
+	}
+
+	if w := serve(gw, "POST", "/users/tommy/session", `{}`); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"code":16`) {
+		t.Errorf("expect 401, got %d %s", w.Code, w.Body)
+	}
+	if w := serve(gw, "POST", "/users/tommy/session?api_key=bad", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expect 401, got %d %s", w.Code, w.Body)
+	}
+	if w := serve(gw, "POST", "/users/tommy/session?api_key=k1", `{}`); w.Code != 200 || principal == nil || principal.ID != "web" || !principal.HasRole("user") {
+		t.Errorf("unexpected result %d %s %+v", w.Code, w.Body, principal)
+	}
+	r := httptest.NewRequest("POST", "/users/tommy/session", strings.NewReader(`{"password":"p"}`))
+	r.Header.Set("Content-Type", "application/json")
+	auth.SignRequest(r, "app1", "secret", auth.SIGN_MD5)
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	if w.Code != 200 || principal == nil || principal.ID != "app1" || principal.Scheme != auth.TYPE_SIGN {
+		t.Errorf("unexpected result %d %s %+v", w.Code, w.Body, principal)
+	}
+	principal = nil
+	if w := serve(gw, "POST", "/login", `{}`); w.Code != 200 || principal != nil {
+		t.Errorf("route without auth: %d %+v", w.Code, principal)
+	}
+	//不能通过method参数绕过其他路由的认证
+	if w := serve(gw, "POST", "/login?method=/secure", `{"sessionId":"s1"}`); w.Code != 200 || strings.Contains(w.Body.String(), "bye") {
+		t.Errorf("method query should not select another route: %d %s", w.Code, w.Body)
+	}
+	if w := serve(gw, "POST", "/login", `{"method":"DELETE /sessions/{sessionId}","sessionId":"s1"}`); w.Code != 200 || strings.Contains(w.Body.String(), "bye") {
+		t.Errorf("method in body should not select another route: %d %s", w.Code, w.Body)
+	}
+	if _, _, err := gw.rpcRequest(map[string]interface{}{MethodName: 1.0}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid method should be InvalidArgument, got %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	auth.RegisterByConfig(&util.AuthInfo{Name: "rl-key", Type: auth.TYPE_APIKEY, KeyList: []*util.AuthKey{{ID: "web", Value: "k1"}, {ID: "app", Value: "k2"}}})
+	gw, mock := newTestGateway(t)
+	mock.Handle("/login.loginService/login", func(ctx context.Context, request interface{}) (interface{}, error) {
+		return &login.LoginReply{}, nil
+	})
+	err := gw.Register([]*util.HTTPGateWayInfo{
+		{Name: "/login", Method: "/login.loginService/login", Auth: "rl-key", RateLimitList: []*util.RateLimitInfo{{By: "key", Limit: "2", Window: "1h"}}},
+		{Name: "/ip", Method: "/login.loginService/login", RateLimitList: []*util.RateLimitInfo{{By: "ip", Limit: "1", Window: "1h"}, {Limit: "10", Window: "1h"}}},
+		{Name: "/refund", Method: "/login.loginService/login", RateLimitList: []*util.RateLimitInfo{{Limit: "2", Window: "1h"}, {By: "ip", Limit: "1", Window: "1h"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Register([]*util.HTTPGateWayInfo{{Name: "/bad", Method: "/login.loginService/login", RateLimitList: []*util.RateLimitInfo{{By: "user", Limit: "1"}}}}); err == nil {
+		t.Error("invalid by should fail")
+	}
+	request := func(target, key, ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, strings.NewReader(`{}`))
+		r.Header.Set("x-api-key", key)
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(w, r)
+		return w
+	}
+	for i, remaining := range []string{"1", "0"} {
+		w := request("/login", "k1", "10.0.0.1")
+		if w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Errorf("request %d: %d %v", i, w.Code, w.Header())
+		}
+	}
+	w := request("/login", "k1", "10.0.0.2")
+	if w.Code != http.StatusTooManyRequests || len(w.Header().Get("Retry-After")) == 0 || !strings.Contains(w.Body.String(), `"code":8`) {
+		t.Errorf("expect 429, got %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	//另一个调用方单独计数
+	if w := request("/login", "k2", "10.0.0.1"); w.Code != 200 {
+		t.Errorf("other key: %d %s", w.Code, w.Body)
+	}
+
+	if w := request("/ip", "", "10.0.0.1"); w.Code != 200 || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("ip limit: %d %v", w.Code, w.Header())
+	}
+	if w := request("/ip", "", "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expect 429, got %d", w.Code)
+	}
+	if w := request("/ip", "", "10.0.0.2"); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Errorf("other ip: %d %v", w.Code, w.Header())
+	}
+
+	//被ip规则拒绝的请求退回路由规则的计数
+	for i, ip := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+		if w := request("/refund", "", ip); (w.Code == 200) != (i == 0 || i == 3) {
+			t.Errorf("refund request %d: %d %v", i, w.Code, w.Header())
+		}
+	}
+	if w := request("/refund", "", "10.0.0.3"); w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Errorf("route limit: %d %v", w.Code, w.Header())
+	}
+
+	//计数器太多时只删除过期的
+	limiter := NewMemoryRateLimiter()
+	limiter.max = 3
+	ctx := context.Background()
+	if result, _ := limiter.Allow(ctx, "live", 1, time.Hour); !result.Allowed {
+		t.Error("first request should be allowed")
+	}
+	for _, key := range []string{"a", "b"} {
+		limiter.Allow(ctx, key, 1, time.Millisecond)
+	}
+	time.Sleep(3 * time.Millisecond)
+	limiter.Allow(ctx, "c", 1, time.Hour)
+	if result, _ := limiter.Allow(ctx, "live", 1, time.Hour); result.Allowed || len(limiter.counters) != 2 {
+		t.Errorf("live counter should be kept, got %+v %d", result, len(limiter.counters))
+	}
+
+	//前一个窗口的计数按剩余时间加权
+	if result := slidingWindow(10, 0, 10, 0, time.Second); result.Allowed || result.RetryAfter != time.Nanosecond {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result := slidingWindow(10, 0, 10, 500*time.Millisecond, time.Second); !result.Allowed || result.Remaining != 4 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result := slidingWindow(0, 10, 10, 500*time.Millisecond, time.Second); result.Allowed || result.RetryAfter != 500*time.Millisecond+time.Nanosecond {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	//没有新请求时, 在elapsed+d计数的结果
+	allowAfter := func(prev, cur, limit int64, elapsed, d, window time.Duration) bool {
+		at := elapsed + d
+		if at >= window {
+			prev, cur, at = cur, 0, at-window
+		}
+		return slidingWindow(prev, cur, limit, at, window).Allowed
+	}
+	for _, c := range []struct {
+		prev, cur, limit int64
+		elapsed, window  time.Duration
+	}{
+		{10, 0, 10, 0, time.Second},
+		{10, 5, 10, 100 * time.Millisecond, time.Second},
+		{7, 2, 5, 300 * time.Millisecond, time.Second},
+		{100, 1, 10, 0, time.Minute},
+		{3, 9, 10, 600 * time.Millisecond, time.Second},
+		{0, 10, 10, 500 * time.Millisecond, time.Second},
+		{5, 20, 10, 0, time.Second},
+		{0, 1, 1, 999 * time.Millisecond, time.Second},
+	} {
+		result := slidingWindow(c.prev, c.cur, c.limit, c.elapsed, c.window)
+		if result.Allowed {
+			t.Errorf("%+v should be rejected", c)
+			continue
+		}
+		if !allowAfter(c.prev, c.cur, c.limit, c.elapsed, result.RetryAfter, c.window) {
+			t.Errorf("%+v: still rejected after %v", c, result.RetryAfter)
+		}
+		if allowAfter(c.prev, c.cur, c.limit, c.elapsed, result.RetryAfter-time.Nanosecond, c.window) {
+			t.Errorf("%+v: allowed before %v", c, result.RetryAfter)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	gw, mock := newTestGateway(t)
+	var flowID string
+	mock.Handle("/login.loginService/login", func(ctx context.Context, request interface{}) (interface{}, error) {
+		logInfo, _ := logHelper.FromContext(ctx)
+		flowID = logInfo.FlowID
+		return &login.LoginReply{}, nil
+	})
+	if err := gw.Register([]*util.HTTPGateWayInfo{{Name: "/login", Method: "/login.loginService/login"}}); err != nil {
+		t.Fatal(err)
+	}
+	request := func(target, id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, strings.NewReader(`{}`))
+		if len(id) > 0 {
+			r.Header.Set("X-Request-ID", id)
+		}
+		w := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(w, r)
+		return w
+	}
+	if w := request("/login", "req-1:abc"); w.Code != 200 || w.Header().Get("X-Request-ID") != "req-1:abc" || flowID != "req-1:abc" {
+		t.Errorf("unexpected result %d %v %s", w.Code, w.Header(), flowID)
+	}
+	for _, id := range []string{"", "bad id\nlevel=error", strings.Repeat("a", 129)} {
+		w := request("/login", id)
+		if got := w.Header().Get("X-Request-ID"); got == id || got != flowID || !validRequestID(got) {
+			t.Errorf("%q: unexpected request id %q, flowID %q", id, got, flowID)
+		}
+	}
+	w := request("/missing", "req-2")
+	var body ErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.FlowID != "req-2" || w.Header().Get("X-Request-ID") != "req-2" {
+		t.Errorf("unexpected error response %v %s", w.Header(), w.Body)
+	}
+
+	gw.SetOptions(RequestIDHeader("x-trace-no"))
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(`{}`))
+	r.Header.Set("X-Trace-No", "t1")
+	r.Header.Set("Origin", "https://web.example.com")
+	gw.SetOptions(CORS(DEFAULT_CORS, &CORSPolicy{Origins: []string{"*"}}))
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	if w.Header().Get("X-Trace-No") != "t1" || flowID != "t1" || w.Header().Get("Access-Control-Expose-Headers") != "X-Trace-No" {
+		t.Errorf("unexpected result %v %s", w.Header(), flowID)
+	}
+}
+
+func TestIdempotencyHeader(t *testing.T) {
+	mock := clienttest.NewMockClient()
+	mock.Register(&util.ClientInfo{InterfaceList: []*util.InterfaceInfo{
+		{Name: "/login.loginService/login", ReqType: "login.loginRequest", RspType: "login.loginReply", IdempotencyHeader: "X-Request-Key"},
+	}})
+	var key string
+	mock.Handle("/login.loginService/login", func(ctx context.Context, request interface{}) (interface{}, error) {
+		key = idempotency.KeyFromContext(ctx)
+		return &login.LoginReply{}, nil
+	})
+	gw := NewHTTPGateway()
+	gw.SetLogger(log.NewNopLogger())
+	gw.SetClient(mock)
+	if err := gw.Register([]*util.HTTPGateWayInfo{{Name: "/login", Method: "/login.loginService/login"}}); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(`{}`))
+	r.Header.Set("X-Idempotency-Key", "default")
+	r.Header.Set("X-Request-Key", "k1")
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	if w.Code != 200 || key != "k1" {
+		t.Errorf("unexpected result %d %q", w.Code, key)
+	}
+}
+
+type uploadMessage struct {
+	UserName       string   `protobuf:"bytes,1,opt,name=user_name,json=userName" json:"user_name,omitempty"`
+	Avatar         []byte   `protobuf:"bytes,2,opt,name=avatar" json:"avatar,omitempty"`
+	AvatarFilename string   `protobuf:"bytes,3,opt,name=avatar_filename,json=avatarFilename" json:"avatar_filename,omitempty"`
+	ContentType    string   `protobuf:"bytes,4,opt,name=content_type,json=contentType" json:"content_type,omitempty"`
+	Files          [][]byte `protobuf:"bytes,5,rep,name=files" json:"files,omitempty"`
+}
+
+func (m *uploadMessage) Reset()         { *m = uploadMessage{} }
+func (m *uploadMessage) String() string { return fmt.Sprintf("%+v", *m) }
+func (*uploadMessage) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*uploadMessage)(nil), "gateway.uploadMessage")
+}
+
+func multipartBody(t *testing.T, values map[string]string, files ...[3]string) (*bytes.Buffer, string) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for k, v := range values {
+		mw.WriteField(k, v)
+	}
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, file[0], file[1]))
+		header.Set("Content-Type", "image/png")
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(file[2]))
+	}
+	mw.Close()
+	return body, mw.FormDataContentType()
+}
+
+func TestMultipart(t *testing.T) {
+	gw, mock := newTestGateway(t)
+	mock.Register(&util.ClientInfo{InterfaceList: []*util.InterfaceInfo{
+		{Name: "/user.userService/upload", ReqType: "gateway.uploadMessage", RspType: "gateway.uploadMessage"},
+	}})
+	var got *uploadMessage
+	mock.Handle("/user.userService/upload", func(ctx context.Context, request interface{}) (interface{}, error) {
+		got = request.(*uploadMessage)
+		return &uploadMessage{}, nil
+	})
+	err := gw.Register([]*util.HTTPGateWayInfo{
+		{Name: "/upload", Method: "/user.userService/upload", MaxFile: "1KB"},
+		{Name: "/users/{userName}/avatar", Verb: "PUT", Method: "/user.userService/upload", Body: "*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//文件超过内存限制时写入临时文件
+	gw.SetOptions(MultipartMemory(16))
+	request := func(method, target string, body *bytes.Buffer, ct string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, body)
+		r.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	avatar := strings.Repeat("png", 100)
+	body, ct := multipartBody(t, map[string]string{"user_name": "tommy"}, [3]string{"avatar", "a.png", avatar})
+	if w := request("POST", "/upload", body, ct); w.Code != 200 || got == nil || got.UserName != "tommy" || string(got.Avatar) != avatar ||
+		got.AvatarFilename != "a.png" || got.ContentType != "image/png" {
+		t.Errorf("unexpected result %d %s %+v", w.Code, w.Body, got)
+	}
+	got = nil
+	body, ct = multipartBody(t, nil, [3]string{"files", "1.csv", "a,b"}, [3]string{"files", "2.csv", "c,d"})
+	if w := request("PUT", "/users/tommy/avatar", body, ct); w.Code != 200 || got == nil || got.UserName != "tommy" ||
+		!reflect.DeepEqual(got.Files, [][]byte{[]byte("a,b"), []byte("c,d")}) || len(got.ContentType) > 0 {
+		t.Errorf("unexpected result %d %s %+v", w.Code, w.Body, got)
+	}
+
+	body, ct = multipartBody(t, nil, [3]string{"avatar", "big.png", strings.Repeat("x", 2048)})
+	if w := request("POST", "/upload", body, ct); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expect 413, got %d %s", w.Code, w.Body)
+	}
+	//文件超过max-file时不再继续读取, 不会把整个文件写入临时文件
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, _ := mw.CreateFormFile("avatar", "huge.png")
+		chunk := []byte(strings.Repeat("x", 1024))
+		for i := 0; i < 1024; i++ {
+			if _, err := part.Write(chunk); err != nil {
+				return
+			}
+		}
+		mw.Close()
+		pw.Close()
+	}()
+	var read bytes.Buffer
+	r := httptest.NewRequest("POST", "/upload", io.TeeReader(pr, &read))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if w.Code != http.StatusRequestEntityTooLarge || read.Len() > 64*1024 {
+		t.Errorf("expect 413 without reading the whole file, got %d %s, read %d bytes", w.Code, w.Body, read.Len())
+	}
+	//日志中不记录文件内容
+	logged, _ := json.Marshal(logRequest(map[string]interface{}{"user_name": "tommy", "avatar": []byte(avatar), "files": []interface{}{[]byte("a,b")}}))
+	if string(logged) != `{"avatar":"[300 bytes]","files":["[3 bytes]"],"user_name":"tommy"}` {
+		t.Errorf("unexpected log %s", logged)
+	}
+	gw.SetRouteMaxBody("/upload", 512)
+	body, ct = multipartBody(t, nil, [3]string{"files", "1.csv", strings.Repeat("x", 400)}, [3]string{"files", "2.csv", strings.Repeat("x", 400)})
+	r = httptest.NewRequest("POST", "/upload", ioutil.NopCloser(body))
+	r.Header.Set("Content-Type", ct)
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expect 413, got %d %s", w.Code, w.Body)
+	}
+	if w := request("POST", "/upload", bytes.NewBufferString("x"), "multipart/form-data"); w.Code != http.StatusBadRequest {
+		t.Errorf("expect 400, got %d %s", w.Code, w.Body)
+	}
+}
+
+func newStreamGateway(t *testing.T) (*HTTPGateWay, chan error) {
+	gw, mock := newTestGateway(t)
+	mock.Register(&util.ClientInfo{InterfaceList: []*util.InterfaceInfo{
+		{Name: "/login.loginService/watch", ReqType: "login.loginRequest", RspType: "login.loginReply", Stream: client.STREAM_SERVER},
+		{Name: "/login.loginService/chat", ReqType: "login.loginRequest", RspType: "login.loginReply", Stream: client.STREAM_BIDI},
+	}})
+	//handler结束时的错误, 用于检查客户端断开时是否取消
+	done := make(chan error, 10)
+	mock.HandleStream("/login.loginService/watch", func(ctx context.Context, stream clienttest.ServerStream) error {
+		request, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		req := request.(*login.LoginRequest)
+		if req.GetPassword() == "fail" {
+			return status.Error(codes.Unavailable, "down")
+		}
+		for i := 0; i < 2; i++ {
+			if err := stream.Send(&login.LoginReply{SessionId: fmt.Sprintf("%s:%d", req.GetUserName(), i)}); err != nil {
+				done <- err
+				return err
+			}
+		}
+		if req.GetPassword() == "wait" {
+			<-ctx.Done()
+			done <- ctx.Err()
+			return ctx.Err()
+		}
+		if req.GetPassword() == "error" {
+			return status.Error(codes.Internal, "broken")
+		}
+		return nil
+	})
+	mock.HandleStream("/login.loginService/chat", func(ctx context.Context, stream clienttest.ServerStream) error {
+		for {
+			request, err := stream.Recv()
+			if err != nil {
+				done <- err
+				return err
+			}
+			req := request.(*login.LoginRequest)
+			if err := stream.Send(&login.LoginReply{SessionId: req.GetUserName() + ":" + req.GetPassword()}); err != nil {
+				done <- err
+				return err
+			}
+		}
+	})
+	err := gw.Register([]*util.HTTPGateWayInfo{
+		{Name: "/watch", Method: "/login.loginService/watch", Stream: STREAM_SSE},
+		{Name: "/users/{userName}/watch", Verb: "GET", Method: "/login.loginService/watch", Stream: STREAM_WEBSOCKET},
+		{Name: "/users/{userName}/chat", Verb: "GET", Method: "/login.loginService/chat", Stream: STREAM_WEBSOCKET},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gw, done
+}
+
+func TestSSE(t *testing.T) {
+	gw, done := newStreamGateway(t)
+	if err := gw.Register([]*util.HTTPGateWayInfo{{Name: "/bad", Method: "/login.loginService/watch", Stream: "grpc"}}); err == nil {
+		t.Error("invalid stream should fail")
+	}
+	w := serve(gw, "GET", "/watch?userName=tommy", "")
+	expect := "data: {\"sessionId\":\"tommy:0\"}\n\ndata: {\"sessionId\":\"tommy:1\"}\n\nevent: end\ndata: {}\n\n"
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/event-stream; charset=utf-8" || w.Body.String() != expect {
+		t.Errorf("unexpected response %d %v %q", w.Code, w.Header(), w.Body)
+	}
+	//第一个消息之前出错时按普通请求返回
+	if w := serve(gw, "GET", "/watch?userName=tommy&password=fail", ""); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"message":"down"`) {
+		t.Errorf("expect 503, got %d %s", w.Code, w.Body)
+	}
+	w = serve(gw, "GET", "/watch?userName=tommy&password=error", "")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "event: error\ndata: {\"code\":13,\"message\":\"broken\"") {
+		t.Errorf("expect error event, got %d %q", w.Code, w.Body)
+	}
+	//sse只支持服务端流
+	for _, method := range []string{"/login.loginService/login", "/login.loginService/chat"} {
+		if err := gw.Register([]*util.HTTPGateWayInfo{{Name: "/sse", Method: method, Stream: STREAM_SSE}}); err == nil {
+			t.Errorf("%s: sse should only support server stream", method)
+		}
+		if err := gw.RegisterStreamRoute("GET", "/sse/{userName}", method, "", STREAM_SSE); err == nil {
+			t.Errorf("%s: sse route should only support server stream", method)
+		}
+	}
+	//接口还没有注册时在请求时检查
+	gw.Register([]*util.HTTPGateWayInfo{{Name: "/later-sse", Method: "/login.loginService/later", Stream: STREAM_SSE}})
+	if w := serve(gw, "GET", "/later-sse", ""); w.Code != http.StatusNotImplemented {
+		t.Errorf("expect 501, got %d %s", w.Code, w.Body)
+	}
+
+	//客户端断开时取消调用
+	server := httptest.NewServer(gw.Handler())
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequest("GET", server.URL+"/watch?userName=tommy&password=wait", nil)
+	rsp, err := http.DefaultClient.Do(r.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(rsp.Body).ReadString('\n')
+	if line != "data: {\"sessionId\":\"tommy:0\"}\n" {
+		t.Errorf("unexpected line %q", line)
+	}
+	cancel()
+	rsp.Body.Close()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expect canceled, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("stream was not canceled")
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	gw, done := newStreamGateway(t)
+	server := httptest.NewServer(gw.Handler())
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	//服务端流: 路径变量和query参数是入参
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/users/tommy/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, data, err := conn.ReadMessage()
+		if err != nil || string(data) != fmt.Sprintf(`{"sessionId":"tommy:%d"}`, i) {
+			t.Errorf("unexpected message %s %v", data, err)
+		}
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expect normal closure, got %v", err)
+	}
+	conn.Close()
+
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL+"/users/tommy/watch?password=error", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.ReadMessage()
+	conn.ReadMessage()
+	if _, data, _ := conn.ReadMessage(); !strings.Contains(string(data), `"code":13`) {
+		t.Errorf("expect error message, got %s", data)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseInternalServerErr) {
+		t.Errorf("expect 1011, got %v", err)
+	}
+	conn.Close()
+
+	//双向流: 每条消息是一个入参, 路径变量优先
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL+"/users/tommy/chat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ send, expect string }{
+		{`{"password":"p1"}`, `{"sessionId":"tommy:p1"}`},
+		{`{"userName":"alice","password":"p2"}`, `{"sessionId":"tommy:p2"}`},
+		{`not json`, `"code":3`},
+	} {
+		conn.WriteMessage(websocket.TextMessage, []byte(c.send))
+		if _, data, err := conn.ReadMessage(); err != nil || !strings.Contains(string(data), c.expect) {
+			t.Errorf("%s: unexpected message %s %v", c.send, data, err)
+		}
+	}
+	//浏览器断开时取消调用
+	conn.Close()
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.Canceled {
+			t.Errorf("expect canceled, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("stream was not canceled")
+	}
+
+	//客户端正常关闭时结束发送, 不取消调用
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL+"/users/tommy/chat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"password":"p1"}`))
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != `{"sessionId":"tommy:p1"}` {
+		t.Errorf("unexpected message %s %v", data, err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expect normal closure, got %v", err)
+	}
+	conn.Close()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("expect EOF, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("stream was not closed")
+	}
+
+	//跨域需要CORS策略允许, 不接受*
+	header := http.Header{"Origin": []string{"https://evil.example.com"}}
+	if _, rsp, err := websocket.DefaultDialer.Dial(wsURL+"/users/tommy/chat", header); err == nil || rsp == nil || rsp.StatusCode != http.StatusForbidden {
+		t.Errorf("expect 403, got %v", err)
+	}
+	gw.SetOptions(CORS(DEFAULT_CORS, &CORSPolicy{Origins: []string{"*"}}))
+	if _, rsp, err := websocket.DefaultDialer.Dial(wsURL+"/users/tommy/chat", header); err == nil || rsp == nil || rsp.StatusCode != http.StatusForbidden {
+		t.Errorf("wildcard policy should not allow websocket, got %v", err)
+	}
+	gw.SetOptions(CORS(DEFAULT_CORS, &CORSPolicy{Origins: []string{"*.example.com"}}))
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL+"/users/tommy/chat", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -1,7 +1,9 @@
 package gateway
 
 import (
+	"local/sndaRpc/client"
 	"local/sndaRpc/util"
+	"net/http"
 
 	"github.com/go-kit/kit/log"
 )
@@ -9,6 +11,18 @@ import (
 //GateWay 网关接口
 type GateWay interface {
 	SetLogger(lg log.Logger) error
+	SetOptions(opts ...Option) error
 	Register(infoList []*util.HTTPGateWayInfo) error
+	RegisterRoute(verb, path, method, body string) error
+	RegisterByProto(protoName string) error
 	Serve(addr string) error
+	Handler() http.Handler
+	Routes() []*RouteStatus
+	SetRouteEnabled(name string, enabled bool) error
+	OpenAPI() *OpenAPI
+}
+
+//ClientSetter 可以替换调用后端服务的客户端的网关, 通过类型断言使用
+type ClientSetter interface {
+	SetClient(clt client.Client) error
 }
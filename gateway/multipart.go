@@ -0,0 +1,251 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+)
+
+const (
+	//DEFAULT_MULTIPART_MEMORY 上传的文件超过该大小时先写入临时文件
+	DEFAULT_MULTIPART_MEMORY = 1024 * 1024
+
+	//文件对应的文件名和类型字段的后缀, 如 avatar 对应 avatar_filename, avatar_content_type
+	filenameSuffix    = "_filename"
+	contentTypeSuffix = "_content_type"
+)
+
+var errFileTooLarge = errors.New("upload file too large")
+
+//isTooLarge 请求body或上传的文件超过限制, 返回413
+func isTooLarge(err error) bool {
+	return err == errBodyTooLarge || err == errFileTooLarge
+}
+
+//MultipartMemory 解析multipart/form-data时文件在内存中保留的最大字节数, 超过的文件写入临时文件, 解析后删除
+func MultipartMemory(n int64) Option {
+	return func(opts *options) {
+		opts.multipartMemory = n
+	}
+}
+
+//SetRouteMaxFile 设置路由上传的每个文件的最大字节数, 0表示只受最大请求body限制
+func (me *HTTPGateWay) SetRouteMaxFile(name string, n int64) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.handlers[name]; !ok {
+		return fmt.Errorf("route %s not found", name)
+	}
+	if n <= 0 {
+		delete(me.routeMaxFile, name)
+	} else {
+		me.routeMaxFile[name] = n
+	}
+	return nil
+}
+
+//uploadLimit 解析上传文件的限制, 由limitHandler放入context
+type uploadLimit struct {
+	maxFile int64
+	memory  int64
+}
+
+type uploadLimitKey struct {
+}
+
+func (me *HTTPGateWay) uploadLimit(name string) *uploadLimit {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	limit := &uploadLimit{maxFile: me.routeMaxFile[name], memory: me.opts.multipartMemory}
+	if limit.memory <= 0 {
+		limit.memory = DEFAULT_MULTIPART_MEMORY
+	}
+	return limit
+}
+
+func uploadLimitFromContext(ctx context.Context) *uploadLimit {
+	if limit, ok := ctx.Value(uploadLimitKey{}).(*uploadLimit); ok {
+		return limit
+	}
+	return &uploadLimit{memory: DEFAULT_MULTIPART_MEMORY}
+}
+
+//isMultipart 请求是否为multipart/form-data
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+//decodeMultipart 解析multipart/form-data, 普通字段是字符串, 文件是[]byte, 按bytes字段的base64编码.
+//文件名和Content-Type放在 字段名_filename, 字段名_content_type 中, 只有一个文件时也放在 filename, content_type 中,
+//入参中没有这些字段时忽略. 边读边检查每个文件的大小, 超过内存限制的文件先写入临时文件, 读取后删除
+func decodeMultipart(ctx context.Context, r *http.Request) (map[string]interface{}, error) {
+	limit := uploadLimitFromContext(ctx)
+	form, err := readMultipart(r, limit)
+	if err != nil {
+		//multipart的错误会被包装, 按body是否超过限制判断
+		if body, ok := r.Body.(*limitedBody); ok && body.exceeded() {
+			return nil, errBodyTooLarge
+		}
+		if isTooLarge(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid multipart body: %s", err)
+	}
+	defer form.removeAll()
+	m := toMap(form.values)
+	var files int
+	for _, name := range form.names {
+		var (
+			contents           []interface{}
+			names, contentType []string
+		)
+		for _, file := range form.files[name] {
+			b, err := file.read()
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, b)
+			names = append(names, file.filename)
+			contentType = append(contentType, file.contentType)
+		}
+		files += len(form.files[name])
+		if len(contents) == 1 {
+			m[name] = contents[0]
+			m[name+filenameSuffix] = names[0]
+			m[name+contentTypeSuffix] = contentType[0]
+		} else {
+			m[name] = contents
+			m[name+filenameSuffix] = names
+			m[name+contentTypeSuffix] = contentType
+		}
+	}
+	if files == 1 {
+		name := form.names[0]
+		if _, ok := m["filename"]; !ok {
+			m["filename"] = m[name+filenameSuffix]
+		}
+		if _, ok := m["content_type"]; !ok {
+			m["content_type"] = m[name+contentTypeSuffix]
+		}
+	}
+	return m, nil
+}
+
+//uploadFile 上传的文件, 超过内存限制时内容在临时文件中
+type uploadFile struct {
+	filename    string
+	contentType string
+	content     []byte
+	tmpFile     string
+}
+
+func (me *uploadFile) read() ([]byte, error) {
+	if len(me.tmpFile) == 0 {
+		return me.content, nil
+	}
+	return ioutil.ReadFile(me.tmpFile)
+}
+
+//multipartForm 解析后的表单, names为文件字段按出现顺序排列
+type multipartForm struct {
+	values map[string][]string
+	names  []string
+	files  map[string][]*uploadFile
+}
+
+func (me *multipartForm) removeAll() {
+	for _, files := range me.files {
+		for _, file := range files {
+			if len(file.tmpFile) > 0 {
+				os.Remove(file.tmpFile)
+			}
+		}
+	}
+}
+
+//readMultipart 逐个读取part, 文件超过maxFile时立即返回errFileTooLarge, 不等整个文件写入临时文件.
+//与http.Request.ParseMultipartForm相同, 普通字段最多占用memory+10MB, 文件共用memory, 超过的写入临时文件
+func readMultipart(r *http.Request, limit *uploadLimit) (_ *multipartForm, err error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	form := &multipartForm{values: make(map[string][]string), files: make(map[string][]*uploadFile)}
+	defer func() {
+		if err != nil {
+			form.removeAll()
+		}
+	}()
+	memory, maxValue := limit.memory, limit.memory+10<<20
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := part.FormName()
+		if len(name) == 0 {
+			continue
+		}
+		if len(part.FileName()) == 0 {
+			var buf bytes.Buffer
+			n, err := io.CopyN(&buf, part, maxValue+1)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if maxValue -= n; maxValue < 0 {
+				return nil, errors.New("form values too large")
+			}
+			form.values[name] = append(form.values[name], buf.String())
+			continue
+		}
+		file := &uploadFile{filename: part.FileName(), contentType: part.Header.Get("Content-Type")}
+		if _, ok := form.files[name]; !ok {
+			form.names = append(form.names, name)
+		}
+		form.files[name] = append(form.files[name], file)
+		var content io.Reader = part
+		if limit.maxFile > 0 {
+			content = io.LimitReader(part, limit.maxFile+1)
+		}
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, content, memory+1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if n <= memory {
+			file.content = buf.Bytes()
+			memory -= n
+		} else {
+			if n, err = spoolFile(file, &buf, content); err != nil {
+				return nil, err
+			}
+		}
+		if limit.maxFile > 0 && n > limit.maxFile {
+			return nil, errFileTooLarge
+		}
+	}
+}
+
+//spoolFile 把已读取的内容和剩余部分写入临时文件, 返回文件大小
+func spoolFile(file *uploadFile, head *bytes.Buffer, rest io.Reader) (int64, error) {
+	f, err := ioutil.TempFile("", "multipart-")
+	if err != nil {
+		return 0, err
+	}
+	file.tmpFile = f.Name()
+	n, err := io.Copy(f, io.MultiReader(head, rest))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
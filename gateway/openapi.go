@@ -0,0 +1,490 @@
+package gateway
+
+import (
+	"encoding/json"
+	"local/sndaRpc/auth"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	//OPENAPI_PATH OpenAPI文档的路径
+	OPENAPI_PATH = "/openapi.json"
+)
+
+//DocsInfo OpenAPI文档的标题和版本
+func DocsInfo(title, version string) Option {
+	return func(opts *options) {
+		opts.docsTitle = title
+		opts.docsVersion = version
+	}
+}
+
+//DocsUI 文档页面的路径, 如 /docs, 为空时不提供页面
+func DocsUI(path string) Option {
+	return func(opts *options) {
+		opts.docsUI = path
+	}
+}
+
+//DocsEnabled 是否提供OpenAPI文档和文档页面, 默认提供. 不希望公开接口列表时关闭
+func DocsEnabled(enabled bool) Option {
+	return func(opts *options) {
+		opts.docsDisabled = !enabled
+	}
+}
+
+//DocsAuth 访问OpenAPI文档和文档页面的认证方式, names对应auth.Register的name, 任意一个通过即可, 为空时不认证
+func DocsAuth(names []string) Option {
+	return func(opts *options) {
+		opts.docsAuth = names
+	}
+}
+
+//OpenAPI OpenAPI 3文档
+type OpenAPI struct {
+	OpenAPI    string                            `json:"openapi"`
+	Info       map[string]string                 `json:"info"`
+	Paths      map[string]map[string]*operation  `json:"paths"`
+	Components map[string]map[string]interface{} `json:"components"`
+}
+
+type operation struct {
+	OperationID string                 `json:"operationId"`
+	Parameters  []*parameter           `json:"parameters,omitempty"`
+	RequestBody map[string]interface{} `json:"requestBody,omitempty"`
+	Responses   map[string]interface{} `json:"responses"`
+}
+
+type parameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      interface{} `json:"schema"`
+}
+
+//openAPIBuilder 生成文档时收集用到的message
+type openAPIBuilder struct {
+	orig    bool //字段名使用proto中的原始字段名
+	schemas map[string]interface{}
+}
+
+//OpenAPI 按已注册的路由和rpc的入参/出参类型生成文档. 客户端没有注册的接口不在文档中
+func (me *HTTPGateWay) OpenAPI() *OpenAPI {
+	me.mu.RLock()
+	opts := me.opts
+	handlers := make(map[string]string, len(me.handlers))
+	for name, method := range me.handlers {
+		handlers[name] = method
+	}
+	me.mu.RUnlock()
+	doc := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": opts.docsTitle, "version": opts.docsVersion},
+		Paths:   make(map[string]map[string]*operation),
+	}
+	if len(doc.Info["title"]) == 0 {
+		doc.Info["title"] = "API"
+	}
+	if len(doc.Info["version"]) == 0 {
+		doc.Info["version"] = "1.0"
+	}
+	builder := &openAPIBuilder{orig: opts.naming == NAMING_ORIGINAL, schemas: make(map[string]interface{})}
+	builder.schemas["Error"] = errorSchema()
+
+	me.router.mu.RLock()
+	routes := me.router.routes
+	me.router.mu.RUnlock()
+	for _, rt := range routes {
+		reqType, rspType, ok := me.messageTypes(rt.rpc)
+		if !ok {
+			continue
+		}
+		op := builder.operation(rt.key(), reqType, rspType, opts.envelope)
+		vars := make(map[string]bool)
+		for _, v := range rt.template.vars {
+			vars[v.field] = true
+			op.Parameters = append(op.Parameters, pathParameter(rt.template, v))
+		}
+		switch rt.body {
+		case "*":
+			op.RequestBody = builder.requestBody(reqType)
+		case "":
+			op.Parameters = append(op.Parameters, builder.queryParameters(reqType, vars)...)
+		default:
+			vars[rt.body] = true
+			if keys, err := jsonPath(reqType, rt.body); err == nil {
+				if field, ok := fieldByPath(reqType, keys); ok {
+					op.RequestBody = map[string]interface{}{"required": true, "content": jsonContent(builder.fieldSchema(field))}
+				}
+			}
+			op.Parameters = append(op.Parameters, builder.queryParameters(reqType, vars)...)
+		}
+		doc.addOperation(openAPIPath(rt.template), strings.ToLower(rt.verb), op)
+	}
+	//固定路径接受form或json body, 按POST描述
+	for name, method := range handlers {
+		if strings.Contains(name, " ") {
+			continue
+		}
+		reqType, rspType, ok := me.messageTypes(method)
+		if !ok {
+			continue
+		}
+		op := builder.operation(name, reqType, rspType, opts.envelope)
+		op.RequestBody = builder.requestBody(reqType)
+		doc.addOperation(name, "post", op)
+	}
+	doc.Components = map[string]map[string]interface{}{"schemas": builder.schemas}
+	return doc
+}
+
+func (me *OpenAPI) addOperation(path, verb string, op *operation) {
+	if me.Paths[path] == nil {
+		me.Paths[path] = make(map[string]*operation)
+	}
+	me.Paths[path][verb] = op
+}
+
+func (me *HTTPGateWay) messageTypes(rpc string) (reflect.Type, reflect.Type, bool) {
+	info := me.client.InterfaceInfo(rpc)
+	if info == nil {
+		return nil, nil, false
+	}
+	reqType, rspType := proto.MessageType(info.ReqType), proto.MessageType(info.RspType)
+	return reqType, rspType, reqType != nil && rspType != nil
+}
+
+//openAPIPath 路径模板转成OpenAPI的路径, {name=users/*}写成{name}
+//pathParameter 路径变量对应的参数. 匹配多段的变量, 如 {name=users/*}, 值中包含/,
+//OpenAPI的路径参数只能是一段, 用pattern和description说明实际的格式
+func pathParameter(t *pathTemplate, v pathVar) *parameter {
+	param := &parameter{Name: v.field, In: "path", Required: true}
+	end := v.end
+	if end < 0 {
+		end = len(t.segments)
+	}
+	segments := t.segments[v.start:end]
+	if len(segments) == 1 && segments[0].kind == segWild {
+		param.Schema = map[string]string{"type": "string"}
+		return param
+	}
+	var patterns, formats []string
+	for _, seg := range segments {
+		switch seg.kind {
+		case segLiteral:
+			patterns = append(patterns, regexp.QuoteMeta(seg.value))
+			formats = append(formats, seg.value)
+		case segWild:
+			patterns = append(patterns, "[^/]+")
+			formats = append(formats, "*")
+		case segWildAll:
+			patterns = append(patterns, ".+")
+			formats = append(formats, "**")
+		}
+	}
+	param.Schema = map[string]string{"type": "string", "pattern": "^" + strings.Join(patterns, "/") + "$"}
+	param.Description = "multi-segment path " + strings.Join(formats, "/") + ", the / in the value must not be escaped"
+	return param
+}
+
+func openAPIPath(t *pathTemplate) string {
+	var parts []string
+	for i := 0; i < len(t.segments); {
+		var v *pathVar
+		for j := range t.vars {
+			if t.vars[j].start == i {
+				v = &t.vars[j]
+			}
+		}
+		if v != nil {
+			parts = append(parts, "{"+v.field+"}")
+			if v.end < 0 {
+				break
+			}
+			i = v.end
+			continue
+		}
+		seg := t.segments[i]
+		switch seg.kind {
+		case segLiteral:
+			parts = append(parts, seg.value)
+		case segWild:
+			parts = append(parts, "*")
+		case segWildAll:
+			parts = append(parts, "**")
+		}
+		i++
+	}
+	path := "/" + strings.Join(parts, "/")
+	if len(t.verb) > 0 {
+		path += ":" + t.verb
+	}
+	return path
+}
+
+func fieldByPath(tp reflect.Type, keys []string) (reflect.StructField, bool) {
+	var field reflect.StructField
+	for _, key := range keys {
+		for tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
+		}
+		f, ok := fieldByName(tp, key)
+		if !ok {
+			return field, false
+		}
+		field, tp = f, f.Type
+	}
+	return field, true
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func errorSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"code":    map[string]string{"type": "integer", "format": "int32"},
+			"message": map[string]string{"type": "string"},
+			"flowID":  map[string]string{"type": "string"},
+			"details": map[string]interface{}{"type": "array", "items": map[string]string{"type": "object"}},
+		},
+	}
+}
+
+func (me *openAPIBuilder) operation(id string, reqType, rspType reflect.Type, envelope bool) *operation {
+	rsp := me.messageSchema(rspType)
+	if envelope {
+		rsp = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"code": map[string]string{"type": "integer", "format": "int32"},
+				"data": rsp,
+			},
+		}
+	}
+	return &operation{
+		OperationID: id,
+		Responses: map[string]interface{}{
+			"200":     map[string]interface{}{"description": "OK", "content": jsonContent(rsp)},
+			"default": map[string]interface{}{"description": "Error", "content": jsonContent(ref("Error"))},
+		},
+	}
+}
+
+func (me *openAPIBuilder) requestBody(reqType reflect.Type) map[string]interface{} {
+	return map[string]interface{}{"required": true, "content": jsonContent(me.messageSchema(reqType))}
+}
+
+//queryParameters 入参中不是message的字段都可以用query参数传, except中的字段除外
+func (me *openAPIBuilder) queryParameters(reqType reflect.Type, except map[string]bool) []*parameter {
+	var list []*parameter
+	for _, field := range protoFields(reqType) {
+		name := protoName(field)
+		if except[name] || except[jsonName(field)] {
+			continue
+		}
+		tp := field.Type
+		if tp.Kind() == reflect.Slice && tp.Elem().Kind() != reflect.Uint8 {
+			tp = tp.Elem()
+		}
+		if tp.Kind() == reflect.Ptr || tp.Kind() == reflect.Map {
+			continue
+		}
+		list = append(list, &parameter{Name: name, In: "query", Schema: me.fieldSchema(field)})
+	}
+	return list
+}
+
+func ref(name string) map[string]string {
+	return map[string]string{"$ref": "#/components/schemas/" + name}
+}
+
+//protoFields message中有protobuf tag的字段
+func protoFields(tp reflect.Type) []reflect.StructField {
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	var list []reflect.StructField
+	for i := 0; i < tp.NumField(); i++ {
+		if len(tp.Field(i).Tag.Get("protobuf")) > 0 {
+			list = append(list, tp.Field(i))
+		}
+	}
+	return list
+}
+
+//messageSchema 注册message的schema, 返回引用
+func (me *openAPIBuilder) messageSchema(tp reflect.Type) interface{} {
+	if tp.Kind() != reflect.Ptr {
+		tp = reflect.PtrTo(tp)
+	}
+	msg, ok := reflect.Zero(tp).Interface().(proto.Message)
+	if !ok {
+		return map[string]string{"type": "object"}
+	}
+	name := proto.MessageName(msg)
+	if len(name) == 0 {
+		name = tp.Elem().Name()
+	}
+	if _, ok := me.schemas[name]; ok {
+		return ref(name)
+	}
+	//先占位, 避免递归的message死循环
+	me.schemas[name] = nil
+	properties := make(map[string]interface{})
+	for _, field := range protoFields(tp) {
+		properties[me.fieldName(field)] = me.fieldSchema(field)
+	}
+	me.schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+	return ref(name)
+}
+
+//fieldName 响应中的字段名, 与jsonpb一致
+func (me *openAPIBuilder) fieldName(field reflect.StructField) string {
+	if !me.orig {
+		for _, opt := range strings.Split(field.Tag.Get("protobuf"), ",") {
+			if strings.HasPrefix(opt, "json=") {
+				return opt[len("json="):]
+			}
+		}
+	}
+	return protoName(field)
+}
+
+func (me *openAPIBuilder) fieldSchema(field reflect.StructField) interface{} {
+	tp := field.Type
+	switch {
+	case tp.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": me.typeSchema(tp.Elem(), field.Tag.Get("protobuf_val"))}
+	case tp.Kind() == reflect.Slice && tp.Elem().Kind() != reflect.Uint8:
+		return map[string]interface{}{"type": "array", "items": me.typeSchema(tp.Elem(), field.Tag.Get("protobuf"))}
+	}
+	return me.typeSchema(tp, field.Tag.Get("protobuf"))
+}
+
+//typeSchema 按proto3的json映射描述类型
+func (me *openAPIBuilder) typeSchema(tp reflect.Type, tag string) interface{} {
+	for _, opt := range strings.Split(tag, ",") {
+		if strings.HasPrefix(opt, "enum=") {
+			var names []string
+			for name := range proto.EnumValueMap(opt[len("enum="):]) {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return map[string]interface{}{"type": "string", "enum": names}
+		}
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		return map[string]string{"type": "boolean"}
+	case reflect.Int32:
+		return map[string]string{"type": "integer", "format": "int32"}
+	case reflect.Uint32:
+		return map[string]string{"type": "integer", "format": "uint32"}
+	case reflect.Int64:
+		return map[string]string{"type": "string", "format": "int64"}
+	case reflect.Uint64:
+		return map[string]string{"type": "string", "format": "uint64"}
+	case reflect.Float32:
+		return map[string]string{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]string{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]string{"type": "string"}
+	case reflect.Slice:
+		return map[string]string{"type": "string", "format": "byte"}
+	case reflect.Ptr, reflect.Struct:
+		return me.messageSchema(tp)
+	}
+	return map[string]string{"type": "object"}
+}
+
+//serveDocs 返回OpenAPI文档和文档页面, 不是文档的路径或文档已关闭时返回false.
+//配置了DocsAuth时认证失败返回401
+func (me *HTTPGateWay) serveDocs(w http.ResponseWriter, r *http.Request) bool {
+	me.mu.RLock()
+	ui, disabled, names := me.opts.docsUI, me.opts.docsDisabled, me.opts.docsAuth
+	me.mu.RUnlock()
+	isDocs := r.URL.Path == OPENAPI_PATH || (len(ui) > 0 && r.URL.Path == ui)
+	if disabled || !isDocs {
+		return false
+	}
+	if len(names) > 0 {
+		if _, err := auth.VerifyRequest(r, names, r.URL.Path); err != nil {
+			me.writeError(r.Context(), w, 0, statusError(codes.Unauthenticated, err.Error()))
+			return true
+		}
+	}
+	if r.URL.Path == OPENAPI_PATH {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(me.OpenAPI())
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(docsPage))
+	}
+	return true
+}
+
+//docsPage 不依赖外部资源的文档页面
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API</title>
+<style>
+body{font-family:sans-serif;margin:2em;color:#333}
+details{border:1px solid #ddd;border-radius:4px;margin:.5em 0;padding:.5em}
+summary{cursor:pointer;font-family:monospace;font-size:1.1em}
+.verb{display:inline-block;width:5em;font-weight:bold;color:#fff;text-align:center;border-radius:3px;margin-right:1em}
+.get{background:#61affe}.post{background:#49cc90}.put{background:#fca130}.delete{background:#f93e3e}.patch{background:#50e3c2}
+pre{background:#f6f6f6;padding:.5em;overflow:auto}
+</style>
+</head>
+<body>
+<h1 id="title"></h1>
+<div id="paths"></div>
+<script>
+fetch("` + OPENAPI_PATH + `").then(function(r){return r.json()}).then(function(doc){
+  document.title = doc.info.title;
+  document.getElementById("title").textContent = doc.info.title + " " + doc.info.version;
+  var schemas = doc.components.schemas;
+  function resolve(s, depth){
+    if (!s || depth > 5) return s;
+    if (s["$ref"]) return resolve(schemas[s["$ref"].split("/").pop()], depth+1);
+    var out = {};
+    for (var k in s) out[k] = (typeof s[k] === "object" && !Array.isArray(s[k])) ? resolve(s[k], depth+1) : s[k];
+    return out;
+  }
+  function esc(s){
+    return String(s).replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/"/g, "&quot;").replace(/'/g, "&#39;");
+  }
+  function section(name, obj){
+    return "<h4>" + esc(name) + "</h4><pre>" + esc(JSON.stringify(obj, null, 2)) + "</pre>";
+  }
+  var html = "";
+  Object.keys(doc.paths).sort().forEach(function(path){
+    Object.keys(doc.paths[path]).forEach(function(verb){
+      var op = doc.paths[path][verb];
+      html += "<details><summary><span class='verb " + esc(verb) + "'>" + esc(verb.toUpperCase()) + "</span>" + esc(path) + "</summary>";
+      if (op.parameters) html += section("Parameters", op.parameters);
+      if (op.requestBody) html += section("Request", resolve(op.requestBody.content["application/json"].schema, 0));
+      html += section("Response", resolve(op.responses["200"].content["application/json"].schema, 0));
+      html += "</details>";
+    });
+  });
+  document.getElementById("paths").innerHTML = html;
+});
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,361 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"local/sndaRpc/auth"
+	"local/sndaRpc/cache"
+	"local/sndaRpc/util"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-redis/redis"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	//限流的维度
+	LIMIT_BY_ROUTE = "route" //路由的全部请求
+	LIMIT_BY_IP    = "ip"    //每个对端ip, 按连接的RemoteAddr, 不读取X-Forwarded-For, 网关在代理后面时是代理的ip
+	LIMIT_BY_KEY   = "key"   //每个认证通过的调用方(apikey, app id, jwt sub), 没有认证时按ip
+
+	//内存中最多记录多少个计数器, 超过后先删除过期的, 仍然太多时随机删除
+	maxRateCounters = 100000
+	//redis中计数器key的前缀
+	rateKeyPrefix = "gateway:ratelimit:"
+)
+
+//RateLimit 一条限流规则: 每个By维度在Window内最多Limit次请求
+type RateLimit struct {
+	By     string
+	Limit  int64
+	Window time.Duration
+	//Redis 对应<redis>的name, 多个网关实例共享计数; 为空时在内存中计数
+	Redis string
+}
+
+//RateLimitsByConfig 把<interface>中的<rate-limit>配置转成限流规则
+func RateLimitsByConfig(infoList []*util.RateLimitInfo) ([]*RateLimit, error) {
+	var list []*RateLimit
+	for _, info := range infoList {
+		limit := &RateLimit{By: strings.ToLower(info.By), Redis: info.Redis, Window: time.Second}
+		switch limit.By {
+		case "":
+			limit.By = LIMIT_BY_ROUTE
+		case LIMIT_BY_ROUTE, LIMIT_BY_IP, LIMIT_BY_KEY:
+		default:
+			return nil, fmt.Errorf("rate-limit: invalid by %s", info.By)
+		}
+		n, err := strconv.ParseInt(info.Limit, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("rate-limit: invalid limit %s", info.Limit)
+		}
+		limit.Limit = n
+		if len(info.Window) > 0 {
+			if limit.Window, err = time.ParseDuration(info.Window); err != nil || limit.Window < time.Millisecond {
+				return nil, fmt.Errorf("rate-limit: invalid window %s", info.Window)
+			}
+		}
+		list = append(list, limit)
+	}
+	return list, nil
+}
+
+//RateResult 一次计数的结果
+type RateResult struct {
+	Allowed    bool
+	Remaining  int64
+	Reset      time.Duration //当前窗口结束的时间
+	RetryAfter time.Duration //被拒绝时需要等待的时间
+
+	index int64 //计数的窗口, 用于Refund
+}
+
+//RateLimiter 滑动窗口计数器, 用前一个窗口的计数按剩余时间加权估算最近一个窗口的请求数
+type RateLimiter interface {
+	//Allow 没有超过limit时计数一次
+	Allow(ctx context.Context, key string, limit int64, window time.Duration) (*RateResult, error)
+	//Refund 退回Allow计的一次, 用于同一请求的其他规则拒绝时
+	Refund(ctx context.Context, key string, window time.Duration, result *RateResult) error
+}
+
+//slidingWindow 按前一个窗口和当前窗口的计数计算是否允许. elapsed为当前窗口已经过去的时间
+func slidingWindow(prev, cur, limit int64, elapsed, window time.Duration) *RateResult {
+	estimated := weighted(prev, window-elapsed, window) + cur
+	result := &RateResult{Reset: window - elapsed}
+	if estimated < limit {
+		result.Allowed = true
+		result.Remaining = limit - estimated - 1
+		return result
+	}
+	//等到估算值降到limit以下, 即floor(prev*weight) < limit-cur
+	if cur < limit && prev > 0 {
+		//本窗口内前一个窗口的权重降到(limit-cur)/prev以下
+		result.RetryAfter = weightBelow(window, prev, limit-cur) - elapsed
+	} else {
+		//下一个窗口中当前窗口的权重降到limit/cur以下
+		result.RetryAfter = window - elapsed + weightBelow(window, cur, limit)
+	}
+	if result.RetryAfter <= 0 {
+		result.RetryAfter = time.Millisecond
+	}
+	return result
+}
+
+//weighted 前一个窗口的计数按剩余时间加权, 先乘后除, 避免1-elapsed/window的误差在边界上多算或少算一次
+func weighted(prev int64, remaining, window time.Duration) int64 {
+	return int64(math.Floor(float64(prev) * float64(remaining) / float64(window)))
+}
+
+//weightBelow 窗口内count*weight第一次小于n的时间, 即elapsed > window*(count-n)/count
+func weightBelow(window time.Duration, count, n int64) time.Duration {
+	return time.Duration(math.Floor(float64(window)*float64(count-n)/float64(count))) + 1
+}
+
+type rateCounter struct {
+	index     int64
+	prev, cur int64
+	expire    int64 //当前窗口和下一个窗口都结束后不再影响计数
+}
+
+//MemoryRateLimiter 在内存中计数, 只对单个网关实例有效
+type MemoryRateLimiter struct {
+	mu       sync.Mutex
+	counters map[string]*rateCounter
+	max      int
+}
+
+//NewMemoryRateLimiter 创建MemoryRateLimiter
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{counters: make(map[string]*rateCounter), max: maxRateCounters}
+}
+
+//evict 删除过期的计数器, 仍然太多时随机删除到90%, 避免每个新key都遍历一次
+func (me *MemoryRateLimiter) evict(now int64) {
+	for key, counter := range me.counters {
+		if counter.expire <= now {
+			delete(me.counters, key)
+		}
+	}
+	for key := range me.counters {
+		if len(me.counters) < me.max*9/10 {
+			break
+		}
+		delete(me.counters, key)
+	}
+}
+
+func (me *MemoryRateLimiter) Allow(ctx context.Context, key string, limit int64, window time.Duration) (*RateResult, error) {
+	now := time.Now().UnixNano()
+	index := now / int64(window)
+	elapsed := time.Duration(now % int64(window))
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	counter, ok := me.counters[key]
+	if !ok {
+		if len(me.counters) >= me.max {
+			me.evict(now)
+		}
+		counter = &rateCounter{index: index}
+		me.counters[key] = counter
+	}
+	switch {
+	case counter.index == index-1:
+		counter.prev, counter.cur = counter.cur, 0
+	case counter.index < index-1:
+		counter.prev, counter.cur = 0, 0
+	}
+	counter.index = index
+	counter.expire = (index + 2) * int64(window)
+	result := slidingWindow(counter.prev, counter.cur, limit, elapsed, window)
+	result.index = index
+	if result.Allowed {
+		counter.cur++
+	}
+	return result, nil
+}
+
+func (me *MemoryRateLimiter) Refund(ctx context.Context, key string, window time.Duration, result *RateResult) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	counter, ok := me.counters[key]
+	if !ok {
+		return nil
+	}
+	switch {
+	case counter.index == result.index && counter.cur > 0:
+		counter.cur--
+	case counter.index == result.index+1 && counter.prev > 0:
+		counter.prev--
+	}
+	return nil
+}
+
+//rateScript 读取前一个窗口和当前窗口的计数, 没有超过时当前窗口加一
+var rateScript = redis.NewScript(`
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+if math.floor(prev * tonumber(ARGV[1]) / tonumber(ARGV[4])) + cur < tonumber(ARGV[2]) then
+	redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return {prev, cur}
+`)
+
+//refundScript 计数还在时减一, 过期的不再创建
+var refundScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+//RedisRateLimiter 在RedisManager中注册的redis中计数, 多个网关实例共享
+type RedisRateLimiter struct {
+	redisName string
+}
+
+//NewRedisRateLimiter redisName 对应<redis>的name
+func NewRedisRateLimiter(redisName string) *RedisRateLimiter {
+	return &RedisRateLimiter{redisName: redisName}
+}
+
+func (me *RedisRateLimiter) Allow(ctx context.Context, key string, limit int64, window time.Duration) (*RateResult, error) {
+	client, err := cache.DefaultRedisManager().GetContext(ctx, me.redisName)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixNano()
+	index := now / int64(window)
+	elapsed := time.Duration(now % int64(window))
+	keys := []string{redisRateKey(key, index), redisRateKey(key, index-1)}
+	//保留到下一个窗口结束, 作为下一个窗口的prev
+	ttl := int64(2 * window / time.Millisecond)
+	//与slidingWindow相同, 按剩余时间/窗口长度加权
+	res, err := rateScript.Run(client, keys, int64(window-elapsed), limit, ttl, int64(window)).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts, ok := res.([]interface{})
+	if !ok || len(counts) != 2 {
+		return nil, fmt.Errorf("unexpected rate limit result %v", res)
+	}
+	prev, _ := counts[0].(int64)
+	cur, _ := counts[1].(int64)
+	result := slidingWindow(prev, cur, limit, elapsed, window)
+	result.index = index
+	return result, nil
+}
+
+func (me *RedisRateLimiter) Refund(ctx context.Context, key string, window time.Duration, result *RateResult) error {
+	client, err := cache.DefaultRedisManager().GetContext(ctx, me.redisName)
+	if err != nil {
+		return err
+	}
+	return refundScript.Run(client, []string{redisRateKey(key, result.index)}).Err()
+}
+
+func redisRateKey(key string, index int64) string {
+	return rateKeyPrefix + key + ":" + strconv.FormatInt(index, 10)
+}
+
+//SetRouteRateLimits 设置路由的限流规则, 为空时不限流
+func (me *HTTPGateWay) SetRouteRateLimits(name string, limits []*RateLimit) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.handlers[name]; !ok {
+		return fmt.Errorf("route %s not found", name)
+	}
+	if len(limits) == 0 {
+		delete(me.routeLimits, name)
+	} else {
+		me.routeLimits[name] = limits
+	}
+	return nil
+}
+
+func (me *HTTPGateWay) rateLimiter(redisName string) RateLimiter {
+	if len(redisName) == 0 {
+		return me.memoryLimiter
+	}
+	return NewRedisRateLimiter(redisName)
+}
+
+//rateKey 计数器的key, 不同路由和维度分开计数
+func rateKey(route string, limit *RateLimit, r *http.Request) string {
+	key := route + ":" + limit.By + ":" + strconv.FormatInt(int64(limit.Window/time.Millisecond), 10)
+	switch limit.By {
+	case LIMIT_BY_KEY:
+		if principal, ok := auth.FromContext(r.Context()); ok && len(principal.ID) > 0 {
+			return key + ":" + principal.Scheme + ":" + principal.ID
+		}
+		fallthrough
+	case LIMIT_BY_IP:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return key + ":" + host
+	}
+	return key
+}
+
+//rateLimitHandler 超过限流时返回429和Retry-After, 并带上X-RateLimit-*响应头(多条规则时为剩余最少的一条).
+//被任意一条规则拒绝时退回其他规则已经计的数, 被拒绝的请求不占用配额. 计数失败时不限流, 只记录日志
+func (me *HTTPGateWay) rateLimitHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		me.mu.RLock()
+		limits := me.routeLimits[name]
+		me.mu.RUnlock()
+		var (
+			tightest *RateResult
+			limitOf  *RateLimit
+			keys     = make([]string, len(limits))
+			charged  = make([]*RateResult, len(limits)) //通过并且已经计数的规则
+		)
+		for i, limit := range limits {
+			keys[i] = rateKey(name, limit, r)
+			result, err := me.rateLimiter(limit.Redis).Allow(r.Context(), keys[i], limit.Limit, limit.Window)
+			if err != nil {
+				level.Error(me.logger).Log("msg", "rate limit", "route", name, "redis", limit.Redis, "error", err)
+				continue
+			}
+			if tightest == nil || !result.Allowed || (tightest.Allowed && result.Remaining < tightest.Remaining) {
+				tightest, limitOf = result, limit
+			}
+			if !result.Allowed {
+				break
+			}
+			charged[i] = result
+		}
+		if tightest != nil && !tightest.Allowed {
+			for i, result := range charged {
+				if result == nil {
+					continue
+				}
+				limit := limits[i]
+				if err := me.rateLimiter(limit.Redis).Refund(r.Context(), keys[i], limit.Window, result); err != nil {
+					level.Error(me.logger).Log("msg", "rate limit refund", "route", name, "redis", limit.Redis, "error", err)
+				}
+			}
+		}
+		if tightest != nil {
+			header := w.Header()
+			header.Set("X-RateLimit-Limit", strconv.FormatInt(limitOf.Limit, 10))
+			header.Set("X-RateLimit-Remaining", strconv.FormatInt(tightest.Remaining, 10))
+			header.Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(tightest.Reset.Seconds())), 10))
+			if !tightest.Allowed {
+				header.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(tightest.RetryAfter.Seconds())), 10))
+				me.writeError(r.Context(), w, 0, statusError(codes.ResourceExhausted, "rate limit exceeded"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"local/sndaRpc/logHelper"
+	"local/sndaRpc/util"
+	"net/http"
+)
+
+const (
+	//DEFAULT_REQUEST_ID_HEADER 默认读取和返回请求id的header
+	DEFAULT_REQUEST_ID_HEADER = "X-Request-ID"
+	//maxRequestIDLen 调用方传入的请求id的最大长度
+	maxRequestIDLen = 128
+)
+
+//RequestIDHeader 读取和返回请求id的header, 为空时使用DEFAULT_REQUEST_ID_HEADER
+func RequestIDHeader(name string) Option {
+	return func(opts *options) {
+		opts.requestIDHeader = http.CanonicalHeaderKey(name)
+	}
+}
+
+//validRequestID 请求id会写入日志, 只接受字母, 数字和 -_.: 避免伪造日志内容
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (me *HTTPGateWay) requestIDHeader() string {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	if len(me.opts.requestIDHeader) == 0 {
+		return DEFAULT_REQUEST_ID_HEADER
+	}
+	return me.opts.requestIDHeader
+}
+
+//withRequestID 使用调用方传入的请求id作为flowID, 没有或不合法时生成新的, 并在响应头中返回.
+//flowID会写入网关和后端服务的日志, 也在错误响应中返回
+func (me *HTTPGateWay) withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	header := me.requestIDHeader()
+	id := r.Header.Get(header)
+	if !validRequestID(id) {
+		id = util.NewGuid()
+	}
+	w.Header().Set(header, id)
+	return r.WithContext(logHelper.ContextWithLogInfo(r.Context(), &logHelper.LogInfo{FlowID: id}))
+}
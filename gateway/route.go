@@ -0,0 +1,265 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//路径模板中的一段
+const (
+	segLiteral = iota
+	segWild    //* 匹配一段
+	segWildAll //** 匹配剩余的全部
+)
+
+type segment struct {
+	kind  int
+	value string
+}
+
+//pathVar 路径变量, 对应模板中[start, end)的段, end为-1时到路径末尾
+type pathVar struct {
+	field      string //入参字段路径, 如 user.name
+	start, end int
+}
+
+//pathTemplate 与grpc-gateway一致的路径模板, 如 /v1/{name=users/*}/session:verify
+type pathTemplate struct {
+	template string
+	segments []segment
+	vars     []pathVar
+	verb     string //模板末尾的 :verb
+}
+
+//parseTemplate 解析路径模板
+//Template = "/" Segments [ Verb ] ;
+//Segment  = "*" | "**" | LITERAL | Variable ;
+//Variable = "{" FieldPath [ "=" Segments ] "}" ;
+func parseTemplate(template string) (*pathTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("path %s: should start with /", template)
+	}
+	me := &pathTemplate{template: template}
+	path := template[1:]
+	//:verb只能在最后一个}之后
+	if idx := strings.LastIndex(path, ":"); idx >= 0 && idx > strings.LastIndex(path, "}") {
+		me.verb = path[idx+1:]
+		path = path[:idx]
+		if len(me.verb) == 0 {
+			return nil, fmt.Errorf("path %s: empty verb", template)
+		}
+	}
+	for len(path) > 0 {
+		if path[0] == '{' {
+			end := strings.Index(path, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("path %s: unclosed {", template)
+			}
+			field, pattern := path[1:end], "*"
+			if idx := strings.Index(field, "="); idx >= 0 {
+				field, pattern = field[:idx], field[idx+1:]
+			}
+			if len(field) == 0 || len(pattern) == 0 {
+				return nil, fmt.Errorf("path %s: invalid variable %s", template, path[:end+1])
+			}
+			v := pathVar{field: field, start: len(me.segments)}
+			for _, s := range strings.Split(pattern, "/") {
+				seg, err := parseSegment(s)
+				if err != nil {
+					return nil, fmt.Errorf("path %s: %s", template, err)
+				}
+				me.segments = append(me.segments, seg)
+			}
+			v.end = len(me.segments)
+			me.vars = append(me.vars, v)
+			path = path[end+1:]
+		} else {
+			end := strings.Index(path, "/")
+			if end < 0 {
+				end = len(path)
+			}
+			seg, err := parseSegment(path[:end])
+			if err != nil {
+				return nil, fmt.Errorf("path %s: %s", template, err)
+			}
+			me.segments = append(me.segments, seg)
+			path = path[end:]
+		}
+		if len(path) > 0 {
+			if path[0] != '/' || len(path) == 1 {
+				return nil, fmt.Errorf("path %s: invalid segment near %s", template, path)
+			}
+			path = path[1:]
+		}
+	}
+	for i, seg := range me.segments {
+		if seg.kind == segWildAll && i != len(me.segments)-1 {
+			return nil, fmt.Errorf("path %s: ** must be the last segment", template)
+		}
+	}
+	for i := range me.vars {
+		if me.vars[i].end == len(me.segments) && me.segments[len(me.segments)-1].kind == segWildAll {
+			me.vars[i].end = -1
+		}
+	}
+	return me, nil
+}
+
+func parseSegment(s string) (segment, error) {
+	switch {
+	case s == "*":
+		return segment{kind: segWild}, nil
+	case s == "**":
+		return segment{kind: segWildAll}, nil
+	case len(s) == 0 || strings.ContainsAny(s, "{}=*"):
+		return segment{}, fmt.Errorf("invalid segment %q", s)
+	}
+	return segment{kind: segLiteral, value: s}, nil
+}
+
+//match 匹配请求路径, 返回路径变量<字段路径, 值>和字面量段数(用于选择更具体的路由)
+func (me *pathTemplate) match(path string) (map[string]string, int, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, 0, false
+	}
+	path = path[1:]
+	if len(me.verb) > 0 {
+		if !strings.HasSuffix(path, ":"+me.verb) {
+			return nil, 0, false
+		}
+		path = path[:len(path)-len(me.verb)-1]
+	}
+	parts := strings.Split(path, "/")
+	literals := 0
+	for i, seg := range me.segments {
+		switch seg.kind {
+		case segWildAll:
+			if i >= len(parts) {
+				parts = append(parts, "")
+			}
+		case segWild:
+			if i >= len(parts) || len(parts[i]) == 0 {
+				return nil, 0, false
+			}
+		case segLiteral:
+			if i >= len(parts) || parts[i] != seg.value {
+				return nil, 0, false
+			}
+			literals++
+		}
+	}
+	last := len(me.segments) - 1
+	if len(parts) > len(me.segments) && (last < 0 || me.segments[last].kind != segWildAll) {
+		return nil, 0, false
+	}
+	vars := make(map[string]string, len(me.vars))
+	for _, v := range me.vars {
+		end := v.end
+		if end < 0 {
+			end = len(parts)
+		}
+		value, err := url.PathUnescape(strings.Join(parts[v.start:end], "/"))
+		if err != nil {
+			return nil, 0, false
+		}
+		vars[v.field] = value
+	}
+	return vars, literals, true
+}
+
+//route 一个RESTful路由
+type route struct {
+	verb     string //http method, 如 GET
+	template *pathTemplate
+	rpc      string //对应的rpc接口
+	body     string //*: body是整个入参; 字段路径: body是该字段; 空: 没有body
+	handler  http.Handler
+}
+
+//key 路由名, 如 GET /users/{userName}/session
+func (me *route) key() string {
+	return me.verb + " " + me.template.template
+}
+
+type pathVarsKey struct{}
+
+//pathVarsFromContext 路由匹配到的路径变量
+func pathVarsFromContext(ctx context.Context) map[string]string {
+	vars, _ := ctx.Value(pathVarsKey{}).(map[string]string)
+	return vars
+}
+
+//router 按http method和路径模板分发请求, 多个路由匹配时字面量多的优先
+type router struct {
+	mu     sync.RWMutex
+	routes []*route
+}
+
+func (me *router) add(r *route) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, exist := range me.routes {
+		if exist.key() == r.key() {
+			return fmt.Errorf("route %s exist already", r.key())
+		}
+	}
+	me.routes = append(me.routes, r)
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+//find 查找匹配的路由. 没有匹配时返回路径匹配但http method不匹配的路由允许的method
+func (me *router) find(method, path string) (*route, map[string]string, []string) {
+	var (
+		best     *route
+		bestVars map[string]string
+		bestLit  = -1
+		allowed  []string
+	)
+	me.mu.RLock()
+	routes := me.routes
+	me.mu.RUnlock()
+	for _, rt := range routes {
+		vars, literals, ok := rt.template.match(path)
+		if !ok {
+			continue
+		}
+		if rt.verb != method {
+			if !contains(allowed, rt.verb) {
+				allowed = append(allowed, rt.verb)
+			}
+			continue
+		}
+		if literals > bestLit {
+			best, bestVars, bestLit = rt, vars, literals
+		}
+	}
+	if best != nil {
+		return best, bestVars, nil
+	}
+	sort.Strings(allowed)
+	return nil, nil, allowed
+}
+
+//serve 处理匹配到的请求. 没有匹配的路由时返回false, 路径匹配但http method不匹配时同时返回允许的method
+func (me *router) serve(w http.ResponseWriter, r *http.Request) (bool, []string) {
+	rt, vars, allowed := me.find(r.Method, r.URL.EscapedPath())
+	if rt == nil {
+		return false, allowed
+	}
+	rt.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pathVarsKey{}, vars)))
+	return true, nil
+}
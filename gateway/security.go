@@ -0,0 +1,371 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"local/sndaRpc/util"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	//DEFAULT_CORS 没有单独配置CORS的路由使用的策略名
+	DEFAULT_CORS = "default"
+	//DEFAULT_MAX_BODY 默认的最大请求body, 与grpc默认的最大消息一致
+	DEFAULT_MAX_BODY = 4 * 1024 * 1024
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+//defaultSecurityHeaders 默认的安全相关响应头
+func defaultSecurityHeaders() map[string]string {
+	return map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "DENY",
+		"Referrer-Policy":        "no-referrer",
+	}
+}
+
+//CORSPolicy 跨域策略
+type CORSPolicy struct {
+	Origins       []string //允许的Origin, *表示任意, https://*.example.com表示子域名, 没有scheme时只允许https
+	Methods       []string //允许的http method, 为空时允许路由的method
+	Headers       []string //允许的请求头, 为空时允许请求的全部
+	ExposeHeaders []string //浏览器可以读取的响应头
+	Credentials   bool     //是否允许带cookie
+	MaxAge        time.Duration
+}
+
+func (me *CORSPolicy) allowOrigin(origin string) bool {
+	return contains(me.Origins, "*") || me.listOrigin(origin)
+}
+
+//listOrigin 不考虑*, Origin是否在允许的列表中
+func (me *CORSPolicy) listOrigin(origin string) bool {
+	for _, allowed := range me.Origins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if matchSubdomain(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchSubdomain 按scheme和域名后缀匹配*.example.com形式的Origin, 防止http页面冒充https站点
+func matchSubdomain(allowed, origin string) bool {
+	scheme, host := "https", allowed
+	if i := strings.Index(allowed, "://"); i >= 0 {
+		scheme, host = allowed[:i], allowed[i+3:]
+	}
+	if !strings.HasPrefix(host, "*.") {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || !strings.EqualFold(u.Scheme, scheme) {
+		return false
+	}
+	suffix := strings.ToLower(host[1:])
+	return len(u.Host) > len(suffix) && strings.HasSuffix(strings.ToLower(u.Host), suffix)
+}
+
+//CORS 设置跨域策略, 名为DEFAULT_CORS的策略用于没有单独配置的路由, policy为nil时删除
+func CORS(name string, policy *CORSPolicy) Option {
+	return func(opts *options) {
+		cors := make(map[string]*CORSPolicy, len(opts.cors)+1)
+		for k, v := range opts.cors {
+			cors[k] = v
+		}
+		if policy == nil {
+			delete(cors, name)
+		} else {
+			cors[name] = policy
+		}
+		opts.cors = cors
+	}
+}
+
+//MaxBodySize 默认的最大请求body(字节), 超过时返回413. 0表示不限制
+func MaxBodySize(n int64) Option {
+	return func(opts *options) {
+		opts.maxBody = n
+	}
+}
+
+//SecurityHeader 设置每个响应都带的响应头, value为空时去掉
+func SecurityHeader(name, value string) Option {
+	return func(opts *options) {
+		headers := make(map[string]string, len(opts.headers)+1)
+		for k, v := range opts.headers {
+			headers[k] = v
+		}
+		if len(value) == 0 {
+			delete(headers, http.CanonicalHeaderKey(name))
+		} else {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+		opts.headers = headers
+	}
+}
+
+//SecurityOptionsByConfig 把<http><cors>和<http><security>配置转成Option
+func SecurityOptionsByConfig(corsList []*util.CORSInfo, info *util.HTTPSecurityInfo) ([]Option, error) {
+	var list []Option
+	for _, corsInfo := range corsList {
+		name := corsInfo.Name
+		if len(name) == 0 {
+			name = DEFAULT_CORS
+		}
+		policy := &CORSPolicy{
+			Origins:       splitList(corsInfo.Origins),
+			Methods:       splitList(strings.ToUpper(corsInfo.Methods)),
+			Headers:       splitList(corsInfo.Headers),
+			ExposeHeaders: splitList(corsInfo.ExposeHeaders),
+		}
+		if len(policy.Origins) == 0 {
+			return nil, fmt.Errorf("http cors %s: origins required", name)
+		}
+		if len(corsInfo.Credentials) > 0 {
+			credentials, err := strconv.ParseBool(corsInfo.Credentials)
+			if err != nil {
+				return nil, fmt.Errorf("http cors %s: invalid credentials %s", name, corsInfo.Credentials)
+			}
+			policy.Credentials = credentials
+		}
+		//任意Origin带cookie等于关闭了跨域保护
+		if policy.Credentials && contains(policy.Origins, "*") {
+			return nil, fmt.Errorf("http cors %s: origins * not allowed with credentials", name)
+		}
+		if len(corsInfo.MaxAge) > 0 {
+			maxAge, err := time.ParseDuration(corsInfo.MaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("http cors %s: invalid max-age %s", name, corsInfo.MaxAge)
+			}
+			policy.MaxAge = maxAge
+		}
+		list = append(list, CORS(name, policy))
+	}
+	if info == nil {
+		return list, nil
+	}
+	if len(info.MaxBody) > 0 {
+		n, err := util.ParseSize(info.MaxBody)
+		if err != nil {
+			return nil, fmt.Errorf("http security: invalid max-body %s", info.MaxBody)
+		}
+		list = append(list, MaxBodySize(int64(n)))
+	}
+	for _, header := range info.Headers {
+		if len(header.Name) == 0 {
+			return nil, fmt.Errorf("http security: header name required")
+		}
+		list = append(list, SecurityHeader(header.Name, header.Value))
+	}
+	if len(info.MultipartMemory) > 0 {
+		n, err := util.ParseSize(info.MultipartMemory)
+		if err != nil {
+			return nil, fmt.Errorf("http security: invalid multipart-memory %s", info.MultipartMemory)
+		}
+		list = append(list, MultipartMemory(int64(n)))
+	}
+	if len(info.RequestID) > 0 {
+		list = append(list, RequestIDHeader(info.RequestID))
+	}
+	return list, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+//SetRouteCORS 设置路由使用的跨域策略, 为空时使用DEFAULT_CORS, 策略要先通过CORS设置
+func (me *HTTPGateWay) SetRouteCORS(name, policy string) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.handlers[name]; !ok {
+		return fmt.Errorf("route %s not found", name)
+	}
+	if _, ok := me.opts.cors[policy]; len(policy) > 0 && !ok {
+		return fmt.Errorf("route %s: cors %s not found", name, policy)
+	}
+	if len(policy) == 0 {
+		delete(me.routeCORS, name)
+	} else {
+		me.routeCORS[name] = policy
+	}
+	return nil
+}
+
+//SetRouteMaxBody 设置路由的最大请求body(字节), 小于0时使用默认值, 0表示不限制
+func (me *HTTPGateWay) SetRouteMaxBody(name string, n int64) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.handlers[name]; !ok {
+		return fmt.Errorf("route %s not found", name)
+	}
+	if n < 0 {
+		delete(me.routeMaxBody, name)
+	} else {
+		me.routeMaxBody[name] = n
+	}
+	return nil
+}
+
+//corsPolicy 路由的跨域策略, 没有时返回nil
+func (me *HTTPGateWay) corsPolicy(name string) *CORSPolicy {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	policy, ok := me.routeCORS[name]
+	if !ok {
+		policy = DEFAULT_CORS
+	}
+	return me.opts.cors[policy]
+}
+
+func (me *HTTPGateWay) maxBody(name string) int64 {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	if n, ok := me.routeMaxBody[name]; ok {
+		return n
+	}
+	return me.opts.maxBody
+}
+
+//writeSecurityHeaders 每个响应都带的响应头
+func (me *HTTPGateWay) writeSecurityHeaders(w http.ResponseWriter) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	for name, value := range me.opts.headers {
+		w.Header().Set(name, value)
+	}
+}
+
+//isPreflight CORS预检请求
+func isPreflight(r *http.Request) bool {
+	return r.Method == "OPTIONS" && len(r.Header.Get("Origin")) > 0 && len(r.Header.Get("Access-Control-Request-Method")) > 0
+}
+
+//servePreflight 按预检请求要调用的路由回复CORS预检, 没有对应的路由时返回false
+func (me *HTTPGateWay) servePreflight(w http.ResponseWriter, r *http.Request) bool {
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	var name string
+	if rt, _, _ := me.router.find(method, r.URL.EscapedPath()); rt != nil {
+		name = rt.key()
+	} else if _, pattern := me.serveMux.Handler(r); len(pattern) > 0 {
+		name = pattern
+	} else {
+		return false
+	}
+	policy := me.corsPolicy(name)
+	origin := r.Header.Get("Origin")
+	if policy == nil || !policy.allowOrigin(origin) {
+		me.writeError(r.Context(), w, http.StatusForbidden, statusError(codes.PermissionDenied, "origin "+origin+" not allowed"))
+		return true
+	}
+	if len(policy.Methods) > 0 && !contains(policy.Methods, method) {
+		me.writeError(r.Context(), w, http.StatusForbidden, statusError(codes.PermissionDenied, "method "+method+" not allowed"))
+		return true
+	}
+	header := w.Header()
+	me.writeCORSHeaders(header, policy, origin)
+	header.Set("Access-Control-Allow-Methods", method)
+	if len(policy.Methods) > 0 {
+		header.Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+	}
+	if len(policy.Headers) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); len(requested) > 0 {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+	if policy.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge/time.Second)))
+	}
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (me *HTTPGateWay) writeCORSHeaders(header http.Header, policy *CORSPolicy, origin string) {
+	header.Add("Vary", "Origin")
+	//允许任意Origin时不回显Origin, 也不允许带cookie
+	if contains(policy.Origins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		if policy.Credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	//浏览器中也可以读取请求id
+	header.Set("Access-Control-Expose-Headers", strings.Join(append([]string{me.requestIDHeader()}, policy.ExposeHeaders...), ", "))
+}
+
+//corsHandler 跨域请求带上CORS响应头, Origin不允许时不带, 由浏览器拦截
+func (me *HTTPGateWay) corsHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); len(origin) > 0 {
+			if policy := me.corsPolicy(name); policy != nil && policy.allowOrigin(origin) {
+				me.writeCORSHeaders(w.Header(), policy, origin)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//limitHandler 请求body超过限制时返回413, 上传文件的限制放入context
+func (me *HTTPGateWay) limitHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), uploadLimitKey{}, me.uploadLimit(name)))
+		limit := me.maxBody(name)
+		if limit > 0 && r.Body != nil {
+			if r.ContentLength > limit {
+				me.writeError(r.Context(), w, http.StatusRequestEntityTooLarge, statusError(codes.ResourceExhausted, errBodyTooLarge.Error()))
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, limit: limit}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//limitedBody 读取超过limit时返回errBodyTooLarge, 用于没有Content-Length的请求
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (me *limitedBody) exceeded() bool {
+	return me.read > me.limit
+}
+
+func (me *limitedBody) Read(p []byte) (int, error) {
+	if me.read > me.limit {
+		return 0, errBodyTooLarge
+	}
+	//多读一个字节判断是否超过
+	if remain := me.limit - me.read + 1; int64(len(p)) > remain {
+		p = p[:remain]
+	}
+	n, err := me.ReadCloser.Read(p)
+	me.read += int64(n)
+	if me.read > me.limit {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
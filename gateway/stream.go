@@ -0,0 +1,340 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"local/sndaRpc/client"
+	"local/sndaRpc/logHelper"
+	"local/sndaRpc/util"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//流式接口的桥接方式, 对应<interface>的stream
+	STREAM_SSE       = "sse"
+	STREAM_WEBSOCKET = "websocket"
+
+	//sseHeartbeat 没有消息时定时发送注释, 避免代理断开空闲连接
+	sseHeartbeat = 15 * time.Second
+	//wsCloseTimeout websocket客户端正常关闭后等待流结束的时间, 超过时取消调用
+	wsCloseTimeout = 10 * time.Second
+)
+
+func checkStreamMode(mode string) error {
+	switch mode {
+	case "", STREAM_SSE, STREAM_WEBSOCKET:
+		return nil
+	}
+	return fmt.Errorf("invalid stream %s", mode)
+}
+
+//checkStreamMethod 检查rpc接口能否按mode桥接, sse只支持服务端流
+func checkStreamMethod(mode string, info *util.InterfaceInfo) error {
+	if len(info.Stream) == 0 {
+		return fmt.Errorf("%s is not a stream method", info.Name)
+	}
+	if mode == STREAM_SSE && info.Stream != client.STREAM_SERVER {
+		return fmt.Errorf("sse only supports server stream, %s is %s stream", info.Name, info.Stream)
+	}
+	return nil
+}
+
+//newStreamHandler 把流式接口桥接成sse或websocket, 每条消息是出参按proto3 json映射编码的json.
+//bodyFromMessage: websocket调用服务端流时, 入参的body从客户端的第一条消息读取
+func (me *HTTPGateWay) newStreamHandler(name, mode string, dec kithttp.DecodeRequestFunc, bodyFromMessage bool) http.Handler {
+	dec = invalidArgument(dec)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := getTraceparent()(r.Context(), r)
+		request, err := dec(ctx, r)
+		if err != nil {
+			me.encodeError(ctx, err, w)
+			return
+		}
+		reqMap := request.(map[string]interface{})
+		me.mu.RLock()
+		rpcMethod := me.handlers[name]
+		me.mu.RUnlock()
+		ctx = me.withIdempotencyKey(ctx, r.Header, rpcMethod)
+		info := me.client.InterfaceInfo(rpcMethod)
+		streamClient, ok := me.client.(client.StreamClient)
+		if info == nil || !ok {
+			me.encodeError(ctx, status.Errorf(codes.Unimplemented, "%s is not a stream method", rpcMethod), w)
+			return
+		}
+		if err := checkStreamMethod(mode, info); err != nil {
+			me.encodeError(ctx, status.Error(codes.Unimplemented, err.Error()), w)
+			return
+		}
+		begin := time.Now()
+		if mode == STREAM_SSE {
+			err = me.serveSSE(ctx, w, streamClient, rpcMethod, reqMap)
+		} else {
+			waitFirst := info.Stream != client.STREAM_SERVER || bodyFromMessage
+			err = me.serveWebSocket(ctx, name, w, r, streamClient, rpcMethod, reqMap, waitFirst)
+		}
+		onceLogger := log.With(me.logger, "route", name, "method", rpcMethod, "stream", mode)
+		if logInfo, ok := logHelper.FromContext(ctx); ok {
+			onceLogger = log.With(onceLogger, "flowID", logInfo.FlowID)
+		}
+		level.Info(onceLogger).Log("error", err, "took", time.Since(begin))
+	})
+}
+
+//serveSSE 只支持服务端流: 请求参数是入参, 每个出参是一个事件, 结束时发送end事件, 出错时发送error事件.
+//第一个出参之前出错时按普通请求返回错误. 客户端断开时取消调用
+func (me *HTTPGateWay) serveSSE(ctx context.Context, w http.ResponseWriter, streamClient client.StreamClient, rpcMethod string, reqMap map[string]interface{}) error {
+	reqObj, err := me.newRequest(rpcMethod, reqMap)
+	if err != nil {
+		me.encodeError(ctx, err, w)
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := streamClient.NewStream(ctx, rpcMethod)
+	if err == nil {
+		if err = stream.Send(reqObj); err == nil {
+			err = stream.CloseSend()
+		}
+	}
+	if err != nil {
+		me.encodeError(ctx, err, w)
+		return err
+	}
+	type result struct {
+		response interface{}
+		err      error
+	}
+	results := make(chan result)
+	go func() {
+		for {
+			response, err := stream.Recv()
+			select {
+			case results <- result{response, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		header := w.Header()
+		header.Set("Content-Type", "text/event-stream; charset=utf-8")
+		header.Set("Cache-Control", "no-cache")
+		//nginx不缓冲
+		header.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+	}
+	write := func(s string) {
+		io.WriteString(w, s)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-heartbeat.C:
+			start()
+			write(": ping\n\n")
+		case res := <-results:
+			if res.err == io.EOF {
+				start()
+				write("event: end\ndata: {}\n\n")
+				return nil
+			}
+			if res.err != nil {
+				if !started {
+					me.encodeError(ctx, res.err, w)
+					return res.err
+				}
+				_, body := me.errorBody(res.err)
+				data, _ := json.Marshal(me.formatError(ctx, body))
+				write("event: error\ndata: " + string(data) + "\n\n")
+				return res.err
+			}
+			data, err := me.marshaler().MarshalToString(res.response.(proto.Message))
+			if err != nil {
+				return err
+			}
+			start()
+			write("data: " + data + "\n\n")
+		}
+	}
+}
+
+//checkOrigin websocket不受浏览器同源策略限制, 只接受同源或路由的跨域策略列出的Origin, 不接受*
+func (me *HTTPGateWay) checkOrigin(name string, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	policy := me.corsPolicy(name)
+	return policy != nil && policy.listOrigin(origin)
+}
+
+//wsConn websocket同时只能有一个写, 读消息和转发出参在不同的goroutine中
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (me *wsConn) writeText(data []byte) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.WriteMessage(websocket.TextMessage, data)
+}
+
+func (me *wsConn) close(code int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+}
+
+//serveWebSocket 客户端的每条文本消息是一个json入参, 与路径变量和query参数合并; 每个出参是一条文本消息.
+//服务端流不需要等待消息时请求参数就是入参. 流结束时正常关闭, 出错时先发送错误响应再以1011关闭;
+//消息不是合法的入参时只发送错误响应. 客户端正常关闭时结束发送, 继续转发出参直到流结束; 断开时取消调用
+func (me *HTTPGateWay) serveWebSocket(ctx context.Context, name string, w http.ResponseWriter, r *http.Request,
+	streamClient client.StreamClient, rpcMethod string, reqMap map[string]interface{}, waitFirst bool) error {
+	var reqObj proto.Message
+	if !waitFirst {
+		var err error
+		if reqObj, err = me.newRequest(rpcMethod, reqMap); err != nil {
+			me.encodeError(ctx, err, w)
+			return err
+		}
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return me.checkOrigin(name, r) }}
+	//Upgrade失败时已经返回了错误
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+	conn := &wsConn{Conn: c}
+	defer conn.Close()
+	//客户端关闭时不立即回复关闭帧, 流结束时再关闭
+	conn.SetCloseHandler(func(code int, text string) error { return nil })
+	if limit := me.maxBody(name); limit > 0 {
+		conn.SetReadLimit(limit)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	//出错时发送错误响应后关闭
+	fail := func(err error) error {
+		me.writeWebSocketError(ctx, conn, err)
+		conn.close(websocket.CloseInternalServerErr)
+		return err
+	}
+	stream, err := streamClient.NewStream(ctx, rpcMethod)
+	if err != nil {
+		return fail(err)
+	}
+	if reqObj != nil {
+		if err := stream.Send(reqObj); err != nil {
+			return fail(err)
+		}
+		stream.CloseSend()
+	}
+	go me.readWebSocket(ctx, cancel, conn, stream, rpcMethod, reqMap, waitFirst)
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			conn.close(websocket.CloseNormalClosure)
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				//客户端已经断开
+				return ctx.Err()
+			}
+			return fail(err)
+		}
+		data, err := me.marshaler().MarshalToString(response.(proto.Message))
+		if err != nil {
+			return fail(err)
+		}
+		if err := conn.writeText([]byte(data)); err != nil {
+			return err
+		}
+	}
+}
+
+//readWebSocket 转发客户端的消息, 只调用服务端流时只转发第一条.
+//客户端正常关闭时结束发送, 等待流结束(最多wsCloseTimeout); 断开时取消调用
+func (me *HTTPGateWay) readWebSocket(ctx context.Context, cancel context.CancelFunc, conn *wsConn, stream client.Stream,
+	rpcMethod string, reqMap map[string]interface{}, forward bool) {
+	info := me.client.InterfaceInfo(rpcMethod)
+	for {
+		_, data, err := conn.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			if forward {
+				stream.CloseSend()
+			}
+			time.AfterFunc(wsCloseTimeout, cancel)
+			return
+		}
+		if err != nil {
+			cancel()
+			return
+		}
+		if !forward {
+			continue
+		}
+		message := make(map[string]interface{})
+		if err := unmarshalJSON(data, &message); err != nil {
+			me.writeWebSocketError(ctx, conn, status.Errorf(codes.InvalidArgument, "invalid json message: %s", err))
+			continue
+		}
+		//路径变量优先
+		appendMap(reqMap, message)
+		reqObj, err := me.newRequest(rpcMethod, message)
+		if err != nil {
+			me.writeWebSocketError(ctx, conn, err)
+			continue
+		}
+		if err := stream.Send(reqObj); err != nil {
+			//流已经结束, 错误由Recv返回
+			cancel()
+			return
+		}
+		if info.Stream == client.STREAM_SERVER {
+			stream.CloseSend()
+			forward = false
+		}
+	}
+}
+
+//writeWebSocketError 发送错误响应, 格式与普通请求相同
+func (me *HTTPGateWay) writeWebSocketError(ctx context.Context, conn *wsConn, err error) {
+	_, body := me.errorBody(err)
+	data, _ := json.Marshal(me.formatError(ctx, body))
+	conn.writeText(data)
+}
@@ -0,0 +1,238 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"local/sndaRpc/util"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//HEADER 默认携带幂等key的metadata/http header
+	HEADER = "x-idempotency-key"
+
+	STATE_PENDING = "pending"
+	STATE_DONE    = "done"
+
+	DEFAULT_TTL      = 24 * time.Hour
+	DEFAULT_WAIT     = 5 * time.Second
+	DEFAULT_LOCK_TTL = 30 * time.Second
+
+	//等待第一个请求完成时查询结果的间隔
+	pollInterval = 50 * time.Millisecond
+)
+
+type contextKey struct{}
+
+//HeaderName 携带幂等key的metadata key, grpc的metadata key都是小写. 为空时使用HEADER
+func HeaderName(name string) string {
+	if len(name) == 0 {
+		return HEADER
+	}
+	return strings.ToLower(name)
+}
+
+//ContextWithKey 把幂等key放入ctx, client调用时通过metadata传给服务端
+func ContextWithKey(ctx context.Context, key string) context.Context {
+	if len(key) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+//KeyFromContext 取出ContextWithKey放入的幂等key
+func KeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(contextKey{}).(string)
+	return key
+}
+
+//Config 一个接口的幂等配置
+type Config struct {
+	Redis   string
+	TTL     time.Duration
+	Wait    time.Duration
+	LockTTL time.Duration
+	Header  string
+}
+
+//NewConfig 通过xml配置创建, 错误信息中带上出错的属性名
+func NewConfig(info *util.IdempotencyInfo) (*Config, error) {
+	conf := &Config{
+		Redis:   info.Redis,
+		TTL:     DEFAULT_TTL,
+		Wait:    DEFAULT_WAIT,
+		LockTTL: DEFAULT_LOCK_TTL,
+		Header:  HeaderName(info.Header),
+	}
+	if len(info.Redis) == 0 {
+		return nil, fmt.Errorf("idempotency: redis required")
+	}
+	var err error
+	if len(info.TTL) > 0 {
+		if conf.TTL, err = time.ParseDuration(info.TTL); err != nil || conf.TTL <= 0 {
+			return nil, fmt.Errorf("idempotency: invalid ttl %s", info.TTL)
+		}
+	}
+	if len(info.Wait) > 0 {
+		if conf.Wait, err = time.ParseDuration(info.Wait); err != nil || conf.Wait < 0 {
+			return nil, fmt.Errorf("idempotency: invalid wait %s", info.Wait)
+		}
+	}
+	if len(info.LockTTL) > 0 {
+		if conf.LockTTL, err = time.ParseDuration(info.LockTTL); err != nil || conf.LockTTL <= 0 {
+			return nil, fmt.Errorf("idempotency: invalid lock-ttl %s", info.LockTTL)
+		}
+	}
+	return conf, nil
+}
+
+//record 保存在store中的内容
+type record struct {
+	State string `json:"state"`
+	//处理中状态的每次处理不同, 续期, 保存结果和删除时比较, 避免修改其他请求的记录
+	Token string `json:"token,omitempty"`
+	//请求内容的hash, 相同的key不同的请求时拒绝
+	Hash string `json:"hash"`
+	//响应的proto类型名和内容
+	Type string `json:"type,omitempty"`
+	Body []byte `json:"body,omitempty"`
+}
+
+//Do 相同key的请求只调用一次next, 重复请求返回保存的结果; 并发的重复请求等待第一个请求完成.
+//next返回错误时不保存结果, 允许重试. store出错时返回Unavailable, 避免重复处理.
+//next处理期间每隔LockTTL/2延长处理中状态的保存时间, 处理时间超过LockTTL也不会被重复处理.
+//续期失败处理中状态过期后, 不再修改该key, 由重新抢到的请求保存结果
+func Do(ctx context.Context, store Store, key string, request interface{}, conf *Config,
+	next func(context.Context, interface{}) (interface{}, error)) (interface{}, error) {
+	hash, err := requestHash(request)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	pending, _ := json.Marshal(&record{State: STATE_PENDING, Hash: hash, Token: util.NewGuid()})
+	deadline := time.Now().Add(conf.Wait)
+	for {
+		ok, err := store.SetNX(ctx, key, pending, conf.LockTTL)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "idempotency store: %s", err)
+		}
+		if ok {
+			return process(ctx, store, key, hash, pending, request, conf, next)
+		}
+		data, err := store.Get(ctx, key)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "idempotency store: %s", err)
+		}
+		//data为空说明第一个请求失败了或者结果过期了, 重新抢
+		if data != nil {
+			rec := new(record)
+			if err := json.Unmarshal(data, rec); err != nil {
+				return nil, status.Errorf(codes.Internal, "idempotency record: %s", err)
+			}
+			if rec.Hash != hash {
+				return nil, status.Error(codes.FailedPrecondition, "idempotency key reused with a different request")
+			}
+			if rec.State == STATE_DONE {
+				return decodeResponse(rec)
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return nil, status.Error(codes.Aborted, "request with the same idempotency key is in progress")
+		}
+		select {
+		case <-ctx.Done():
+			code := codes.Canceled
+			if ctx.Err() == context.DeadlineExceeded {
+				code = codes.DeadlineExceeded
+			}
+			return nil, status.Error(code, ctx.Err().Error())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func process(ctx context.Context, store Store, key, hash string, pending []byte, request interface{}, conf *Config,
+	next func(context.Context, interface{}) (interface{}, error)) (interface{}, error) {
+	//调用方断开后也要释放或保存结果, store操作不使用请求的ctx
+	storeCtx := context.Background()
+	stop := renew(storeCtx, store, key, pending, conf.LockTTL)
+	response, err := next(ctx, request)
+	//保存结果前停止续期, 避免覆盖结果
+	stop()
+	if err != nil {
+		store.CompareAndDel(storeCtx, key, pending)
+		return nil, err
+	}
+	msg, ok := response.(proto.Message)
+	if !ok {
+		store.CompareAndDel(storeCtx, key, pending)
+		return response, nil
+	}
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		store.CompareAndDel(storeCtx, key, pending)
+		return response, nil
+	}
+	data, _ := json.Marshal(&record{State: STATE_DONE, Hash: hash, Type: proto.MessageName(msg), Body: body})
+	//结果已经产生, 保存失败也返回成功, 重复请求会在lock-ttl之后重新处理
+	store.CompareAndSet(storeCtx, key, pending, data, conf.TTL)
+	return response, nil
+}
+
+//renew 定时延长处理中状态的保存时间, 返回停止续期的函数, 返回时续期已经结束.
+//处理中状态已经不是这次处理的(过期后被其他请求抢到)时停止续期
+func renew(ctx context.Context, store Store, key string, pending []byte, ttl time.Duration) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if ok, err := store.CompareAndSet(ctx, key, pending, pending, ttl); err == nil && !ok {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func requestHash(request interface{}) (string, error) {
+	msg, ok := request.(proto.Message)
+	if !ok {
+		return "", fmt.Errorf("idempotency: %T is not a proto.Message", request)
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return util.GetSha256String(string(b)), nil
+}
+
+func decodeResponse(rec *record) (interface{}, error) {
+	tp := proto.MessageType(rec.Type)
+	if tp == nil {
+		return nil, status.Errorf(codes.Internal, "idempotency record: unknown type %s", rec.Type)
+	}
+	msg := reflect.New(tp.Elem()).Interface().(proto.Message)
+	if err := proto.Unmarshal(rec.Body, msg); err != nil {
+		return nil, status.Errorf(codes.Internal, "idempotency record: %s", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,176 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"local/sndaRpc/pb/login"
+	"local/sndaRpc/util"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDo(t *testing.T) {
+	store := NewInMemoryStore()
+	conf, err := NewConfig(&util.IdempotencyInfo{Redis: "redis1", Wait: "2s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	next := func(ctx context.Context, request interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		return &login.LoginReply{SessionId: request.(*login.LoginRequest).GetUserName()}, nil
+	}
+	req := &login.LoginRequest{UserName: "tommy"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := Do(context.Background(), store, "k1", req, conf, next)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if rsp.(*login.LoginReply).GetSessionId() != "tommy" {
+				t.Errorf("unexpected response %v", rsp)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expect 1 call, got %d", calls)
+	}
+
+	_, err = Do(context.Background(), store, "k1", &login.LoginRequest{UserName: "alice"}, conf, next)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expect FailedPrecondition, got %v", err)
+	}
+
+	//失败的请求不保存结果, 可以重试
+	failed := errors.New("failed")
+	_, err = Do(context.Background(), store, "k2", req, conf, func(context.Context, interface{}) (interface{}, error) {
+		return nil, failed
+	})
+	if err != failed {
+		t.Errorf("expect original error, got %v", err)
+	}
+	if _, err = Do(context.Background(), store, "k2", req, conf, next); err != nil || calls != 2 {
+		t.Errorf("retry after failure should be processed: %v %d", err, calls)
+	}
+}
+
+func TestDoInProgress(t *testing.T) {
+	store := NewInMemoryStore()
+	conf, _ := NewConfig(&util.IdempotencyInfo{Redis: "redis1", Wait: "100ms"})
+	req := &login.LoginRequest{UserName: "tommy"}
+	release := make(chan struct{})
+	go Do(context.Background(), store, "k", req, conf, func(context.Context, interface{}) (interface{}, error) {
+		<-release
+		return &login.LoginReply{}, nil
+	})
+	defer close(release)
+	time.Sleep(20 * time.Millisecond)
+	_, err := Do(context.Background(), store, "k", req, conf, func(context.Context, interface{}) (interface{}, error) {
+		t.Error("duplicate should not be processed")
+		return nil, nil
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("expect Aborted, got %v", err)
+	}
+}
+
+func TestDoRenew(t *testing.T) {
+	store := NewInMemoryStore()
+	conf, _ := NewConfig(&util.IdempotencyInfo{Redis: "redis1", Wait: "10ms", LockTTL: "40ms"})
+	req := &login.LoginRequest{UserName: "tommy"}
+	release := make(chan struct{})
+	go Do(context.Background(), store, "k", req, conf, func(context.Context, interface{}) (interface{}, error) {
+		<-release
+		return &login.LoginReply{}, nil
+	})
+	defer close(release)
+	//处理时间超过lock-ttl时仍然是处理中
+	time.Sleep(150 * time.Millisecond)
+	_, err := Do(context.Background(), store, "k", req, conf, func(context.Context, interface{}) (interface{}, error) {
+		t.Error("duplicate should not be processed")
+		return nil, nil
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("expect Aborted, got %v", err)
+	}
+}
+
+//ctxStore 请求的ctx结束后store操作失败
+type ctxStore struct {
+	*InMemoryStore
+}
+
+func (me ctxStore) CompareAndDel(ctx context.Context, key string, old []byte) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	return me.InMemoryStore.CompareAndDel(ctx, key, old)
+}
+
+func TestDoLockLost(t *testing.T) {
+	store := NewInMemoryStore()
+	conf, _ := NewConfig(&util.IdempotencyInfo{Redis: "redis1", Wait: "10ms"})
+	req := &login.LoginRequest{UserName: "tommy"}
+	other := []byte(`{"state":"pending","token":"other"}`)
+	//处理中状态过期后被其他请求抢到, 不能删除或覆盖其他请求的记录
+	for _, fail := range []bool{true, false} {
+		Do(context.Background(), store, "k", req, conf, func(context.Context, interface{}) (interface{}, error) {
+			store.mu.Lock()
+			store.items["k"] = &memoryItem{value: other, expireAt: time.Now().Add(time.Minute)}
+			store.mu.Unlock()
+			if fail {
+				return nil, errors.New("fail")
+			}
+			return &login.LoginReply{}, nil
+		})
+		if data, _ := store.Get(context.Background(), "k"); string(data) != string(other) {
+			t.Errorf("fail %v: record of another request changed to %s", fail, data)
+		}
+		store.items = make(map[string]*memoryItem)
+	}
+
+	//调用方断开后也要删除处理中状态, 允许重试
+	ctx, cancel := context.WithCancel(context.Background())
+	Do(ctx, ctxStore{store}, "k2", req, conf, func(context.Context, interface{}) (interface{}, error) {
+		cancel()
+		return nil, errors.New("fail")
+	})
+	if data, _ := store.Get(context.Background(), "k2"); data != nil {
+		t.Errorf("pending record should be deleted, got %s", data)
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	conf, err := NewConfig(&util.IdempotencyInfo{Redis: "redis1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.TTL != DEFAULT_TTL || conf.Header != HEADER {
+		t.Errorf("unexpected defaults %+v", conf)
+	}
+	if conf, _ := NewConfig(&util.IdempotencyInfo{Redis: "redis1", Header: "X-Request-Key"}); conf.Header != "x-request-key" {
+		t.Errorf("header should be lower case, got %s", conf.Header)
+	}
+	for attr, info := range map[string]*util.IdempotencyInfo{
+		"redis":    {},
+		"ttl":      {Redis: "r", TTL: "1d"},
+		"wait":     {Redis: "r", Wait: "x"},
+		"lock-ttl": {Redis: "r", LockTTL: "0s"},
+	} {
+		if _, err := NewConfig(info); err == nil {
+			t.Errorf("invalid %s should fail", attr)
+		}
+	}
+}
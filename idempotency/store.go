@@ -0,0 +1,155 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"local/sndaRpc/cache"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//Store 保存幂等请求的状态和结果
+type Store interface {
+	//SetNX key不存在时设置, 返回是否设置成功
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	//Get key不存在时返回nil, nil
+	Get(ctx context.Context, key string) ([]byte, error)
+	//CompareAndSet key的值等于old时设置为value, 返回是否设置成功
+	CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error)
+	//CompareAndDel key的值等于old时删除, 返回是否删除成功
+	CompareAndDel(ctx context.Context, key string, old []byte) (bool, error)
+}
+
+//compareAndSetScript 值没有被其他请求修改时设置
+var compareAndSetScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+	return 1
+end
+return 0
+`)
+
+//compareAndDelScript 值没有被其他请求修改时删除
+var compareAndDelScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+//RedisStore 使用RedisManager中注册的redis
+type RedisStore struct {
+	redisName string
+}
+
+//NewRedisStore redisName 对应<redis>的name
+func NewRedisStore(redisName string) *RedisStore {
+	return &RedisStore{redisName: redisName}
+}
+
+func (me *RedisStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	client, err := cache.DefaultRedisManager().GetContext(ctx, me.redisName)
+	if err != nil {
+		return false, err
+	}
+	return client.SetNX(key, value, ttl).Result()
+}
+
+func (me *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	client, err := cache.DefaultRedisManager().GetContext(ctx, me.redisName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := client.Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (me *RedisStore) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	client, err := cache.DefaultRedisManager().GetContext(ctx, me.redisName)
+	if err != nil {
+		return false, err
+	}
+	n, err := compareAndSetScript.Run(client, []string{key}, old, value, int64(ttl/time.Millisecond)).Int64()
+	return n == 1, err
+}
+
+func (me *RedisStore) CompareAndDel(ctx context.Context, key string, old []byte) (bool, error) {
+	client, err := cache.DefaultRedisManager().GetContext(ctx, me.redisName)
+	if err != nil {
+		return false, err
+	}
+	n, err := compareAndDelScript.Run(client, []string{key}, old).Int64()
+	return n == 1, err
+}
+
+type memoryItem struct {
+	value    []byte
+	expireAt time.Time
+}
+
+//InMemoryStore 保存在内存中, 只对单进程有效, 用于测试
+type InMemoryStore struct {
+	mu    sync.Mutex
+	items map[string]*memoryItem
+}
+
+//NewInMemoryStore 创建InMemoryStore
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{items: make(map[string]*memoryItem)}
+}
+
+func (me *InMemoryStore) get(key string) *memoryItem {
+	item, ok := me.items[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(item.expireAt) {
+		delete(me.items, key)
+		return nil
+	}
+	return item
+}
+
+func (me *InMemoryStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.get(key) != nil {
+		return false, nil
+	}
+	me.items[key] = &memoryItem{value: value, expireAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (me *InMemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if item := me.get(key); item != nil {
+		return item.value, nil
+	}
+	return nil, nil
+}
+
+func (me *InMemoryStore) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if item := me.get(key); item == nil || !bytes.Equal(item.value, old) {
+		return false, nil
+	}
+	me.items[key] = &memoryItem{value: value, expireAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (me *InMemoryStore) CompareAndDel(ctx context.Context, key string, old []byte) (bool, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if item := me.get(key); item == nil || !bytes.Equal(item.value, old) {
+		return false, nil
+	}
+	delete(me.items, key)
+	return true, nil
+}
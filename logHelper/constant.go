@@ -11,4 +11,6 @@ const (
 	DB = "db"
 	// GATE_WAY记录网关日志
 	GATE_WAY = "gate_way"
+	// AUDIT 记录授权拒绝等审计日志
+	AUDIT = "audit"
 )
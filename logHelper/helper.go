@@ -7,18 +7,58 @@ import (
 	"io"
 	"local/sndaRpc/util"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 )
 
 var (
-	logMap map[string]log.Logger
+	logMu  sync.RWMutex
+	logMap map[string]*levelLogger
 )
 
 func init() {
-	logMap = make(map[string]log.Logger)
+	logMap = make(map[string]*levelLogger)
+}
+
+//levelLogger 可以在运行时修改级别的logger
+type levelLogger struct {
+	base     log.Logger
+	mu       sync.RWMutex
+	level    string
+	filtered log.Logger
+}
+
+func (me *levelLogger) Log(keyvals ...interface{}) error {
+	me.mu.RLock()
+	filtered := me.filtered
+	me.mu.RUnlock()
+	return filtered.Log(keyvals...)
+}
+
+func (me *levelLogger) setLevel(logLevel string) {
+	logLevel = strings.ToLower(logLevel)
+	var filtered log.Logger
+	switch logLevel {
+	case "error":
+		filtered = level.NewFilter(me.base, level.AllowError())
+	case "warn":
+		filtered = level.NewFilter(me.base, level.AllowWarn())
+	case "info":
+		filtered = level.NewFilter(me.base, level.AllowInfo())
+	case "debug":
+		filtered = level.NewFilter(me.base, level.AllowDebug())
+	default:
+		logLevel = "all"
+		filtered = level.NewFilter(me.base, level.AllowAll())
+	}
+	me.mu.Lock()
+	me.level = logLevel
+	me.filtered = filtered
+	me.mu.Unlock()
 }
 
 //logKey 记录日志用的key
@@ -57,7 +97,9 @@ func ContextWithNewLogInfo(ctx context.Context) context.Context {
 // fileName 为日志文件名
 // logLevel为日志级别 取值有: error, warn, info, debug, all.
 func Register(name string, fileName string, logLevel string) (log.Logger, error) {
+	logMu.RLock()
 	_, ok := logMap[name]
+	logMu.RUnlock()
 	if ok {
 		return nil, errors.New("log" + name + "exist")
 	}
@@ -70,47 +112,79 @@ func Register(name string, fileName string, logLevel string) (log.Logger, error)
 }
 
 func RegisterByWriter(name string, w io.Writer, logLevel string) (log.Logger, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
 	_, ok := logMap[name]
 	if ok {
 		return nil, fmt.Errorf("log %s exist already", name)
 	}
-	logger := log.NewLogfmtLogger(w)
+	logger := &levelLogger{base: log.NewLogfmtLogger(w)}
 	//logger := log.NewJSONLogger(w)
-	logLevel = strings.ToLower(logLevel)
-	switch logLevel {
-	case "error":
-		logger = level.NewFilter(logger, level.AllowError())
-	case "warn":
-		logger = level.NewFilter(logger, level.AllowWarn())
-	case "info":
-		logger = level.NewFilter(logger, level.AllowInfo())
-	case "debug":
-		logger = level.NewFilter(logger, level.AllowDebug())
-	default:
-		logger = level.NewFilter(logger, level.AllowAll())
-	}
+	logger.setLevel(logLevel)
 	logMap[name] = logger
 	return logger, nil
 }
 
+//SetLevel 修改已注册的日志的级别, 立即生效. 取值有: error, warn, info, debug, all
+func SetLevel(name string, logLevel string) error {
+	switch strings.ToLower(logLevel) {
+	case "error", "warn", "info", "debug", "all":
+	default:
+		return fmt.Errorf("invalid log level %s", logLevel)
+	}
+	logMu.RLock()
+	logger, ok := logMap[name]
+	logMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("log %s not found", name)
+	}
+	logger.setLevel(logLevel)
+	return nil
+}
+
+//LevelInfo 日志名和当前级别
+type LevelInfo struct {
+	Name  string `json:"name"`
+	Level string `json:"level"`
+}
+
+//Levels 返回全部已注册日志的当前级别, 按名字排序
+func Levels() []*LevelInfo {
+	logMu.RLock()
+	list := make([]*LevelInfo, 0, len(logMap))
+	for name, logger := range logMap {
+		logger.mu.RLock()
+		list = append(list, &LevelInfo{Name: name, Level: logger.level})
+		logger.mu.RUnlock()
+	}
+	logMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
 func Logger(name string) log.Logger {
-	return logMap[name]
+	logMu.RLock()
+	defer logMu.RUnlock()
+	if logger, ok := logMap[name]; ok {
+		return logger
+	}
+	return nil
 }
 
 func Debug(name string) log.Logger {
-	return level.Debug(logMap[name])
+	return level.Debug(Logger(name))
 }
 
 func Error(name string) log.Logger {
-	return level.Error(logMap[name])
+	return level.Error(Logger(name))
 }
 
 func Info(name string) log.Logger {
-	return level.Info(logMap[name])
+	return level.Info(Logger(name))
 }
 
 func Warn(name string) log.Logger {
-	return level.Warn(logMap[name])
+	return level.Warn(Logger(name))
 }
 
 //func Debug( name string, args ... interface{}) error{
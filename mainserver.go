@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"local/sndaRpc/admin"
+	"local/sndaRpc/auth"
 	"local/sndaRpc/cache"
 	"local/sndaRpc/client"
 	"local/sndaRpc/dbutil"
@@ -9,7 +11,9 @@ import (
 	"local/sndaRpc/logHelper"
 	"local/sndaRpc/server"
 	_ "local/sndaRpc/service"
+	"local/sndaRpc/trace"
 	"local/sndaRpc/util"
+	"local/sndaRpc/validate"
 	"os"
 
 	"github.com/astaxie/beego"
@@ -32,6 +36,9 @@ func main() {
 		panic(fmt.Sprintf("init log error: %s", err))
 	}
 	logger := logHelper.Logger(logHelper.ALL)
+	if err = initTrace(); err != nil {
+		level.Error(logger).Log("error", fmt.Sprintf("init trace error:%s", err))
+	}
 	if err = initMysql(); err != nil {
 		level.Error(logger).Log("error", fmt.Sprintf("init mysql error:%s", err))
 	}
@@ -41,7 +48,20 @@ func main() {
 	if err = initClient(); err != nil {
 		level.Error(logger).Log("error", fmt.Sprintf("init client error:%s", err))
 	}
+	if err = initAuth(); err != nil {
+		panic(fmt.Sprintf("init auth error: %s", err))
+	}
+	if err = validate.RegisterByConfig(xmlconf.ServiceList); err != nil {
+		panic(fmt.Sprintf("init validate error: %s", err))
+	}
+	if err = initAdmin(); err != nil {
+		panic(fmt.Sprintf("init admin error: %s", err))
+	}
 	// level.Error(logger).Log("error", startServer())
+	if beego.AppConfig.DefaultBool("mux.enable", false) {
+		startMuxServer()
+		return
+	}
 	var wg sync.WaitGroup
 	wg.Add(3)
 	go func(wg *sync.WaitGroup){
@@ -111,6 +131,29 @@ func initLog() error {
 	if err != nil {
 		return err
 	}
+	_, err = logHelper.Register(
+		logHelper.AUDIT,
+		beego.AppConfig.DefaultString("log.path.audit", "logs/audit.log"),
+		beego.AppConfig.DefaultString("log.level.audit", "info"),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//初始化span exporter. trace.exporter取值: file(写jsonl文件), 其他值不导出
+func initTrace() error {
+	switch beego.AppConfig.DefaultString("trace.exporter", "") {
+	case "file":
+		exporter, err := trace.NewFileExporter(beego.AppConfig.DefaultString("trace.path", "logs/trace.log"))
+		if err != nil {
+			return err
+		}
+		trace.SetExporter(exporter)
+	default:
+		trace.SetExporter(nil)
+	}
 	return nil
 }
 
@@ -166,32 +209,149 @@ func initClient() error {
 	return nil
 }
 
+//注册<auth>中配置的认证方式
+func initAuth() error {
+	for _, authInfo := range xmlconf.AuthList {
+		if err := auth.RegisterByConfig(authInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//在监控端口上注册/admin/管理接口, admin.auth为空时不启用
+func initAdmin() error {
+	names := auth.SplitNames(beego.AppConfig.DefaultString("admin.auth", ""))
+	if len(names) == 0 {
+		level.Warn(logHelper.Logger(logHelper.ALL)).Log("msg", "admin api disabled, admin.auth is empty")
+		return nil
+	}
+	adm, err := admin.New(names, beego.AppConfig.DefaultString("admin.role", ""))
+	if err != nil {
+		return err
+	}
+	adm.SetLogger(logHelper.Logger(logHelper.AUDIT))
+	adm.SetServer(server.DefaultGRPCServer())
+	adm.SetGateway(gateway.DefaultHTTPGateWay())
+	adm.SetClient(client.DefaultGRPCClient())
+	adm.SetReload(reloadConfig)
+	http.Handle(admin.PREFIX, adm)
+	return nil
+}
+
+//重新加载xml中<service>的策略配置(认证, 授权, 限流, 幂等, 入参校验), 其他配置需要重启.
+//<auth>中的认证方式定义不重新加载, 新增或修改需要重启, <service>引用未注册的认证方式时加载失败.
+//全部配置校验通过后才生效, 有错误时保持原来的配置
+func reloadConfig() error {
+	conf, err := util.LoadXMLConfig(beego.AppConfig.DefaultString("xmlconf", "conf/config.xml"))
+	if err != nil {
+		return err
+	}
+	rulesByMethod, err := validate.RulesByConfig(conf.ServiceList)
+	if err != nil {
+		return err
+	}
+	//SetServiceConfig校验失败时不修改配置
+	if err := server.DefaultGRPCServer().SetServiceConfig(conf.ServiceList); err != nil {
+		return err
+	}
+	//删除了<validate>的接口也不再校验
+	validate.ReplaceAll(rulesByMethod)
+	return nil
+}
+
+//注册grpc服务
+func initGRPCServer() (server.Server, error) {
+	var grpcServer server.Server = server.DefaultGRPCServer()
+	grpcServer.SetLogger(logHelper.Logger(logHelper.REQUEST_IN))
+	grpcServer.SetAuditLogger(logHelper.Logger(logHelper.AUDIT))
+	logger := logHelper.Logger(logHelper.ALL)
+	opts, err := server.OptionsByConfig(xmlconf.GRPCServer)
+	if err != nil {
+		return nil, err
+	}
+	if err := grpcServer.SetOptions(opts...); err != nil {
+		return nil, err
+	}
+	if err := grpcServer.SetServiceConfig(xmlconf.ServiceList); err != nil {
+		return nil, fmt.Errorf("load service config: %s", err)
+	}
+	for _, srvInfo := range xmlconf.ServiceList {
+		if enabled, _ := srvInfo.IsEnabled(); !enabled {
+			level.Warn(logger).Log("msg", "service disabled", "service", srvInfo.Name)
+		}
+		if err := grpcServer.RegisterByConfig(srvInfo); err != nil {
+			return nil, fmt.Errorf("register service: %s", err)
+		}
+	}
+	return grpcServer, nil
+}
+
 //启动grpc服务
 func startServer() error {
 	addr := beego.AppConfig.DefaultString("rpcaddr", ":8081")
-	var grpcServer server.Server = server.DefaultGRPCServer()
-	grpcServer.SetLogger(logHelper.Logger(logHelper.REQUEST_IN))
 	logger := logHelper.Logger(logHelper.ALL)
-	//for _, srvInfo := range xmlconf.ServiceList {
-	//	err := grpcServer.RegisterByConfig(srvInfo)
-	//	if err != nil {
-	//		level.Error(logger).Log("during", "register service", "err", err)
-	//		os.Exit(1)
-	//	}
-	//}
+	grpcServer, err := initGRPCServer()
+	if err != nil {
+		level.Error(logger).Log("during", "init grpc server", "err", err)
+		os.Exit(1)
+	}
 	level.Warn(logger).Log("msg", "gRPC server start success", "addr", addr)
 	level.Error(logger).Log("error", grpcServer.Serve(addr))
 	return nil
 }
 
-func startHTTPGateway() error {
-	gatewayLogger := logHelper.Logger(logHelper.GATE_WAY)
-	allLogger := logHelper.Logger(logHelper.ALL)
+//注册http网关
+func initHTTPGateway() (gateway.GateWay, error) {
 	gw := gateway.DefaultHTTPGateWay()
-	if err := gw.SetLogger(gatewayLogger); nil != err {
-		return err
+	if err := gw.SetLogger(logHelper.Logger(logHelper.GATE_WAY)); nil != err {
+		return nil, err
+	}
+	opts, err := gateway.OptionsByConfig(xmlconf.HTTPJSON)
+	if err != nil {
+		return nil, err
+	}
+	title, version := beego.AppConfig.String("appname"), ""
+	if docs := xmlconf.HTTPDocs; docs != nil {
+		if len(docs.Title) > 0 {
+			title = docs.Title
+		}
+		version = docs.Version
+		enabled, err := docs.IsEnabled()
+		if err != nil {
+			return nil, fmt.Errorf("docs: invalid enabled %s", docs.Enabled)
+		}
+		authNames := auth.SplitNames(docs.Auth)
+		for _, name := range authNames {
+			if _, ok := auth.Get(name); !ok {
+				return nil, fmt.Errorf("docs: auth %s not found", name)
+			}
+		}
+		opts = append(opts, gateway.DocsUI(docs.UI), gateway.DocsEnabled(enabled), gateway.DocsAuth(authNames))
 	}
-	err := gw.Register(xmlconf.HTTPGateWayList)
+	opts = append(opts, gateway.DocsInfo(title, version))
+	securityOpts, err := gateway.SecurityOptionsByConfig(xmlconf.HTTPCORSList, xmlconf.HTTPSecurity)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, securityOpts...)
+	if err := gw.SetOptions(opts...); err != nil {
+		return nil, err
+	}
+	if err := gw.Register(xmlconf.HTTPGateWayList); err != nil {
+		return nil, err
+	}
+	for _, protoName := range xmlconf.HTTPProtoList {
+		if err := gw.RegisterByProto(protoName); err != nil {
+			return nil, err
+		}
+	}
+	return gw, nil
+}
+
+func startHTTPGateway() error {
+	allLogger := logHelper.Logger(logHelper.ALL)
+	gw, err := initHTTPGateway()
 	if err != nil {
 		level.Error(allLogger).Log("error", "did not init http gateway", "reason", err)
 		return err
@@ -202,6 +362,32 @@ func startHTTPGateway() error {
 	return nil
 }
 
+//grpc, http网关, metrics/pprof共用一个端口. http请求中/metrics, /debug/pprof/等默认路由优先, 其余交给网关
+func startMuxServer() error {
+	addr := beego.AppConfig.DefaultString("muxaddr", ":8081")
+	logger := logHelper.Logger(logHelper.ALL)
+	grpcServer, err := initGRPCServer()
+	if err != nil {
+		level.Error(logger).Log("during", "init grpc server", "err", err)
+		os.Exit(1)
+	}
+	gw, err := initHTTPGateway()
+	if err != nil {
+		level.Error(logger).Log("error", "did not init http gateway", "reason", err)
+		return err
+	}
+	gwHandler := gw.Handler()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := http.DefaultServeMux.Handler(r); len(pattern) > 0 {
+			http.DefaultServeMux.ServeHTTP(w, r)
+			return
+		}
+		gwHandler.ServeHTTP(w, r)
+	})
+	level.Warn(logger).Log("msg", "mux server start success", "addr", addr)
+	level.Error(logger).Log("error", grpcServer.ServeMux(addr, handler))
+	return nil
+}
 
 //启动grpc服务
 func startDefaultHttpServer() error {
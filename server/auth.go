@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"local/sndaRpc/auth"
+	"local/sndaRpc/logHelper"
+	"net"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//authParams 按<method auth="">或<service auth="">的配置校验调用方身份,
+//通过后把auth.Principal放入context, handler中用auth.FromContext获取
+func (me *GRPCServer) authParams(next endpoint.Endpoint) (endpoint.Endpoint, error) {
+	var ep endpoint.Endpoint = func(ctx context.Context, request interface{}) (interface{}, error) {
+		fullMethod := methodFromContext(ctx)
+		names := me.authNames(fullMethod)
+		if len(names) == 0 {
+			return next(ctx, request)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		principal, err := auth.Verify(ctx, names, md, fullMethod)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return next(auth.ContextWithPrincipal(ctx, principal), request)
+	}
+	return ep, nil
+}
+
+//authNames method上的配置优先, 没有时使用service上的
+func (me *GRPCServer) authNames(fullMethod string) []string {
+	serverInfo, methodInfo := me.methodConfig(fullMethod)
+	if methodInfo != nil && len(methodInfo.Auth) > 0 {
+		return auth.SplitNames(methodInfo.Auth)
+	}
+	if serverInfo != nil {
+		return auth.SplitNames(serverInfo.Auth)
+	}
+	return nil
+}
+
+//checkAuthNames 配置的认证方式要先在auth中注册, 避免配置错误时每个请求都认证失败
+func checkAuthNames(s string) error {
+	for _, name := range auth.SplitNames(s) {
+		if _, ok := auth.Get(name); !ok {
+			return fmt.Errorf("auth %s not found", name)
+		}
+	}
+	return nil
+}
+
+//SetAuditLogger 设置记录授权拒绝的审计日志
+func (me *GRPCServer) SetAuditLogger(lg log.Logger) error {
+	if nil == lg {
+		return fmt.Errorf("nil logger not allow")
+	}
+	me.auditLogger = lg
+	return nil
+}
+
+//authzParams 在调用业务方法前按<allow>/<deny>规则检查调用方身份和对端地址,
+//拒绝时返回PermissionDenied并记录审计日志
+func (me *GRPCServer) authzParams(next endpoint.Endpoint) (endpoint.Endpoint, error) {
+	var ep endpoint.Endpoint = func(ctx context.Context, request interface{}) (interface{}, error) {
+		fullMethod := methodFromContext(ctx)
+		policy := me.policy(fullMethod)
+		if policy == nil || policy.IsEmpty() {
+			return next(ctx, request)
+		}
+		principal, _ := auth.FromContext(ctx)
+		var addr net.Addr
+		if pr, ok := peer.FromContext(ctx); ok {
+			addr = pr.Addr
+		}
+		allowed, reason := policy.Evaluate(principal, auth.IPFromAddr(addr))
+		if !allowed {
+			me.audit(ctx, fullMethod, principal, addr, reason)
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		return next(ctx, request)
+	}
+	return ep, nil
+}
+
+//audit 记录一次拒绝
+func (me *GRPCServer) audit(ctx context.Context, fullMethod string, principal *auth.Principal, addr net.Addr, reason string) {
+	auditLogger := log.With(me.auditLogger, "ts", log.TimestampFormat(time.Now().Local, "2006-01-02 15:04:05.000.000000"))
+	auditLogger = log.With(auditLogger, "decision", "deny", "method", fullMethod)
+	if principal != nil {
+		auditLogger = log.With(auditLogger, "principal", principal.ID, "scheme", principal.Scheme)
+	}
+	if addr != nil {
+		auditLogger = log.With(auditLogger, "addr", addr.String())
+	}
+	if logInfo, ok := logHelper.FromContext(ctx); ok {
+		auditLogger = log.With(auditLogger, "flowID", logInfo.FlowID)
+	}
+	level.Warn(auditLogger).Log("reason", reason)
+}
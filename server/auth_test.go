@@ -0,0 +1,26 @@
+package server
+
+import (
+	"local/sndaRpc/auth"
+	"local/sndaRpc/util"
+	"testing"
+)
+
+func TestAuthConfig(t *testing.T) {
+	auth.Register("server-test", auth.NewAPIKeyVerifier("", []*util.AuthKey{{ID: "svc", Value: "k"}}))
+	me := NewGRPCServer()
+	serverInfo := &util.ServerInfo{Name: "/login.loginService", Auth: "server-test"}
+	serverInfo.MethodList = []*util.MethodInfo{{Name: "login", Auth: "server-test, missing"}}
+	if err := me.SetServiceConfig([]*util.ServerInfo{serverInfo}); err == nil {
+		t.Error("unknown method auth should fail SetServiceConfig")
+	}
+	serverInfo.MethodList[0].Auth = "server-test"
+	serverInfo.Auth = "missing"
+	if err := me.SetServiceConfig([]*util.ServerInfo{serverInfo}); err == nil {
+		t.Error("unknown service auth should fail SetServiceConfig")
+	}
+	serverInfo.Auth = "server-test"
+	if err := me.SetServiceConfig([]*util.ServerInfo{serverInfo}); err != nil {
+		t.Fatal(err)
+	}
+}
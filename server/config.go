@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"local/sndaRpc/auth"
+	"local/sndaRpc/idempotency"
+	"local/sndaRpc/util"
+	"strings"
+)
+
+//SetServiceConfig 设置<service>配置.
+//通过代码注册的服务也可以在xml里配置auth, allow/deny, 限流等策略, 请求时按接口名查找
+func (me *GRPCServer) SetServiceConfig(serverInfoList []*util.ServerInfo) error {
+	serviceConf := make(map[string]*util.ServerInfo)
+	policies := make(map[string]*auth.Policy)
+	idempotencyConf := make(map[string]*idempotency.Config)
+	limiters := make(map[string]*methodLimiter)
+	for _, serverInfo := range serverInfoList {
+		serviceConf[serverInfo.Name] = serverInfo
+		if err := checkAuthNames(serverInfo.Auth); err != nil {
+			return fmt.Errorf("service %s: %s", serverInfo.Name, err)
+		}
+		//没有<method>配置的接口在第一次调用时按service级别的配置创建, 这里先校验
+		if _, err := newMethodLimiter(serverInfo.LimitInfo); err != nil {
+			return fmt.Errorf("service %s: %s", serverInfo.Name, err)
+		}
+		//没有<method>配置的接口使用service级别的策略
+		policy, err := auth.NewPolicy(serverInfo, nil)
+		if err != nil {
+			return fmt.Errorf("service %s: %s", serverInfo.Name, err)
+		}
+		policies[serverInfo.Name] = policy
+		for _, methodInfo := range serverInfo.MethodList {
+			if err := checkAuthNames(methodInfo.Auth); err != nil {
+				return fmt.Errorf("service %s method %s: %s", serverInfo.Name, methodInfo.Name, err)
+			}
+			policy, err := auth.NewPolicy(serverInfo, methodInfo)
+			if err != nil {
+				return fmt.Errorf("service %s method %s: %s", serverInfo.Name, methodInfo.Name, err)
+			}
+			policies[serverInfo.Name+"/"+methodInfo.Name] = policy
+			limiter, err := newMethodLimiter(serverInfo.LimitInfo.Merge(&methodInfo.LimitInfo))
+			if err != nil {
+				return fmt.Errorf("service %s method %s: %s", serverInfo.Name, methodInfo.Name, err)
+			}
+			limiters[serverInfo.Name+"/"+methodInfo.Name] = limiter
+			if methodInfo.Idempotency != nil {
+				conf, err := idempotency.NewConfig(methodInfo.Idempotency)
+				if err != nil {
+					return fmt.Errorf("service %s method %s: %s", serverInfo.Name, methodInfo.Name, err)
+				}
+				idempotencyConf[serverInfo.Name+"/"+methodInfo.Name] = conf
+			}
+		}
+	}
+	me.confMu.Lock()
+	me.serviceConf = serviceConf
+	me.policies = policies
+	me.idempotency = idempotencyConf
+	me.confMu.Unlock()
+	//配置变化后重新统计
+	me.limitMu.Lock()
+	me.limiters = limiters
+	me.limitMu.Unlock()
+	return nil
+}
+
+//methodConfig 通过完整接口名查找配置, 如 /login.loginService/login
+//没有配置时返回nil
+func (me *GRPCServer) methodConfig(fullMethod string) (*util.ServerInfo, *util.MethodInfo) {
+	idx := strings.LastIndex(fullMethod, "/")
+	if idx <= 0 {
+		return nil, nil
+	}
+	me.confMu.RLock()
+	serverInfo, ok := me.serviceConf[fullMethod[:idx]]
+	me.confMu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	methodName := fullMethod[idx+1:]
+	for _, method := range serverInfo.MethodList {
+		if method.Name == methodName {
+			return serverInfo, method
+		}
+	}
+	return serverInfo, nil
+}
+
+//policy 查找接口的授权策略, 没有配置时返回nil
+func (me *GRPCServer) policy(fullMethod string) *auth.Policy {
+	me.confMu.RLock()
+	defer me.confMu.RUnlock()
+	if policy, ok := me.policies[fullMethod]; ok {
+		return policy
+	}
+	if idx := strings.LastIndex(fullMethod, "/"); idx > 0 {
+		return me.policies[fullMethod[:idx]]
+	}
+	return nil
+}
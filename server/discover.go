@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	protobuf "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"golang.org/x/net/context"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+//RegisterService 注册服务, 方法名, 入参和出参类型从proto生成的文件描述中自动获取
+//@param
+//handlerInterface 业务类必须实现的一个接口, proto生成的XXXServer. 如 (*login.LoginServiceServer)(nil)
+//handlerCls 实现了 handlerInterface 接口的具体业务类. 如 TestService{}
+//protoName 对应的*.proto定义文件. 如loginService.proto
+//@return error 业务类缺少方法或者方法签名与proto不一致时返回错误
+func (me *GRPCServer) RegisterService(handlerInterface, handlerCls interface{}, protoName string) error {
+	serviceName, methodList, err := DiscoverMethods(handlerInterface, handlerCls, protoName)
+	if err != nil {
+		return err
+	}
+	return me.Register(handlerInterface, handlerCls, serviceName, protoName, methodList...)
+}
+
+//DiscoverMethods 根据proto文件描述找到handlerInterface对应的service, 返回服务名和所有方法信息,
+//同时检查handlerCls是否实现了全部方法
+func DiscoverMethods(handlerInterface, handlerCls interface{}, protoName string) (string, []*MethodInfo, error) {
+	ifaceType := reflect.TypeOf(handlerInterface)
+	if ifaceType == nil || ifaceType.Kind() != reflect.Ptr || ifaceType.Elem().Kind() != reflect.Interface {
+		return "", nil, fmt.Errorf("handlerInterface must be a pointer to interface, such as (*login.LoginServiceServer)(nil)")
+	}
+	ifaceType = ifaceType.Elem()
+	fd, err := FileDescriptor(protoName)
+	if err != nil {
+		return "", nil, err
+	}
+	var sd *protobuf.ServiceDescriptorProto
+	for _, service := range fd.GetService() {
+		if camelCase(service.GetName())+"Server" == ifaceType.Name() {
+			sd = service
+			break
+		}
+	}
+	if sd == nil {
+		return "", nil, fmt.Errorf("%s: no service matches interface %s", protoName, ifaceType.Name())
+	}
+	serviceName := "/" + sd.GetName()
+	if len(fd.GetPackage()) > 0 {
+		serviceName = "/" + fd.GetPackage() + "." + sd.GetName()
+	}
+	if ifaceType.NumMethod() != len(sd.GetMethod()) {
+		return "", nil, fmt.Errorf("%s: interface %s has %d methods but service %s defines %d",
+			protoName, ifaceType.Name(), ifaceType.NumMethod(), serviceName, len(sd.GetMethod()))
+	}
+	clsType := reflect.TypeOf(handlerCls)
+	if clsType == nil {
+		return "", nil, fmt.Errorf("nil handlerCls")
+	}
+	if clsType.Kind() != reflect.Ptr {
+		clsType = reflect.PtrTo(clsType)
+	}
+	var methodList []*MethodInfo
+	for _, md := range sd.GetMethod() {
+		if md.GetClientStreaming() || md.GetServerStreaming() {
+			return "", nil, fmt.Errorf("%s/%s: streaming method is not supported", serviceName, md.GetName())
+		}
+		reqType, err := messageType(md.GetInputType())
+		if err != nil {
+			return "", nil, fmt.Errorf("%s/%s: %s", serviceName, md.GetName(), err)
+		}
+		rspType, err := messageType(md.GetOutputType())
+		if err != nil {
+			return "", nil, fmt.Errorf("%s/%s: %s", serviceName, md.GetName(), err)
+		}
+		goName := camelCase(md.GetName())
+		ifaceMethod, ok := ifaceType.MethodByName(goName)
+		if !ok {
+			return "", nil, fmt.Errorf("interface %s has no method %s for %s/%s", ifaceType.Name(), goName, serviceName, md.GetName())
+		}
+		if err := checkSignature(ifaceMethod.Type, reqType, rspType); err != nil {
+			return "", nil, fmt.Errorf("interface %s method %s: %s", ifaceType.Name(), goName, err)
+		}
+		clsMethod, ok := clsType.MethodByName(goName)
+		if !ok {
+			return "", nil, fmt.Errorf("handler %s is missing method %s", clsType.Elem().Name(), goName)
+		}
+		//去掉receiver再比较
+		if err := checkSignature(methodTypeWithoutReceiver(clsMethod.Type), reqType, rspType); err != nil {
+			return "", nil, fmt.Errorf("handler %s method %s: %s", clsType.Elem().Name(), goName, err)
+		}
+		methodList = append(methodList, &MethodInfo{md.GetName(), reqType.Elem(), rspType.Elem()})
+	}
+	if !clsType.Implements(ifaceType) {
+		return "", nil, fmt.Errorf("handler %s does not implement %s", clsType.Elem().Name(), ifaceType.Name())
+	}
+	return serviceName, methodList, nil
+}
+
+//checkSignature 检查方法是否为 func(context.Context, *Req) (*Rsp, error)
+func checkSignature(tp reflect.Type, reqType, rspType reflect.Type) error {
+	if tp.NumIn() != 2 || tp.In(0) != contextType || tp.In(1) != reqType {
+		return fmt.Errorf("expect parameters (context.Context, %s)", reqType)
+	}
+	if tp.NumOut() != 2 || tp.Out(0) != rspType || tp.Out(1) != errorType {
+		return fmt.Errorf("expect results (%s, error)", rspType)
+	}
+	return nil
+}
+
+func methodTypeWithoutReceiver(tp reflect.Type) reflect.Type {
+	in := make([]reflect.Type, 0, tp.NumIn()-1)
+	for i := 1; i < tp.NumIn(); i++ {
+		in = append(in, tp.In(i))
+	}
+	out := make([]reflect.Type, 0, tp.NumOut())
+	for i := 0; i < tp.NumOut(); i++ {
+		out = append(out, tp.Out(i))
+	}
+	return reflect.FuncOf(in, out, tp.IsVariadic())
+}
+
+//messageType 通过proto中的全名(如 .login.loginRequest)找到go类型(指针)
+func messageType(name string) (reflect.Type, error) {
+	name = strings.TrimPrefix(name, ".")
+	tp := proto.MessageType(name)
+	if tp == nil {
+		return nil, fmt.Errorf("unknown type %s", name)
+	}
+	return tp, nil
+}
+
+//FileDescriptor 读取proto.RegisterFile注册的文件描述, 如 loginService.proto
+func FileDescriptor(protoName string) (*protobuf.FileDescriptorProto, error) {
+	gz := proto.FileDescriptor(protoName)
+	if gz == nil {
+		return nil, fmt.Errorf("proto file %s is not registered", protoName)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	fd := new(protobuf.FileDescriptorProto)
+	if err := proto.Unmarshal(b, fd); err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+//camelCase 与protoc-gen-go的命名规则一致: 首字母大写, 下划线后的字母大写
+func camelCase(s string) string {
+	var buf bytes.Buffer
+	upper := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' && i+1 < len(s) && s[i+1] >= 'a' && s[i+1] <= 'z' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"local/sndaRpc/client"
+	"local/sndaRpc/inject"
+	"local/sndaRpc/pb/login"
+	"local/sndaRpc/util"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	protobuf "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"golang.org/x/net/context"
+)
+
+type fullLoginService struct{}
+
+func (s *fullLoginService) Login(ctx context.Context, in *login.LoginRequest) (*login.LoginReply, error) {
+	return &login.LoginReply{SessionId: in.GetUserName()}, nil
+}
+
+func (s *fullLoginService) Logout(ctx context.Context, in *login.LogoutRequest) (*login.LogoutReply, error) {
+	return nil, nil
+}
+
+type partialLoginService struct{}
+
+func (s *partialLoginService) Login(ctx context.Context, in *login.LoginRequest) (*login.LoginReply, error) {
+	return nil, nil
+}
+
+type wrongLoginService struct{}
+
+func (s *wrongLoginService) Login(ctx context.Context, in *login.LoginRequest) (*login.LoginReply, error) {
+	return nil, nil
+}
+
+func (s *wrongLoginService) Logout(ctx context.Context, in *login.LogoutRequest) (*login.LogoutRequest, error) {
+	return nil, nil
+}
+
+func TestDiscoverMethods(t *testing.T) {
+	serviceName, methodList, err := DiscoverMethods((*login.LoginServiceServer)(nil), fullLoginService{}, "loginService.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serviceName != "/login.loginService" {
+		t.Errorf("unexpected service name %s", serviceName)
+	}
+	expect := map[string][2]reflect.Type{
+		"login":  {reflect.TypeOf(login.LoginRequest{}), reflect.TypeOf(login.LoginReply{})},
+		"logout": {reflect.TypeOf(login.LogoutRequest{}), reflect.TypeOf(login.LogoutReply{})},
+	}
+	if len(methodList) != len(expect) {
+		t.Fatalf("expect %d methods, got %d", len(expect), len(methodList))
+	}
+	for _, method := range methodList {
+		types, ok := expect[method.Name]
+		if !ok || method.ReqType != types[0] || method.RspType != types[1] {
+			t.Errorf("unexpected method %s %v %v", method.Name, method.ReqType, method.RspType)
+		}
+	}
+
+	if _, _, err := DiscoverMethods((*login.LoginServiceServer)(nil), partialLoginService{}, "loginService.proto"); err == nil {
+		t.Error("handler missing a method should fail")
+	}
+	if _, _, err := DiscoverMethods((*login.LoginServiceServer)(nil), &wrongLoginService{}, "loginService.proto"); err == nil {
+		t.Error("handler with wrong signature should fail")
+	}
+	if _, _, err := DiscoverMethods((*login.LoginServiceServer)(nil), fullLoginService{}, "missing.proto"); err == nil {
+		t.Error("unregistered proto should fail")
+	}
+	if _, _, err := DiscoverMethods(fullLoginService{}, fullLoginService{}, "loginService.proto"); err == nil {
+		t.Error("non-interface handlerInterface should fail")
+	}
+}
+
+func TestCamelCase(t *testing.T) {
+	for in, out := range map[string]string{"loginService": "LoginService", "app_info": "AppInfo", "logout": "Logout"} {
+		if got := camelCase(in); got != out {
+			t.Errorf("camelCase(%s) = %s, expect %s", in, got, out)
+		}
+	}
+}
+
+func TestRegisterByConfig(t *testing.T) {
+	inject.Inject((*login.LoginServiceServer)(nil))
+	inject.Inject(fullLoginService{})
+	serverInfo := func() *util.ServerInfo {
+		return &util.ServerInfo{
+			Name:             "/login.loginService",
+			ProtoName:        "loginService.proto",
+			HandlerInterface: "local/sndaRpc/pb/login/LoginServiceServer",
+			HandlerCls:       "local/sndaRpc/server/fullLoginService",
+		}
+	}
+
+	me := NewGRPCServer()
+	if err := me.RegisterByConfig(serverInfo()); err != nil {
+		t.Fatal(err)
+	}
+	if methods := me.baseServer.GetServiceInfo()["login.loginService"].Methods; len(methods) != 2 {
+		t.Errorf("expect 2 discovered methods, got %v", methods)
+	}
+	if err := me.RegisterByConfig(serverInfo()); err == nil {
+		t.Error("duplicate registration should fail")
+	}
+
+	me = NewGRPCServer()
+	info := serverInfo()
+	info.MethodList = []*util.MethodInfo{{Name: "login", ReqType: "login.loginRequest", RspType: "login.loginReply"}}
+	if err := me.RegisterByConfig(info); err != nil {
+		t.Fatal(err)
+	}
+	if methods := me.baseServer.GetServiceInfo()["login.loginService"].Methods; len(methods) != 1 {
+		t.Errorf("expect 1 configured method, got %v", methods)
+	}
+
+	info = serverInfo()
+	info.Enabled = "false"
+	me = NewGRPCServer()
+	if err := me.RegisterByConfig(info); err != nil || len(me.baseServer.GetServiceInfo()) != 0 {
+		t.Errorf("disabled service should be skipped: %v", err)
+	}
+
+	for attr, modify := range map[string]func(*util.ServerInfo){
+		"handler-interface": func(info *util.ServerInfo) { info.HandlerInterface = "local/sndaRpc/pb/login/Missing" },
+		"handler-class":     func(info *util.ServerInfo) { info.HandlerCls = "local/sndaRpc/server/Missing" },
+		"proto-name":        func(info *util.ServerInfo) { info.ProtoName = "missing.proto" },
+		"enabled":           func(info *util.ServerInfo) { info.Enabled = "maybe" },
+		"request-type": func(info *util.ServerInfo) {
+			info.MethodList = []*util.MethodInfo{{Name: "logout", ReqType: "login.missing", RspType: "login.logoutReply"}}
+		},
+		"response-type": func(info *util.ServerInfo) {
+			info.MethodList = []*util.MethodInfo{{Name: "logout", ReqType: "login.logoutRequest", RspType: "login.logoutRequest"}}
+		},
+	} {
+		info := serverInfo()
+		modify(info)
+		err := NewGRPCServer().RegisterByConfig(info)
+		if err == nil || !strings.Contains(err.Error(), attr) {
+			t.Errorf("expect error about %s, got %v", attr, err)
+		}
+	}
+}
+
+//SnakeServiceServer 对应snakeService.proto中的snakeService, rpc名是下划线形式
+type SnakeServiceServer interface {
+	GetUser(context.Context, *login.LoginRequest) (*login.LoginReply, error)
+}
+
+type snakeService struct{}
+
+func (s *snakeService) GetUser(ctx context.Context, in *login.LoginRequest) (*login.LoginReply, error) {
+	return &login.LoginReply{SessionId: "user:" + in.GetUserName()}, nil
+}
+
+func init() {
+	fd := &protobuf.FileDescriptorProto{
+		Name:       proto.String("snakeService.proto"),
+		Package:    proto.String("snake"),
+		Dependency: []string{"loginService.proto"},
+		Syntax:     proto.String("proto3"),
+		Service: []*protobuf.ServiceDescriptorProto{{
+			Name: proto.String("snakeService"),
+			Method: []*protobuf.MethodDescriptorProto{{
+				Name:       proto.String("get_user"),
+				InputType:  proto.String(".login.loginRequest"),
+				OutputType: proto.String(".login.loginReply"),
+			}},
+		}},
+	}
+	b, err := proto.Marshal(fd)
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	proto.RegisterFile("snakeService.proto", buf.Bytes())
+}
+
+func TestSnakeCaseMethod(t *testing.T) {
+	serviceName, methodList, err := DiscoverMethods((*SnakeServiceServer)(nil), snakeService{}, "snakeService.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serviceName != "/snake.snakeService" || len(methodList) != 1 || methodList[0].Name != "get_user" {
+		t.Fatalf("unexpected discovery %s %+v", serviceName, methodList)
+	}
+	me := NewGRPCServer()
+	if err := me.RegisterService((*SnakeServiceServer)(nil), snakeService{}, "snakeService.proto"); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := me.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer me.Stop()
+
+	cli := client.NewGRPCClient()
+	err = cli.Register(&util.ClientInfo{
+		Addr:          []string{addrs[0].String()},
+		InterfaceList: []*util.InterfaceInfo{{Name: "/snake.snakeService/get_user", ReqType: "login.loginRequest", RspType: "login.loginReply"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := cli.Invoke(context.Background(), "/snake.snakeService/get_user", &login.LoginRequest{UserName: "tommy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionID := rsp.(*login.LoginReply).GetSessionId(); sessionID != "user:tommy" {
+		t.Errorf("unexpected response %s", sessionID)
+	}
+}
@@ -1,17 +1,20 @@
 package server
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"local/sndaRpc/auth"
 	"local/sndaRpc/constant"
+	"local/sndaRpc/idempotency"
 	"local/sndaRpc/inject"
 	"local/sndaRpc/logHelper"
+	"local/sndaRpc/trace"
 	"local/sndaRpc/util"
-	"net"
+	"local/sndaRpc/validate"
 	"net/http"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/circuitbreaker"
@@ -85,15 +88,34 @@ func MakeMethodInfoByName(name, req, rsp string) *MethodInfo {
 
 // GRPCServer gprcServer提供基于grpc协议的服务
 type GRPCServer struct {
-	logger     log.Logger //记录请求日志用的logger
-	baseServer *grpc.Server
+	logger           log.Logger //记录请求日志用的logger
+	baseServer       *grpc.Server
+	auditLogger      log.Logger //记录授权拒绝的logger
+	confMu           sync.RWMutex
+	serviceConf      map[string]*util.ServerInfo //<service name, 配置>
+	policies         map[string]*auth.Policy     //<service name或完整接口名, 授权策略>
+	limitMu          sync.Mutex
+	limiters         map[string]*methodLimiter      //<完整接口名, 限流状态>
+	idempotency      map[string]*idempotency.Config //<完整接口名, 幂等配置>
+	idempotencyStore idempotency.Store
+	disabled         map[string]bool //<完整接口名, 是否被停用>, 通过admin接口修改
+	serveMu          sync.Mutex
+	serveErr         error //第一个异常退出的监听的错误
+	serveWG          sync.WaitGroup
+	httpServers      []*http.Server //StartMux的http服务, Stop时关闭
 }
 
 //NewGRPCServer 创建GRPC服务
-func NewGRPCServer() *GRPCServer {
+func NewGRPCServer(opts ...Option) *GRPCServer {
 	srv := new(GRPCServer)
 	srv.SetLogger(log.NewLogfmtLogger(os.Stderr))
-	srv.baseServer = grpc.NewServer()
+	srv.SetAuditLogger(log.NewLogfmtLogger(os.Stderr))
+	srv.baseServer = newBaseServer(opts...)
+	srv.serviceConf = make(map[string]*util.ServerInfo)
+	srv.policies = make(map[string]*auth.Policy)
+	srv.limiters = make(map[string]*methodLimiter)
+	srv.idempotency = make(map[string]*idempotency.Config)
+	srv.disabled = make(map[string]bool)
 	return srv
 }
 
@@ -115,54 +137,99 @@ func (me *GRPCServer) SetLogger(lg log.Logger) error {
 	return nil
 }
 
-//Serve 启动监听
-func (me *GRPCServer) Serve(addr string) error {
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
-	}
-	return me.baseServer.Serve(listener)
-}
-
-//Register 这个是通过xml配置注册服务
+//RegisterByConfig 这个是通过xml配置注册服务
 //@param
-//serverInfo.HandlerInterface: 业务类必须实现一个接口. 如 local/sndaRpc/pb/login/LoginServiceServer
-//serverInfo.HandlerCls: 实现了 HandlerInterface 接口的具体业务类. 如 local/sndaRpc/service/login/TestService
+//serverInfo.HandlerInterface: 业务类必须实现一个接口, 需要先inject.Inject. 如 local/sndaRpc/pb/login/LoginServiceServer
+//serverInfo.HandlerCls: 实现了 HandlerInterface 接口的具体业务类, 需要先inject.Inject. 如 local/sndaRpc/service/login/TestService
 //serverInfo.ProtoName: 对应的*.proto定义文件. 如loginService.proto
 //serverInfo.Name: 要注册的服务名. 如 /login.loginService
 //serverInfo.MethodList: serviceName下的所有methods信息. 如 MethodInfo{"login", "login.loginRequest", "login.loginReply"}
 // serverInfo.Name/MethodInfo.Name共同构成grpc真正的接口名
-//@return: error
+// <method>都没有配置request-type和response-type时, 从proto文件描述中自动获取全部方法
+//serverInfo.Enabled: 为false时不注册
+//@return: error 错误信息中带上出错的属性名
 func (me *GRPCServer) RegisterByConfig(serverInfo *util.ServerInfo) error {
-	handler, err := inject.New(serverInfo.HandlerCls)
+	enabled, err := serverInfo.IsEnabled()
+	if err != nil {
+		return fmt.Errorf("service %s: invalid enabled %s", serverInfo.Name, serverInfo.Enabled)
+	}
+	if !enabled {
+		return nil
+	}
+	ifaceType, err := inject.Type(serverInfo.HandlerInterface)
 	if err != nil {
-		return err
+		return fmt.Errorf("service %s: invalid handler-interface %s: %s", serverInfo.Name, serverInfo.HandlerInterface, err)
+	}
+	if ifaceType.Kind() != reflect.Interface {
+		return fmt.Errorf("service %s: invalid handler-interface %s: not an interface", serverInfo.Name, serverInfo.HandlerInterface)
 	}
-	handlerType, err := inject.New(serverInfo.HandlerInterface)
+	clsType, err := inject.Type(serverInfo.HandlerCls)
 	if err != nil {
-		return err
+		return fmt.Errorf("service %s: invalid handler-class %s: %s", serverInfo.Name, serverInfo.HandlerCls, err)
 	}
-	methodDescList := make([]grpc.MethodDesc, 0)
+	//(*XXXServer)(nil)
+	handlerInterface := reflect.Zero(reflect.PtrTo(ifaceType)).Interface()
+	handlerCls := reflect.New(clsType).Interface()
+	if !reflect.PtrTo(clsType).Implements(ifaceType) {
+		return fmt.Errorf("service %s: handler-class %s does not implement handler-interface %s",
+			serverInfo.Name, serverInfo.HandlerCls, serverInfo.HandlerInterface)
+	}
+	if proto.FileDescriptor(serverInfo.ProtoName) == nil {
+		return fmt.Errorf("service %s: invalid proto-name %s: not registered", serverInfo.Name, serverInfo.ProtoName)
+	}
+	if !hasMethodTypes(serverInfo) {
+		serviceName, methodList, err := DiscoverMethods(handlerInterface, handlerCls, serverInfo.ProtoName)
+		if err != nil {
+			return fmt.Errorf("service %s: %s", serverInfo.Name, err)
+		}
+		if len(serverInfo.Name) > 0 && serverInfo.Name != serviceName {
+			return fmt.Errorf("service %s: invalid name, %s defines %s", serverInfo.Name, serverInfo.ProtoName, serviceName)
+		}
+		return me.Register(handlerInterface, handlerCls, serviceName, serverInfo.ProtoName, methodList...)
+	}
+	if !strings.HasPrefix(serverInfo.Name, "/") {
+		return fmt.Errorf("service %s: invalid name, should be like /package.service", serverInfo.Name)
+	}
+	methodList := make([]*MethodInfo, 0, len(serverInfo.MethodList))
 	for _, method := range serverInfo.MethodList {
-		handler, err := me.makeMethodHandler(serverInfo.Name, method.Name, method.ReqType, method.RspType)
+		if len(method.Name) == 0 {
+			return fmt.Errorf("service %s: method name required", serverInfo.Name)
+		}
+		reqType, err := messageType(method.ReqType)
 		if err != nil {
-			return err
+			return fmt.Errorf("service %s method %s: invalid request-type %s", serverInfo.Name, method.Name, method.ReqType)
 		}
-		methodDesc := grpc.MethodDesc{
-			MethodName: method.Name,
-			Handler:    handler,
+		rspType, err := messageType(method.RspType)
+		if err != nil {
+			return fmt.Errorf("service %s method %s: invalid response-type %s", serverInfo.Name, method.Name, method.RspType)
 		}
-		methodDescList = append(methodDescList, methodDesc)
+		goName := camelCase(method.Name)
+		clsMethod, ok := reflect.PtrTo(clsType).MethodByName(goName)
+		if !ok {
+			return fmt.Errorf("service %s method %s: handler-class %s is missing method %s", serverInfo.Name, method.Name, serverInfo.HandlerCls, goName)
+		}
+		methodType := methodTypeWithoutReceiver(clsMethod.Type)
+		if err := checkSignature(methodType, reqType, rspType); err != nil {
+			attr := "request-type"
+			if methodType.NumIn() == 2 && methodType.In(1) == reqType {
+				attr = "response-type"
+			}
+			return fmt.Errorf("service %s method %s: %s does not match handler-class %s method %s: %s",
+				serverInfo.Name, method.Name, attr, serverInfo.HandlerCls, goName, err)
+		}
+		methodList = append(methodList, &MethodInfo{method.Name, reqType.Elem(), rspType.Elem()})
 	}
-	var serviceDesc = grpc.ServiceDesc{
-		ServiceName: serverInfo.Name[1:],
-		HandlerType: handlerType,
-		Methods:     methodDescList,
-		Streams:     []grpc.StreamDesc{},
-		Metadata:    serverInfo.ProtoName,
+	return me.Register(handlerInterface, handlerCls, serverInfo.Name, serverInfo.ProtoName, methodList...)
+}
+
+//hasMethodTypes <method>中是否配置了入参出参类型
+func hasMethodTypes(serverInfo *util.ServerInfo) bool {
+	for _, method := range serverInfo.MethodList {
+		if len(method.ReqType) > 0 || len(method.RspType) > 0 {
+			return true
+		}
 	}
-	me.baseServer.RegisterService(&serviceDesc, handler)
-	return nil
+	return false
 }
 
 //Register 注册服务
@@ -175,6 +242,9 @@ func (me *GRPCServer) RegisterByConfig(serverInfo *util.ServerInfo) error {
 //serviceName/MethodInfo.Name共同构成grpc真正的接口名
 //@return error
 func (me *GRPCServer) Register(handlerInterface, handlerCls interface{}, serviceName, protoName string, methodList ...*MethodInfo) error {
+	if _, ok := me.baseServer.GetServiceInfo()[strings.TrimPrefix(serviceName, "/")]; ok {
+		return fmt.Errorf("service %s already registered", serviceName)
+	}
 	tp := reflect.TypeOf(handlerCls)
 	if tp.Kind() == reflect.Ptr {
 		tp = tp.Elem()
@@ -183,11 +253,12 @@ func (me *GRPCServer) Register(handlerInterface, handlerCls interface{}, service
 	handler := reflect.New(tp).Interface()
 	methodDescList := make([]grpc.MethodDesc, 0)
 	for _, method := range methodList {
-		var buf bytes.Buffer
-		buf.WriteString(strings.ToUpper(method.Name[:1]))
-		buf.WriteString(method.Name[1:])
-		methodName := buf.String()
-		//注册的时候方法首字母变成大写了, 所以要转一下
+		//字段option中的校验规则写错时不能注册, 否则该字段不再校验
+		if err := validate.CheckRules(reflect.PtrTo(method.ReqType)); err != nil {
+			return fmt.Errorf("%s/%s: %s", serviceName, method.Name, err)
+		}
+		//go方法名与protoc-gen-go一致, 如 get_user 对应 GetUser
+		methodName := camelCase(method.Name)
 		methodHandler, err := me.makeMethodHandler2(serviceName, methodName, method.ReqType, method.RspType)
 		if err != nil {
 			return err
@@ -218,7 +289,28 @@ func (me *GRPCServer) newServerEndpoint(executor interface{}, methodName string)
 	if err != nil {
 		return nil, err
 	}
-	return me.logParams(ep)
+	if ep, err = me.validateParams(ep); err != nil {
+		return nil, err
+	}
+	if ep, err = me.idempotencyParams(ep); err != nil {
+		return nil, err
+	}
+	if ep, err = me.limitParams(ep); err != nil {
+		return nil, err
+	}
+	if ep, err = me.authzParams(ep); err != nil {
+		return nil, err
+	}
+	if ep, err = me.authParams(ep); err != nil {
+		return nil, err
+	}
+	if ep, err = me.switchParams(ep); err != nil {
+		return nil, err
+	}
+	if ep, err = me.logParams(ep); err != nil {
+		return nil, err
+	}
+	return me.traceParams(ep)
 }
 
 //newDefaultHandler 创建GRPC接口处理器,采用默认的调用配置
@@ -233,7 +325,7 @@ func (me *GRPCServer) newDefaultHandler(executor interface{}, methodName string)
 	//ep = addendpoint.InstrumentingMiddleware("example", "daoyu", "request_duration_seconds", "Request duration in seconds.")(ep)
 	options := []kittransport.ServerOption{
 		kittransport.ServerErrorLogger(me.logger),
-		kittransport.ServerBefore(getFlowID()),
+		kittransport.ServerBefore(getFlowID(), getTraceparent()),
 	}
 	var handler kittransport.Handler = kittransport.NewServer(
 		ep,
@@ -281,8 +373,11 @@ func (me *GRPCServer) logParams(next endpoint.Endpoint) (endpoint.Endpoint, erro
 		if logInfo, ok := logHelper.FromContext(ctx); ok {
 			onceLogger = log.With(onceLogger, "flowID", logInfo.FlowID)
 		}
-		if stream, ok := transport.StreamFromContext(ctx); ok {
-			onceLogger = log.With(onceLogger, "method", stream.Method())
+		if span, ok := trace.FromContext(ctx); ok {
+			onceLogger = log.With(onceLogger, "traceID", span.TraceID, "spanID", span.SpanID)
+		}
+		if method := methodFromContext(ctx); len(method) > 0 {
+			onceLogger = log.With(onceLogger, "method", method)
 		}
 		b, err := json.Marshal(request)
 		if err == nil {
@@ -304,6 +399,22 @@ func (me *GRPCServer) logParams(next endpoint.Endpoint) (endpoint.Endpoint, erro
 
 }
 
+//traceParams 为每个请求创建server span, 父span来自客户端传过来的traceparent
+func (me *GRPCServer) traceParams(next endpoint.Endpoint) (endpoint.Endpoint, error) {
+	var ep endpoint.Endpoint = func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		ctx, span := trace.StartSpan(ctx, methodFromContext(ctx), trace.KIND_SERVER)
+		if pr, ok := peer.FromContext(ctx); ok {
+			span.SetAttribute("peer", pr.Addr.String())
+		}
+		defer func() {
+			span.SetError(err)
+			span.Finish()
+		}()
+		return next(ctx, request)
+	}
+	return ep, nil
+}
+
 //InstrumentingMiddleware 记录请求耗时(metrix)
 // InstrumentingMiddleware returns an endpoint middleware that records
 // the duration of each invocation to the passed histogram. The middleware adds
@@ -339,6 +450,14 @@ func encodeGRPCResponse(_ context.Context, response interface{}) (interface{}, e
 	return response, nil
 }
 
+//从context中获取当前调用的完整接口名, 如 /login.loginService/login
+func methodFromContext(ctx context.Context) string {
+	if stream, ok := transport.StreamFromContext(ctx); ok {
+		return stream.Method()
+	}
+	return ""
+}
+
 //从context中抽离出客户端传过来的FlowId
 func getFlowID() kittransport.ServerRequestFunc {
 	return func(ctx context.Context, md metadata.MD) context.Context {
@@ -352,40 +471,14 @@ func getFlowID() kittransport.ServerRequestFunc {
 	}
 }
 
-//makeMethodHandler2 创建方法处理器
-//serviceName 服务名 如/login.loginService
-//methodName 方法名 如login(首字母小写)
-// methodName/methodName 客户端调用时指定的完整接口名. 如 /login.loginService/login(服务名/方法名)
-func (me *GRPCServer) makeMethodHandler(serviceName, methodName, reqType, RspType string) (func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error), error) {
-	tp := proto.MessageType(reqType)
-	if tp == nil {
-		return nil, fmt.Errorf("invalid requestType %s", reqType)
-	}
-	tp = tp.Elem()
-	in := reflect.New(tp).Interface()
-	handler := func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-		if err := dec(in); err != nil {
-			return nil, err
-		}
-		kitGRPCHandler, err := me.newDefaultHandler(srv, methodName)
-		if err != nil {
-			return nil, err
-		}
-		if interceptor == nil {
-			_, response, err := kitGRPCHandler.ServeGRPC(ctx, in)
-			return response, err
-		}
-		info := &grpc.UnaryServerInfo{
-			Server:     srv,
-			FullMethod: serviceName + "/" + methodName,
-		}
-		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-			_, response, err := kitGRPCHandler.ServeGRPC(ctx, in)
-			return response, err
+//从metadata中抽离出客户端传过来的traceparent
+func getTraceparent() kittransport.ServerRequestFunc {
+	return func(ctx context.Context, md metadata.MD) context.Context {
+		if list, ok := md[trace.HEADER]; ok && len(list) > 0 {
+			ctx = trace.ContextWithTraceparent(ctx, list[0])
 		}
-		return interceptor(ctx, in, info, handler)
+		return ctx
 	}
-	return handler, nil
 }
 
 //makeMethodHandler2 创建方法处理器
@@ -393,8 +486,9 @@ func (me *GRPCServer) makeMethodHandler(serviceName, methodName, reqType, RspTyp
 //methodName 方法名 如Login(首字母大写)
 // methodName/methodName 客户端调用时指定的完整接口名. 如 /login.loginService/login(服务名/方法名)
 func (me *GRPCServer) makeMethodHandler2(serviceName, methodName string, reqType, rspType reflect.Type) (func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error), error) {
-	in := reflect.New(reqType).Interface()
 	handler := func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		//每次请求都要新建入参, 否则并发请求会互相覆盖
+		in := reflect.New(reqType).Interface()
 		if err := dec(in); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"local/sndaRpc/auth"
+	"local/sndaRpc/idempotency"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+//SetIdempotencyStore 替换<idempotency redis="">指定的redis, 为nil时恢复使用redis
+func (me *GRPCServer) SetIdempotencyStore(store idempotency.Store) {
+	me.confMu.Lock()
+	me.idempotencyStore = store
+	me.confMu.Unlock()
+}
+
+//idempotencyConfig 接口的幂等配置, 没有配置时返回nil
+func (me *GRPCServer) idempotencyConfig(fullMethod string) (*idempotency.Config, idempotency.Store) {
+	me.confMu.RLock()
+	defer me.confMu.RUnlock()
+	conf, ok := me.idempotency[fullMethod]
+	if !ok {
+		return nil, nil
+	}
+	if me.idempotencyStore != nil {
+		return conf, me.idempotencyStore
+	}
+	return conf, idempotency.NewRedisStore(conf.Redis)
+}
+
+//idempotencyParams 按<method><idempotency>配置, 相同幂等key的请求只处理一次.
+//key区分接口和认证后的调用方, 避免不同调用方的key冲突
+func (me *GRPCServer) idempotencyParams(next endpoint.Endpoint) (endpoint.Endpoint, error) {
+	var ep endpoint.Endpoint = func(ctx context.Context, request interface{}) (interface{}, error) {
+		fullMethod := methodFromContext(ctx)
+		conf, store := me.idempotencyConfig(fullMethod)
+		if conf == nil {
+			return next(ctx, request)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md[conf.Header]
+		if len(values) == 0 || len(values[0]) == 0 {
+			return next(ctx, request)
+		}
+		key := "idempotency:" + fullMethod + ":"
+		if principal, ok := auth.FromContext(ctx); ok {
+			key += principal.Scheme + ":" + principal.ID + ":"
+		}
+		return idempotency.Do(ctx, store, key+values[0], request, conf, next)
+	}
+	return ep, nil
+}
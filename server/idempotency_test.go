@@ -0,0 +1,70 @@
+package server
+
+import (
+	"local/sndaRpc/client"
+	"local/sndaRpc/idempotency"
+	"local/sndaRpc/pb/login"
+	"local/sndaRpc/util"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+//idempotentLoginService 每次处理返回不同的sessionId, 重复请求返回相同的sessionId说明没有重复处理
+type idempotentLoginService struct{}
+
+func (s *idempotentLoginService) Login(ctx context.Context, in *login.LoginRequest) (*login.LoginReply, error) {
+	return &login.LoginReply{SessionId: in.GetUserName() + "-" + util.NewGuid()}, nil
+}
+
+func (s *idempotentLoginService) Logout(ctx context.Context, in *login.LogoutRequest) (*login.LogoutReply, error) {
+	return &login.LogoutReply{}, nil
+}
+
+func TestIdempotency(t *testing.T) {
+	me := NewGRPCServer()
+	me.SetIdempotencyStore(idempotency.NewInMemoryStore())
+	err := me.SetServiceConfig([]*util.ServerInfo{{
+		Name:       "/login.loginService",
+		MethodList: []*util.MethodInfo{{Name: "login", Idempotency: &util.IdempotencyInfo{Redis: "redis1", Header: "X-Request-Key"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := me.RegisterService((*login.LoginServiceServer)(nil), idempotentLoginService{}, "loginService.proto"); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := me.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer me.Stop()
+
+	cli := client.NewGRPCClient()
+	err = cli.Register(&util.ClientInfo{
+		Addr: []string{addrs[0].String()},
+		InterfaceList: []*util.InterfaceInfo{
+			{Name: "/login.loginService/login", ReqType: "login.loginRequest", RspType: "login.loginReply", IdempotencyHeader: "X-Request-Key"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	login1 := func(ctx context.Context) string {
+		rsp, err := cli.Invoke(ctx, "/login.loginService/login", &login.LoginRequest{UserName: "tommy"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rsp.(*login.LoginReply).GetSessionId()
+	}
+	ctx := idempotency.ContextWithKey(context.Background(), "k1")
+	first := login1(ctx)
+	for i := 0; i < 2; i++ {
+		if sessionID := login1(ctx); sessionID != first {
+			t.Errorf("duplicate request should return the saved response, got %s and %s", first, sessionID)
+		}
+	}
+	if sessionID := login1(context.Background()); sessionID == first {
+		t.Error("request without key should be processed")
+	}
+}
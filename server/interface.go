@@ -2,6 +2,8 @@ package server
 
 import (
 	"local/sndaRpc/util"
+	"net"
+	"net/http"
 
 	"github.com/go-kit/kit/log"
 )
@@ -10,7 +12,32 @@ import (
 type Server interface {
 	// SetLogger 设置logger
 	SetLogger(lg log.Logger) error
+	// SetAuditLogger 设置审计日志
+	SetAuditLogger(lg log.Logger) error
+	// SetOptions 设置keepalive, 消息大小, 压缩等参数, 必须在注册服务之前调用
+	SetOptions(opts ...Option) error
+	// SetServiceConfig 设置<service>中的策略配置, 如auth, allow/deny
+	SetServiceConfig(serverInfoList []*util.ServerInfo) error
 	RegisterByConfig(serverInfo *util.ServerInfo) error
 	Register(handlerInterface, handlerCls interface{}, serviceName, protoName string, methodList ...*MethodInfo) error
+	// RegisterService 注册服务, 方法信息从proto文件描述中自动获取
+	RegisterService(handlerInterface, handlerCls interface{}, protoName string) error
+	// Serve 启动监听并阻塞, addr可以是逗号分隔的多个地址, 支持unix://
 	Serve(addr string) error
+	// Start 非阻塞启动, 返回实际监听的地址
+	Start(addrs ...string) ([]net.Addr, error)
+	// ServeMux 在一个地址上同时提供grpc和http服务并阻塞
+	ServeMux(addr string, httpHandler http.Handler) error
+	// Methods 已注册的接口和是否可用
+	Methods() []*MethodStatus
+	// SetMethodEnabled 运行时停用或恢复一个接口
+	SetMethodEnabled(fullMethod string, enabled bool) error
+	// Stop 停止服务
+	Stop()
+}
+
+// ListenerStarter 可以在已经打开的监听上启动的服务, 通过类型断言使用
+type ListenerStarter interface {
+	// StartListener 在已经打开的监听上非阻塞启动
+	StartListener(listener net.Listener) net.Addr
 }
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"local/sndaRpc/auth"
+	"local/sndaRpc/util"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	jujuratelimit "github.com/juju/ratelimit"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//每个接口最多记录多少个调用方的令牌桶, 超过后清空重新统计
+	maxCallerBuckets = 10000
+	//计算平均耗时的衰减系数
+	latencyDecay = 0.1
+	//降载时最多丢弃的比例, 留一部分请求用来探测耗时是否恢复
+	maxShedRatio = 0.9
+)
+
+//methodLimiter 一个接口的限流状态
+type methodLimiter struct {
+	bucket        *jujuratelimit.Bucket
+	callerQPS     float64
+	callerBurst   int64
+	callerMu      sync.Mutex
+	callerBuckets map[string]*jujuratelimit.Bucket
+	sem           chan struct{}
+	shedInflight  int64
+	shedLatency   time.Duration
+	inflight      int64
+	latencyMu     sync.Mutex
+	latency       float64 //平均耗时(ns)
+}
+
+//newMethodLimiter 根据配置创建限流器, 全部为0时返回nil
+func newMethodLimiter(info util.LimitInfo) (*methodLimiter, error) {
+	limiter := &methodLimiter{
+		callerQPS:    info.CallerQPS,
+		callerBurst:  burst(info.CallerQPS, info.CallerBurst),
+		shedInflight: int64(info.ShedInflight),
+	}
+	if info.QPS > 0 {
+		limiter.bucket = jujuratelimit.NewBucketWithRate(info.QPS, burst(info.QPS, info.Burst))
+	}
+	if info.CallerQPS > 0 {
+		limiter.callerBuckets = make(map[string]*jujuratelimit.Bucket)
+	}
+	if info.MaxConcurrent > 0 {
+		limiter.sem = make(chan struct{}, info.MaxConcurrent)
+	}
+	if len(info.ShedLatency) > 0 {
+		d, err := time.ParseDuration(info.ShedLatency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shed-latency %s", info.ShedLatency)
+		}
+		limiter.shedLatency = d
+	}
+	if limiter.bucket == nil && limiter.callerBuckets == nil && limiter.sem == nil &&
+		limiter.shedInflight == 0 && limiter.shedLatency == 0 {
+		return nil, nil
+	}
+	return limiter, nil
+}
+
+//没配置容量时, 容量为1秒的量
+func burst(qps float64, burst int) int64 {
+	if burst > 0 {
+		return int64(burst)
+	}
+	return int64(math.Max(1, math.Ceil(qps)))
+}
+
+//acquire 申请一次调用, 成功时返回释放函数.
+//接口的令牌最后取, 被降载, 并发数或调用方限流拒绝的请求不占用接口的qps
+func (me *methodLimiter) acquire(caller string) (func(), error) {
+	if me.shouldShed() {
+		return nil, status.Error(codes.ResourceExhausted, "server overloaded")
+	}
+	if me.sem != nil {
+		select {
+		case me.sem <- struct{}{}:
+		default:
+			return nil, status.Error(codes.ResourceExhausted, "too many concurrent requests")
+		}
+	}
+	//拒绝时归还并发数
+	reject := func(msg string) (func(), error) {
+		if me.sem != nil {
+			<-me.sem
+		}
+		return nil, status.Error(codes.ResourceExhausted, msg)
+	}
+	if me.callerBuckets != nil && me.callerBucket(caller).TakeAvailable(1) == 0 {
+		return reject("caller rate limit exceeded")
+	}
+	if me.bucket != nil && me.bucket.TakeAvailable(1) == 0 {
+		return reject("method rate limit exceeded")
+	}
+	atomic.AddInt64(&me.inflight, 1)
+	begin := time.Now()
+	release := func() {
+		atomic.AddInt64(&me.inflight, -1)
+		if me.sem != nil {
+			<-me.sem
+		}
+		me.observe(time.Since(begin))
+	}
+	return release, nil
+}
+
+func (me *methodLimiter) callerBucket(caller string) *jujuratelimit.Bucket {
+	me.callerMu.Lock()
+	defer me.callerMu.Unlock()
+	bucket, ok := me.callerBuckets[caller]
+	if !ok {
+		if len(me.callerBuckets) >= maxCallerBuckets {
+			me.callerBuckets = make(map[string]*jujuratelimit.Bucket)
+		}
+		bucket = jujuratelimit.NewBucketWithRate(me.callerQPS, me.callerBurst)
+		me.callerBuckets[caller] = bucket
+	}
+	return bucket
+}
+
+//shouldShed 处理中的请求或平均耗时超过阈值时, 按超出的比例随机丢弃
+func (me *methodLimiter) shouldShed() bool {
+	ratio := 0.0
+	if me.shedInflight > 0 {
+		if inflight := atomic.LoadInt64(&me.inflight); inflight >= me.shedInflight {
+			ratio = float64(inflight-me.shedInflight+1) / float64(me.shedInflight)
+		}
+	}
+	if me.shedLatency > 0 {
+		me.latencyMu.Lock()
+		latency := me.latency
+		me.latencyMu.Unlock()
+		if threshold := float64(me.shedLatency); latency > threshold {
+			ratio = math.Max(ratio, (latency-threshold)/threshold)
+		}
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return rand.Float64() < math.Min(ratio, maxShedRatio)
+}
+
+//observe 更新平均耗时
+func (me *methodLimiter) observe(took time.Duration) {
+	if me.shedLatency == 0 {
+		return
+	}
+	me.latencyMu.Lock()
+	if me.latency == 0 {
+		me.latency = float64(took)
+	} else {
+		me.latency = me.latency*(1-latencyDecay) + float64(took)*latencyDecay
+	}
+	me.latencyMu.Unlock()
+}
+
+//limiter 获取接口的限流器. <method>的限流器在SetServiceConfig时创建,
+//其他接口第一次调用时按service级别的配置创建. 没有配置时返回nil
+func (me *GRPCServer) limiter(fullMethod string) *methodLimiter {
+	me.limitMu.Lock()
+	defer me.limitMu.Unlock()
+	if limiter, ok := me.limiters[fullMethod]; ok {
+		return limiter
+	}
+	var limiter *methodLimiter
+	if serverInfo, _ := me.methodConfig(fullMethod); serverInfo != nil {
+		//配置已在SetServiceConfig中校验过
+		limiter, _ = newMethodLimiter(serverInfo.LimitInfo)
+	}
+	me.limiters[fullMethod] = limiter
+	return limiter
+}
+
+//limitParams 按<service>/<method>上的qps, caller-qps, max-concurrent, shed-*配置限流,
+//被拒绝时返回ResourceExhausted
+func (me *GRPCServer) limitParams(next endpoint.Endpoint) (endpoint.Endpoint, error) {
+	var ep endpoint.Endpoint = func(ctx context.Context, request interface{}) (interface{}, error) {
+		limiter := me.limiter(methodFromContext(ctx))
+		if limiter == nil {
+			return next(ctx, request)
+		}
+		release, err := limiter.acquire(callerFromContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return next(ctx, request)
+	}
+	return ep, nil
+}
+
+//callerFromContext 调用方标识, 认证过的用principal, 否则用对端ip
+func callerFromContext(ctx context.Context) string {
+	if principal, ok := auth.FromContext(ctx); ok && len(principal.ID) > 0 {
+		return principal.Scheme + ":" + principal.ID
+	}
+	if pr, ok := peer.FromContext(ctx); ok {
+		if ip := auth.IPFromAddr(pr.Addr); ip != nil {
+			return ip.String()
+		}
+		return pr.Addr.String()
+	}
+	return ""
+}
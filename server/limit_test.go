@@ -0,0 +1,106 @@
+package server
+
+import (
+	"local/sndaRpc/util"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMethodLimiter(t *testing.T) {
+	limiter, err := newMethodLimiter(util.LimitInfo{})
+	if err != nil || limiter != nil {
+		t.Fatalf("empty config should not create limiter: %v %v", limiter, err)
+	}
+
+	limiter, err = newMethodLimiter(util.LimitInfo{QPS: 1, Burst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		release, err := limiter.acquire("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if _, err := limiter.acquire("a"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expect ResourceExhausted, got %v", err)
+	}
+
+	limiter, _ = newMethodLimiter(util.LimitInfo{CallerQPS: 1})
+	if _, err := limiter.acquire("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limiter.acquire("a"); err == nil {
+		t.Error("caller a should be limited")
+	}
+	if _, err := limiter.acquire("b"); err != nil {
+		t.Errorf("caller b should not be limited: %v", err)
+	}
+
+	limiter, _ = newMethodLimiter(util.LimitInfo{MaxConcurrent: 1})
+	release, err := limiter.acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limiter.acquire("a"); err == nil {
+		t.Error("second concurrent request should be rejected")
+	}
+	release()
+	if _, err := limiter.acquire("a"); err != nil {
+		t.Errorf("request after release should pass: %v", err)
+	}
+
+	//被调用方限流和并发数拒绝的请求不占用接口的qps
+	limiter, _ = newMethodLimiter(util.LimitInfo{QPS: 2, CallerQPS: 1, MaxConcurrent: 1})
+	release, err = limiter.acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limiter.acquire("b"); err == nil {
+		t.Error("second concurrent request should be rejected")
+	}
+	release()
+	if _, err := limiter.acquire("a"); err == nil || !strings.Contains(err.Error(), "caller") {
+		t.Errorf("caller a should be limited, got %v", err)
+	}
+	if _, err := limiter.acquire("b"); err != nil {
+		t.Errorf("rejected requests should not take method quota: %v", err)
+	}
+
+	if _, err := newMethodLimiter(util.LimitInfo{ShedLatency: "fast"}); err == nil {
+		t.Error("invalid shed-latency should fail")
+	}
+}
+
+func TestLimitMerge(t *testing.T) {
+	service := util.LimitInfo{QPS: 100, MaxConcurrent: 10}
+	merged := service.Merge(&util.LimitInfo{QPS: 5, Burst: 1})
+	if merged.QPS != 5 || merged.Burst != 1 || merged.MaxConcurrent != 10 {
+		t.Errorf("unexpected merge result %+v", merged)
+	}
+}
+
+func TestLimitConfig(t *testing.T) {
+	me := NewGRPCServer()
+	serverInfo := &util.ServerInfo{Name: "/login.loginService", LimitInfo: util.LimitInfo{QPS: 1}}
+	serverInfo.MethodList = []*util.MethodInfo{{Name: "login", LimitInfo: util.LimitInfo{ShedLatency: "fast"}}}
+	if err := me.SetServiceConfig([]*util.ServerInfo{serverInfo}); err == nil {
+		t.Error("invalid method shed-latency should fail SetServiceConfig")
+	}
+	serverInfo.MethodList[0].ShedLatency = "1s"
+	if err := me.SetServiceConfig([]*util.ServerInfo{serverInfo}); err != nil {
+		t.Fatal(err)
+	}
+	login := me.limiter("/login.loginService/login")
+	if login == nil || login.shedLatency != time.Second || login != me.limiter("/login.loginService/login") {
+		t.Errorf("unexpected login limiter %+v", login)
+	}
+	if logout := me.limiter("/login.loginService/logout"); logout == nil || logout.bucket == nil || logout == login {
+		t.Errorf("unexpected logout limiter %+v", logout)
+	}
+}
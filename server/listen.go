@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"local/sndaRpc/util"
+	"net"
+	"os"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+//listen 监听一个地址, 支持 unix:///path/to.sock
+func listen(addr string) (net.Listener, error) {
+	network, address := util.ParseAddr(addr)
+	if network == "unix" {
+		//上次异常退出时留下的socket文件会导致监听失败
+		if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	return net.Listen(network, address)
+}
+
+//Start 监听全部地址并在后台处理请求, 返回实际监听的地址(端口为0时可以从这里取到真正的端口)
+//任意一个地址监听失败时关闭已经打开的监听并返回错误
+func (me *GRPCServer) Start(addrs ...string) ([]net.Addr, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address to listen")
+	}
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		listener, err := listen(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("listen %s: %s", addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	boundAddrs := make([]net.Addr, 0, len(listeners))
+	for _, listener := range listeners {
+		boundAddrs = append(boundAddrs, me.StartListener(listener))
+	}
+	return boundAddrs, nil
+}
+
+var _ ListenerStarter = (*GRPCServer)(nil)
+
+//StartListener 在已经打开的监听上后台处理请求, 如测试用的内存监听. Stop时关闭listener
+func (me *GRPCServer) StartListener(listener net.Listener) net.Addr {
+	me.serveWG.Add(1)
+	go func() {
+		defer me.serveWG.Done()
+		if err := me.baseServer.Serve(listener); err != nil {
+			me.serveFailed(listener.Addr().String(), err)
+		}
+	}()
+	return listener.Addr()
+}
+
+//Serve 启动监听并阻塞到服务停止. addr可以是逗号分隔的多个地址, 如 ":8081,unix:///tmp/sndaRpc.sock"
+func (me *GRPCServer) Serve(addr string) error {
+	if _, err := me.Start(util.SplitAddrs(addr)...); err != nil {
+		return err
+	}
+	me.serveWG.Wait()
+	me.serveMu.Lock()
+	defer me.serveMu.Unlock()
+	return me.serveErr
+}
+
+//serveFailed 记录监听异常退出的错误, Serve返回第一个错误
+func (me *GRPCServer) serveFailed(addr string, err error) {
+	level.Error(me.logger).Log("during", "serve", "addr", addr, "err", err)
+	me.serveMu.Lock()
+	if me.serveErr == nil {
+		me.serveErr = err
+	}
+	me.serveMu.Unlock()
+}
+
+//Stop 停止接受新请求, 等待处理中的请求完成后关闭全部监听. 停止后不能再次Start
+func (me *GRPCServer) Stop() {
+	me.serveMu.Lock()
+	httpServers := me.httpServers
+	me.serveMu.Unlock()
+	//StartMux的http服务
+	for _, httpServer := range httpServers {
+		httpServer.Shutdown(context.Background())
+	}
+	me.baseServer.GracefulStop()
+}
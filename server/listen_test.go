@@ -0,0 +1,211 @@
+package server
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"local/sndaRpc/client"
+	"local/sndaRpc/codec"
+	"local/sndaRpc/pb/login"
+	"local/sndaRpc/util"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/http2"
+)
+
+func TestStartMultipleListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sndaRpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := util.UNIX_PREFIX + filepath.Join(dir, "rpc.sock")
+
+	me := NewGRPCServer()
+	if err := me.RegisterService((*login.LoginServiceServer)(nil), fullLoginService{}, "loginService.proto"); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := me.Start("127.0.0.1:0", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer me.Stop()
+	if len(addrs) != 2 || addrs[1].Network() != "unix" {
+		t.Fatalf("unexpected addrs %v", addrs)
+	}
+
+	for _, addr := range []string{addrs[0].String(), sock} {
+		cli := client.NewGRPCClient()
+		err := cli.Register(&util.ClientInfo{
+			Addr: []string{addr},
+			InterfaceList: []*util.InterfaceInfo{
+				{Name: "/login.loginService/login", ReqType: "login.loginRequest", RspType: "login.loginReply"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := cli.Invoke(context.Background(), "/login.loginService/login", &login.LoginRequest{UserName: "tommy"})
+		if err != nil {
+			t.Fatalf("%s: %s", addr, err)
+		}
+		if rsp.(*login.LoginReply).GetSessionId() != "tommy" {
+			t.Errorf("%s: unexpected response %v", addr, rsp)
+		}
+	}
+
+	if _, err := NewGRPCServer().Start(addrs[0].String()); err == nil {
+		t.Error("listening on a used address should fail")
+	}
+}
+
+func TestStartMux(t *testing.T) {
+	me := NewGRPCServer()
+	if err := me.RegisterService((*login.LoginServiceServer)(nil), fullLoginService{}, "loginService.proto"); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := me.StartMux("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto + " " + r.URL.Path))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := client.NewGRPCClient()
+	err = cli.Register(&util.ClientInfo{
+		Addr: []string{addr.String()},
+		InterfaceList: []*util.InterfaceInfo{
+			{Name: "/login.loginService/login", ReqType: "login.loginRequest", RspType: "login.loginReply"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := cli.Invoke(context.Background(), "/login.loginService/login", &login.LoginRequest{UserName: "tommy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.(*login.LoginReply).GetSessionId() != "tommy" {
+		t.Errorf("unexpected grpc response %v", rsp)
+	}
+
+	httpRsp, err := http.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(httpRsp.Body)
+	httpRsp.Body.Close()
+	if string(body) != "HTTP/1.1 /metrics" {
+		t.Errorf("unexpected http response %s", body)
+	}
+
+	//不是grpc的http2请求交给http服务
+	h2c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	httpRsp, err = h2c.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(httpRsp.Body)
+	httpRsp.Body.Close()
+	if string(body) != "HTTP/2.0 /metrics" {
+		t.Errorf("unexpected http2 response %s", body)
+	}
+
+	me.Stop()
+	if _, err := net.DialTimeout("tcp", addr.String(), time.Second); err == nil {
+		t.Error("listener should be closed after Stop")
+	}
+}
+
+func TestLargeMessage(t *testing.T) {
+	opts, err := OptionsByConfig(&util.GRPCServerInfo{
+		ConnInfo:          util.ConnInfo{KeepaliveTime: "1m", MaxRecvMsgSize: "16MB", MaxSendMsgSize: "16MB"},
+		ConnectionTimeout: "5s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	me := NewGRPCServer(opts...)
+	if err := me.RegisterService((*login.LoginServiceServer)(nil), fullLoginService{}, "loginService.proto"); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := me.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer me.Stop()
+
+	cli := client.NewGRPCClient()
+	err = cli.Register(&util.ClientInfo{
+		Addr:     []string{addrs[0].String()},
+		ConnInfo: util.ConnInfo{MaxRecvMsgSize: "16MB", MaxSendMsgSize: "16MB"},
+		InterfaceList: []*util.InterfaceInfo{
+			{Name: "/login.loginService/login", ReqType: "login.loginRequest", RspType: "login.loginReply", Compression: client.COMPRESSION_GZIP},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userName := strings.Repeat("x", 5<<20)
+	rsp, err := cli.Invoke(context.Background(), "/login.loginService/login", &login.LoginRequest{UserName: userName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.(*login.LoginReply).GetSessionId() != userName {
+		t.Error("unexpected response")
+	}
+
+	if _, err := OptionsByConfig(&util.GRPCServerInfo{ConnInfo: util.ConnInfo{Compression: "zstd"}}); err == nil {
+		t.Error("unsupported compression should fail")
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	me := NewGRPCServer()
+	if err := me.RegisterService((*login.LoginServiceServer)(nil), fullLoginService{}, "loginService.proto"); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := me.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer me.Stop()
+
+	cli := client.NewGRPCClient()
+	err = cli.Register(&util.ClientInfo{
+		Addr:  []string{addrs[0].String()},
+		Codec: codec.JSON,
+		InterfaceList: []*util.InterfaceInfo{
+			{Name: "/login.loginService/login", ReqType: "login.loginRequest", RspType: "login.loginReply"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := cli.Invoke(context.Background(), "/login.loginService/login", &login.LoginRequest{UserName: "tommy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.(*login.LoginReply).GetSessionId() != "tommy" {
+		t.Errorf("unexpected response %v", rsp)
+	}
+
+	err = client.NewGRPCClient().Register(&util.ClientInfo{
+		Addr:          []string{addrs[0].String()},
+		InterfaceList: []*util.InterfaceInfo{{Name: "/login.loginService/login", ReqType: "login.loginRequest", RspType: "login.loginReply", Codec: "xml"}},
+	})
+	if err == nil {
+		t.Error("unsupported codec should fail")
+	}
+}
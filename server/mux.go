@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	//http2连接的开头, grpc一定是http2, 但http2不一定是grpc
+	http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	//等待客户端发送第一个包的时间
+	sniffTimeout = 10 * time.Second
+	//http2帧头的长度
+	frameHeaderLen = 9
+)
+
+var errListenerClosed = errors.New("listener closed")
+
+//sniffConn 已经读出来用于判断协议的数据需要重新交给后面的处理者
+type sniffConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (me *sniffConn) Read(b []byte) (int, error) {
+	return me.reader.Read(b)
+}
+
+//chanListener 由muxListener分发连接的虚拟监听
+type chanListener struct {
+	root   net.Listener
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newChanListener(root net.Listener) *chanListener {
+	return &chanListener{
+		root:   root,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (me *chanListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-me.conns:
+		return conn, nil
+	case <-me.closed:
+		return nil, errListenerClosed
+	}
+}
+
+//Close 关闭虚拟监听的同时关闭真正的监听, 另一个虚拟监听随后也会关闭
+func (me *chanListener) Close() error {
+	me.once.Do(func() {
+		close(me.closed)
+		me.root.Close()
+	})
+	return nil
+}
+
+func (me *chanListener) Addr() net.Addr {
+	return me.root.Addr()
+}
+
+func (me *chanListener) dispatch(conn net.Conn) {
+	select {
+	case me.conns <- conn:
+	case <-me.closed:
+		conn.Close()
+	}
+}
+
+//isHTTP2 逐字节比较http2的开头, 不一致时马上返回, 避免较短的http1请求等到超时
+func isHTTP2(reader *bufio.Reader) (bool, error) {
+	for i := 0; i < len(http2Preface); i++ {
+		b, err := reader.Peek(i + 1)
+		if err != nil {
+			return false, err
+		}
+		if b[i] != http2Preface[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+//isGRPC 读取http2连接的帧直到第一个请求头, content-type为application/grpc*时是grpc.
+//grpc客户端收到服务端的SETTINGS之后才发送请求, 收到客户端的SETTINGS时先回复一个空的SETTINGS.
+//读出的数据记录在record中
+func isGRPC(w io.Writer, reader *bufio.Reader, record *bytes.Buffer) (bool, error) {
+	if h2, err := isHTTP2(reader); err != nil || !h2 {
+		return false, err
+	}
+	tee := io.TeeReader(reader, record)
+	if _, err := io.CopyN(ioutil.Discard, tee, int64(len(http2Preface))); err != nil {
+		return false, err
+	}
+	framer := http2.NewFramer(w, tee)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return false, err
+		}
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				if err := framer.WriteSettings(); err != nil {
+					return false, err
+				}
+			}
+		case *http2.MetaHeadersFrame:
+			for _, field := range f.RegularFields() {
+				if field.Name == "content-type" {
+					return strings.HasPrefix(field.Value, "application/grpc"), nil
+				}
+			}
+			return false, nil
+		}
+	}
+}
+
+//ackFilter 去掉客户端对isGRPC发送的SETTINGS的确认, 其余的帧原样返回.
+//交给http服务的http2连接需要去掉, http服务没有发送过SETTINGS, 收到确认会断开连接; grpc服务会忽略
+type ackFilter struct {
+	r       io.Reader
+	pending []byte //已经读出的帧头
+	remain  int64  //当前帧还没有读出的负载
+	done    bool
+}
+
+func (me *ackFilter) Read(p []byte) (int, error) {
+	if len(me.pending) > 0 {
+		n := copy(p, me.pending)
+		me.pending = me.pending[n:]
+		return n, nil
+	}
+	if me.done {
+		return me.r.Read(p)
+	}
+	if me.remain > 0 {
+		if int64(len(p)) > me.remain {
+			p = p[:me.remain]
+		}
+		n, err := me.r.Read(p)
+		me.remain -= int64(n)
+		return n, err
+	}
+	header := make([]byte, frameHeaderLen)
+	if _, err := io.ReadFull(me.r, header); err != nil {
+		return 0, err
+	}
+	if http2.FrameType(header[3]) == http2.FrameSettings && http2.Flags(header[4]).Has(http2.FlagSettingsAck) {
+		me.done = true
+	} else {
+		me.remain = int64(header[0])<<16 | int64(header[1])<<8 | int64(header[2])
+		me.pending = header
+	}
+	return me.Read(p)
+}
+
+//StartMux 在一个地址上同时提供grpc和http服务, content-type为application/grpc的http2连接交给grpc,
+//其余交给httpHandler(支持不升级的http2). 返回实际监听的地址. Stop时一起关闭
+func (me *GRPCServer) StartMux(addr string, httpHandler http.Handler) (net.Addr, error) {
+	root, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	grpcListener := newChanListener(root)
+	httpListener := newChanListener(root)
+	go func() {
+		for {
+			conn, err := root.Accept()
+			if err != nil {
+				grpcListener.Close()
+				httpListener.Close()
+				return
+			}
+			go func(conn net.Conn) {
+				reader := bufio.NewReader(conn)
+				var record bytes.Buffer
+				conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+				grpc, err := isGRPC(conn, reader, &record)
+				conn.SetReadDeadline(time.Time{})
+				if err != nil {
+					conn.Close()
+					return
+				}
+				if grpc {
+					grpcListener.dispatch(&sniffConn{conn, io.MultiReader(&record, reader)})
+				} else if record.Len() > 0 {
+					//http2, 开头之后都是完整的帧
+					record.Next(len(http2Preface))
+					filter := &ackFilter{r: io.MultiReader(&record, reader)}
+					httpListener.dispatch(&sniffConn{conn, io.MultiReader(strings.NewReader(http2Preface), filter)})
+				} else {
+					httpListener.dispatch(&sniffConn{conn, reader})
+				}
+			}(conn)
+		}
+	}()
+	httpServer := &http.Server{Handler: h2c.NewHandler(httpHandler, &http2.Server{})}
+	me.serveMu.Lock()
+	me.httpServers = append(me.httpServers, httpServer)
+	me.serveMu.Unlock()
+	me.serveWG.Add(2)
+	go func() {
+		defer me.serveWG.Done()
+		if err := me.baseServer.Serve(grpcListener); err != nil {
+			me.serveFailed(addr, err)
+		}
+	}()
+	go func() {
+		defer me.serveWG.Done()
+		if err := httpServer.Serve(httpListener); err != nil && err != errListenerClosed && err != http.ErrServerClosed {
+			me.serveFailed(addr, err)
+		}
+	}()
+	return root.Addr(), nil
+}
+
+//ServeMux 同StartMux, 阻塞到服务停止
+func (me *GRPCServer) ServeMux(addr string, httpHandler http.Handler) error {
+	if _, err := me.StartMux(addr, httpHandler); err != nil {
+		return err
+	}
+	me.serveWG.Wait()
+	me.serveMu.Lock()
+	defer me.serveMu.Unlock()
+	return me.serveErr
+}
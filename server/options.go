@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	_ "local/sndaRpc/codec" //注册json codec, 支持application/grpc+json的请求
+	"local/sndaRpc/util"
+	"time"
+
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip" //注册gzip, 客户端用gzip压缩的请求可以自动解压
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	//COMPRESSION_GZIP 目前支持的压缩方式
+	COMPRESSION_GZIP = "gzip"
+)
+
+//Option 创建grpc.Server时的参数
+type Option func(*options)
+
+type options struct {
+	keepalive         *keepalive.ServerParameters
+	enforcement       *keepalive.EnforcementPolicy
+	maxRecvMsgSize    int
+	maxSendMsgSize    int
+	connectionTimeout time.Duration
+	compression       string
+}
+
+//Keepalive 连接空闲interval后发送ping, timeout内没有收到ack时断开连接
+func Keepalive(interval, timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.keepalive = &keepalive.ServerParameters{Time: interval, Timeout: timeout}
+	}
+}
+
+//KeepaliveEnforcement 客户端发送ping的间隔小于minTime时断开连接; permitWithoutStream 是否允许客户端在没有请求时发送ping
+func KeepaliveEnforcement(minTime time.Duration, permitWithoutStream bool) Option {
+	return func(opts *options) {
+		opts.enforcement = &keepalive.EnforcementPolicy{MinTime: minTime, PermitWithoutStream: permitWithoutStream}
+	}
+}
+
+//MaxRecvMsgSize 最大接收消息大小(字节), grpc默认4MB
+func MaxRecvMsgSize(n int) Option {
+	return func(opts *options) {
+		opts.maxRecvMsgSize = n
+	}
+}
+
+//MaxSendMsgSize 最大发送消息大小(字节)
+func MaxSendMsgSize(n int) Option {
+	return func(opts *options) {
+		opts.maxSendMsgSize = n
+	}
+}
+
+//ConnectionTimeout 新连接完成握手的超时
+func ConnectionTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.connectionTimeout = d
+	}
+}
+
+//Compression 响应的压缩方式, 目前只支持gzip. 不设置时按请求的压缩方式响应
+func Compression(name string) Option {
+	return func(opts *options) {
+		opts.compression = name
+	}
+}
+
+//OptionsByConfig 把<server>配置转成Option, 错误信息中带上出错的属性名
+func OptionsByConfig(info *util.GRPCServerInfo) ([]Option, error) {
+	var list []Option
+	if info == nil {
+		return list, nil
+	}
+	keepaliveTime, err := parseDuration(info.KeepaliveTime)
+	if err != nil {
+		return nil, fmt.Errorf("server: invalid keepalive-time %s", info.KeepaliveTime)
+	}
+	keepaliveTimeout, err := parseDuration(info.KeepaliveTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("server: invalid keepalive-timeout %s", info.KeepaliveTimeout)
+	}
+	if keepaliveTime > 0 || keepaliveTimeout > 0 {
+		list = append(list, Keepalive(keepaliveTime, keepaliveTimeout))
+	}
+	minTime, err := parseDuration(info.KeepaliveMinTime)
+	if err != nil {
+		return nil, fmt.Errorf("server: invalid keepalive-min-time %s", info.KeepaliveMinTime)
+	}
+	if minTime > 0 || info.PermitWithoutStream {
+		if minTime == 0 {
+			//grpc的默认值
+			minTime = 5 * time.Minute
+		}
+		list = append(list, KeepaliveEnforcement(minTime, info.PermitWithoutStream))
+	}
+	if len(info.MaxRecvMsgSize) > 0 {
+		n, err := util.ParseSize(info.MaxRecvMsgSize)
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid max-recv-msg-size %s", info.MaxRecvMsgSize)
+		}
+		list = append(list, MaxRecvMsgSize(n))
+	}
+	if len(info.MaxSendMsgSize) > 0 {
+		n, err := util.ParseSize(info.MaxSendMsgSize)
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid max-send-msg-size %s", info.MaxSendMsgSize)
+		}
+		list = append(list, MaxSendMsgSize(n))
+	}
+	connectionTimeout, err := parseDuration(info.ConnectionTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("server: invalid connection-timeout %s", info.ConnectionTimeout)
+	}
+	if connectionTimeout > 0 {
+		list = append(list, ConnectionTimeout(connectionTimeout))
+	}
+	if len(info.Compression) > 0 {
+		if info.Compression != COMPRESSION_GZIP {
+			return nil, fmt.Errorf("server: invalid compression %s, only gzip is supported", info.Compression)
+		}
+		list = append(list, Compression(info.Compression))
+	}
+	return list, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+//serverOptions 转成grpc.NewServer的参数
+func (me *options) serverOptions() []grpc.ServerOption {
+	var list []grpc.ServerOption
+	if me.keepalive != nil {
+		list = append(list, grpc.KeepaliveParams(*me.keepalive))
+	}
+	if me.enforcement != nil {
+		list = append(list, grpc.KeepaliveEnforcementPolicy(*me.enforcement))
+	}
+	if me.maxRecvMsgSize > 0 {
+		list = append(list, grpc.MaxRecvMsgSize(me.maxRecvMsgSize))
+	}
+	if me.maxSendMsgSize > 0 {
+		list = append(list, grpc.MaxSendMsgSize(me.maxSendMsgSize))
+	}
+	if me.connectionTimeout > 0 {
+		list = append(list, grpc.ConnectionTimeout(me.connectionTimeout))
+	}
+	if me.compression == COMPRESSION_GZIP {
+		list = append(list, grpc.RPCCompressor(grpc.NewGZIPCompressor()))
+	}
+	return list
+}
+
+//newBaseServer 按参数创建grpc.Server
+func newBaseServer(opts ...Option) *grpc.Server {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return grpc.NewServer(o.serverOptions()...)
+}
+
+//SetOptions 按参数重新创建grpc.Server, 必须在注册服务之前调用
+func (me *GRPCServer) SetOptions(opts ...Option) error {
+	if len(me.baseServer.GetServiceInfo()) > 0 {
+		return fmt.Errorf("options must be set before registering services")
+	}
+	me.baseServer = newBaseServer(opts...)
+	return nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//MethodStatus 接口是否可用
+type MethodStatus struct {
+	Name    string `json:"name"` //完整接口名, 如 /login.loginService/login
+	Enabled bool   `json:"enabled"`
+}
+
+//Methods 返回已注册的全部接口和是否可用, 按接口名排序
+func (me *GRPCServer) Methods() []*MethodStatus {
+	var list []*MethodStatus
+	me.confMu.RLock()
+	for serviceName, info := range me.baseServer.GetServiceInfo() {
+		for _, method := range info.Methods {
+			name := "/" + serviceName + "/" + method.Name
+			list = append(list, &MethodStatus{Name: name, Enabled: !me.disabled[name]})
+		}
+	}
+	me.confMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+//SetMethodEnabled 运行时停用或恢复一个接口, 停用后请求返回Unavailable
+func (me *GRPCServer) SetMethodEnabled(fullMethod string, enabled bool) error {
+	if !me.hasMethod(fullMethod) {
+		return fmt.Errorf("method %s not found", fullMethod)
+	}
+	me.confMu.Lock()
+	if enabled {
+		delete(me.disabled, fullMethod)
+	} else {
+		me.disabled[fullMethod] = true
+	}
+	me.confMu.Unlock()
+	return nil
+}
+
+func (me *GRPCServer) hasMethod(fullMethod string) bool {
+	for serviceName, info := range me.baseServer.GetServiceInfo() {
+		for _, method := range info.Methods {
+			if "/"+serviceName+"/"+method.Name == fullMethod {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//switchParams 被停用的接口直接返回Unavailable, 放在日志之后, 停用期间的请求也会记录
+func (me *GRPCServer) switchParams(next endpoint.Endpoint) (endpoint.Endpoint, error) {
+	var ep endpoint.Endpoint = func(ctx context.Context, request interface{}) (interface{}, error) {
+		fullMethod := methodFromContext(ctx)
+		me.confMu.RLock()
+		disabled := me.disabled[fullMethod]
+		me.confMu.RUnlock()
+		if disabled {
+			return nil, status.Errorf(codes.Unavailable, "method %s is disabled", fullMethod)
+		}
+		return next(ctx, request)
+	}
+	return ep, nil
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"local/sndaRpc/validate"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/context"
+)
+
+//validateParams 调用业务方法前按proto字段option和<validate>中的规则校验入参,
+//不合法时返回InvalidArgument, 字段错误放在BadRequest details里
+func (me *GRPCServer) validateParams(next endpoint.Endpoint) (endpoint.Endpoint, error) {
+	var ep endpoint.Endpoint = func(ctx context.Context, request interface{}) (interface{}, error) {
+		if err := validate.Validate(methodFromContext(ctx), request); err != nil {
+			if verr, ok := err.(*validate.Error); ok {
+				return nil, verr.Status().Err()
+			}
+			return nil, err
+		}
+		return next(ctx, request)
+	}
+	return ep, nil
+}
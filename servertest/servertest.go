@@ -0,0 +1,167 @@
+//Package servertest 在内存中启动服务端, 客户端和http网关, 用于单元测试和集成测试, 不占用端口
+package servertest
+
+import (
+	"fmt"
+	"io"
+	"local/sndaRpc/client"
+	"local/sndaRpc/gateway"
+	"local/sndaRpc/server"
+	"local/sndaRpc/util"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	//ADDR 客户端中配置的地址, 实际连接内存中的监听
+	ADDR = "bufconn"
+
+	bufSize = 1024 * 1024
+)
+
+//Service 要注册的服务, 方法信息从proto文件描述中自动获取
+type Service struct {
+	HandlerInterface interface{} //如 (*login.LoginServiceServer)(nil)
+	HandlerCls       interface{} //如 &login.TestService{}
+	ProtoName        string      //如 loginService.proto
+}
+
+//Config 测试环境的配置, 都可以为空
+type Config struct {
+	Services      []Service
+	ServiceConfig []*util.ServerInfo      //<service>策略配置, 如auth, 限流, 幂等
+	Gateway       []*util.HTTPGateWayInfo //http网关的路由, 为空时不注册
+	ServerOptions []server.Option
+	ClientOptions []client.Option
+	Logger        log.Logger //为空时不输出日志
+}
+
+//Env 一个测试用例的服务端, 客户端和网关. New创建的在测试结束时自动关闭, Start创建的用完调用Close
+type Env struct {
+	Server  *server.GRPCServer
+	Client  *client.GRPCClient
+	Gateway *gateway.HTTPGateWay
+
+	listener  *bufconn.Listener
+	closeOnce sync.Once
+	closeErr  error
+}
+
+//New 创建测试环境, 出错时t.Fatal. 测试结束时自动Close
+func New(t testing.TB, conf *Config) *Env {
+	env, err := Start(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		env.Close()
+	})
+	return env
+}
+
+//Start 按配置注册服务并在内存监听上启动, 客户端注册全部服务的全部接口
+func Start(conf *Config) (*Env, error) {
+	if conf == nil {
+		conf = new(Config)
+	}
+	logger := conf.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	env := &Env{
+		Server:   server.NewGRPCServer(conf.ServerOptions...),
+		Client:   client.NewGRPCClient(),
+		Gateway:  gateway.NewHTTPGateway(),
+		listener: bufconn.Listen(bufSize),
+	}
+	env.Server.SetLogger(logger)
+	env.Server.SetAuditLogger(logger)
+	env.Client.SetLogger(logger)
+	env.Gateway.SetLogger(logger)
+	if err := env.Server.SetServiceConfig(conf.ServiceConfig); err != nil {
+		env.listener.Close()
+		return nil, err
+	}
+	clientInfo := &util.ClientInfo{Name: ADDR, Addr: []string{ADDR}}
+	for _, svc := range conf.Services {
+		serviceName, methodList, err := server.DiscoverMethods(svc.HandlerInterface, svc.HandlerCls, svc.ProtoName)
+		if err != nil {
+			env.listener.Close()
+			return nil, err
+		}
+		if err := env.Server.Register(svc.HandlerInterface, svc.HandlerCls, serviceName, svc.ProtoName, methodList...); err != nil {
+			env.listener.Close()
+			return nil, err
+		}
+		for _, method := range methodList {
+			clientInfo.InterfaceList = append(clientInfo.InterfaceList, &util.InterfaceInfo{
+				Name:    serviceName + "/" + method.Name,
+				ReqType: messageName(method.ReqType),
+				RspType: messageName(method.RspType),
+			})
+		}
+	}
+	env.Server.StartListener(env.listener)
+
+	env.Client.SetOptions(conf.ClientOptions...)
+	env.Client.SetOptions(client.Dialer(env.Dial))
+	if len(clientInfo.InterfaceList) > 0 {
+		if err := env.Client.Register(clientInfo); err != nil {
+			env.Close()
+			return nil, err
+		}
+	}
+	env.Gateway.SetClient(env.Client)
+	if err := env.Gateway.Register(conf.Gateway); err != nil {
+		env.Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+func messageName(tp reflect.Type) string {
+	return proto.MessageName(reflect.Zero(reflect.PtrTo(tp)).Interface().(proto.Message))
+}
+
+//Dial 建立一个到内存服务的连接, 用于自己创建grpc.ClientConn, 如 grpc.WithDialer(env.Dial)
+func (me *Env) Dial(string, time.Duration) (net.Conn, error) {
+	return me.listener.Dial()
+}
+
+//Do 通过网关处理一个http请求, 不经过网络
+func (me *Env) Do(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	me.Gateway.Handler().ServeHTTP(w, r)
+	return w
+}
+
+//Request 构造请求并通过网关处理, 如 env.Request("POST", "/login", strings.NewReader(`{"userName":"tommy"}`))
+//有body时Content-Type为application/json
+func (me *Env) Request(method, target string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return me.Do(r)
+}
+
+//Close 关闭客户端连接并停止服务端, 可以重复调用
+func (me *Env) Close() error {
+	me.closeOnce.Do(func() {
+		err := me.Client.Close()
+		me.Server.Stop()
+		if err != nil {
+			me.closeErr = fmt.Errorf("close client: %s", err)
+		}
+	})
+	return me.closeErr
+}
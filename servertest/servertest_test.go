@@ -0,0 +1,85 @@
+package servertest
+
+import (
+	"encoding/json"
+	"local/sndaRpc/pb/login"
+	"local/sndaRpc/util"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type loginService struct{}
+
+func (s *loginService) Login(ctx context.Context, in *login.LoginRequest) (*login.LoginReply, error) {
+	if in.GetUserName() == "" {
+		return nil, status.Error(codes.Unauthenticated, "empty user")
+	}
+	return &login.LoginReply{SessionId: "session-" + in.GetUserName()}, nil
+}
+
+func (s *loginService) Logout(ctx context.Context, in *login.LogoutRequest) (*login.LogoutReply, error) {
+	return &login.LogoutReply{}, nil
+}
+
+func newEnv(t *testing.T) *Env {
+	return New(t, &Config{
+		Services: []Service{{(*login.LoginServiceServer)(nil), &loginService{}, "loginService.proto"}},
+		Gateway:  []*util.HTTPGateWayInfo{{Name: "/login", Method: "/login.loginService/login"}},
+	})
+}
+
+func TestClient(t *testing.T) {
+	env := newEnv(t)
+
+	rsp, err := env.Client.Invoke(context.Background(), "/login.loginService/login", &login.LoginRequest{UserName: "tommy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionID := rsp.(*login.LoginReply).GetSessionId(); sessionID != "session-tommy" {
+		t.Errorf("unexpected session %s", sessionID)
+	}
+	_, err = env.Client.Invoke(context.Background(), "/login.loginService/login", &login.LoginRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expect Unauthenticated, got %v", err)
+	}
+	if _, err := env.Client.Invoke(context.Background(), "/login.loginService/logout", &login.LogoutRequest{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGateway(t *testing.T) {
+	env := newEnv(t)
+
+	w := env.Request("POST", "/login", strings.NewReader(`{"userName":"alice"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d %s", w.Code, w.Body)
+	}
+	rsp := new(login.LoginReply)
+	if err := json.Unmarshal(w.Body.Bytes(), rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.GetSessionId() != "session-alice" {
+		t.Errorf("unexpected response %s", w.Body)
+	}
+	if w := env.Request("GET", "/login?userName=bob", nil); !strings.Contains(w.Body.String(), "session-bob") {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+}
+
+//每个测试用例的环境互不影响
+func TestIsolation(t *testing.T) {
+	first := newEnv(t)
+	second := newEnv(t)
+	first.Close()
+	if _, err := second.Client.Invoke(context.Background(), "/login.loginService/login", &login.LoginRequest{UserName: "tommy"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := first.Client.Invoke(context.Background(), "/login.loginService/login", &login.LoginRequest{UserName: "tommy"}); err == nil {
+		t.Error("closed env should fail")
+	}
+}
@@ -3,18 +3,12 @@ package common
 import (
 	"local/sndaRpc/inject"
 	"local/sndaRpc/pb/common"
-	"local/sndaRpc/server"
 	"golang.org/x/net/context"
 )
 
 func init() {
 	inject.Inject((*common.CommonServiceServer)(nil))
 	inject.Inject(CommonService{})
-	server.DefaultGRPCServer().Register((*common.CommonServiceServer)(nil), CommonService{},
-		"/common.commonService",
-		"common.proto",
-		server.MakeMethodInfoByName("appInfo", "common.appInfoRequest", "common.appInfoReply"),
-	)
 }
 
 type CommonService struct {
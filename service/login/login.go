@@ -5,7 +5,6 @@ import (
 	"local/sndaRpc/dbutil"
 	"local/sndaRpc/inject"
 	"local/sndaRpc/pb/login"
-	"local/sndaRpc/server"
 	"local/sndaRpc/util"
 
 	"golang.org/x/net/context"
@@ -14,12 +13,6 @@ import (
 func init() {
 	inject.Inject((*login.LoginServiceServer)(nil))
 	inject.Inject(TestService{})
-	server.DefaultGRPCServer().Register((*login.LoginServiceServer)(nil), TestService{},
-		"/login.loginService",
-		"loginService.proto",
-		server.MakeMethodInfoByName("login", "login.loginRequest", "login.loginReply"),
-		server.MakeMethodInfoByName("logout", "login.logoutRequest", "login.logoutRequest"),
-	)
 }
 
 type TestService struct {
@@ -27,7 +20,7 @@ type TestService struct {
 
 func (s *TestService) Login(ctx context.Context, in *login.LoginRequest) (*login.LoginReply, error) {
 	rsp := new(login.LoginReply)
-	rds, err := cache.DefaultRedisManager().Get("redis1")
+	rds, err := cache.DefaultRedisManager().GetContext(ctx, "redis1")
 	if err != nil {
 		rsp.Err = err.Error()
 		return nil, err
@@ -41,7 +34,8 @@ func (s *TestService) Login(ctx context.Context, in *login.LoginRequest) (*login
 }
 func (s *TestService) Logout(ctx context.Context, in *login.LogoutRequest) (*login.LogoutReply, error) {
 	rsp := new(login.LogoutReply)
-	data, err := dbutil.DefaultMySQLManager().Query(
+	data, err := dbutil.DefaultMySQLManager().QueryContext(
+		ctx,
 		"global",
 		"SELECT * FROM circle_first_ad_more where circle_id=?",
 		2,
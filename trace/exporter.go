@@ -0,0 +1,128 @@
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	exporterMu      sync.RWMutex
+	defaultExporter Exporter = nopExporter{}
+)
+
+//Exporter span导出接口, span结束时调用
+type Exporter interface {
+	Export(span *Span) error
+}
+
+//SetExporter 设置全局exporter, nil表示不导出
+func SetExporter(exporter Exporter) {
+	if exporter == nil {
+		exporter = nopExporter{}
+	}
+	exporterMu.Lock()
+	defaultExporter = exporter
+	exporterMu.Unlock()
+}
+
+func export(span *Span) {
+	exporterMu.RLock()
+	exporter := defaultExporter
+	exporterMu.RUnlock()
+	exporter.Export(span)
+}
+
+type nopExporter struct {
+}
+
+func (nopExporter) Export(*Span) error {
+	return nil
+}
+
+//InMemoryExporter 把span保存在内存里, 测试用
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+//NewInMemoryExporter 创建InMemoryExporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return new(InMemoryExporter)
+}
+
+//Export 保存span
+func (me *InMemoryExporter) Export(span *Span) error {
+	me.mu.Lock()
+	me.spans = append(me.spans, span)
+	me.mu.Unlock()
+	return nil
+}
+
+//Spans 返回已导出的span(按结束顺序)
+func (me *InMemoryExporter) Spans() []*Span {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	spans := make([]*Span, len(me.spans))
+	copy(spans, me.spans)
+	return spans
+}
+
+//Reset 清空已保存的span
+func (me *InMemoryExporter) Reset() {
+	me.mu.Lock()
+	me.spans = nil
+	me.mu.Unlock()
+}
+
+//FileExporter 每个span写一行json, 本地调试用
+type FileExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+//NewFileExporter 以追加方式打开文件
+func NewFileExporter(fileName string) (*FileExporter, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{w: file, closer: file}, nil
+}
+
+//NewWriterExporter 写到任意writer
+func NewWriterExporter(w io.Writer) *FileExporter {
+	return &FileExporter{w: w}
+}
+
+//Export 写一行json
+func (me *FileExporter) Export(span *Span) error {
+	span.mu.Lock()
+	b, err := json.Marshal(span)
+	span.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.w == nil {
+		return errors.New("exporter closed")
+	}
+	_, err = me.w.Write(b)
+	return err
+}
+
+//Close 关闭文件
+func (me *FileExporter) Close() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.w = nil
+	if me.closer != nil {
+		return me.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HEADER W3C trace context 的header名, http和grpc metadata中都用这个key
+	HEADER = "traceparent"
+
+	//span的类型
+	KIND_SERVER   = "server"
+	KIND_CLIENT   = "client"
+	KIND_INTERNAL = "internal"
+)
+
+var (
+	invalidTraceparentErr = errors.New("invalid traceparent")
+)
+
+//spanKey context中存放span用的key
+type spanKey struct {
+}
+
+//remoteKey context中存放远端传过来的SpanContext用的key
+type remoteKey struct {
+}
+
+//SpanContext 跨进程传递的span信息
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+//IsValid traceID和spanID都合法才算有效
+func (me SpanContext) IsValid() bool {
+	return isHex(me.TraceID, 32) && isHex(me.SpanID, 16)
+}
+
+//Traceparent 按照W3C格式输出, 如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (me SpanContext) Traceparent() string {
+	flags := "00"
+	if me.Sampled {
+		flags = "01"
+	}
+	return "00-" + me.TraceID + "-" + me.SpanID + "-" + flags
+}
+
+//ParseTraceparent 解析W3C traceparent
+func ParseTraceparent(s string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, invalidTraceparentErr
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	//00版本只允许4段, 更高的版本按规范尽量兼容
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return SpanContext{}, invalidTraceparentErr
+	}
+	if !isHex(flags, 2) {
+		return SpanContext{}, invalidTraceparentErr
+	}
+	sc := SpanContext{TraceID: traceID, SpanID: spanID}
+	if !sc.IsValid() || strings.Count(traceID, "0") == 32 || strings.Count(spanID, "0") == 16 {
+		return SpanContext{}, invalidTraceparentErr
+	}
+	b, _ := hex.DecodeString(flags)
+	sc.Sampled = b[0]&0x01 == 0x01
+	return sc, nil
+}
+
+//Span 一次调用的耗时信息
+type Span struct {
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   time.Duration          `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+
+	sampled bool
+	mu      sync.Mutex
+	ended   bool
+}
+
+//Context 返回用于向下游传递的SpanContext
+func (me *Span) Context() SpanContext {
+	return SpanContext{TraceID: me.TraceID, SpanID: me.SpanID, Sampled: me.sampled}
+}
+
+//SetAttribute 记录附加信息
+func (me *Span) SetAttribute(key string, value interface{}) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.Attributes == nil {
+		me.Attributes = make(map[string]interface{})
+	}
+	me.Attributes[key] = value
+}
+
+//SetError 记录错误, nil会被忽略
+func (me *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	me.mu.Lock()
+	me.Error = err.Error()
+	me.mu.Unlock()
+}
+
+//Finish 结束span并交给exporter. 重复调用只有第一次生效
+func (me *Span) Finish() {
+	me.mu.Lock()
+	if me.ended {
+		me.mu.Unlock()
+		return
+	}
+	me.ended = true
+	me.End = time.Now()
+	me.Duration = me.End.Sub(me.Start)
+	me.mu.Unlock()
+	if me.sampled {
+		export(me)
+	}
+}
+
+//StartSpan 创建span并放入context.
+//context里有本地span时作为其子span; 否则使用远端传过来的SpanContext作为父span; 都没有则开启新的trace
+func StartSpan(ctx context.Context, name, kind string) (context.Context, *Span) {
+	span := &Span{
+		Name:    name,
+		Kind:    kind,
+		SpanID:  newID(8),
+		Start:   time.Now(),
+		sampled: true,
+	}
+	if parent, ok := FromContext(ctx); ok {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.sampled = parent.sampled
+	} else if remote, ok := RemoteFromContext(ctx); ok {
+		span.TraceID = remote.TraceID
+		span.ParentID = remote.SpanID
+		span.sampled = remote.Sampled
+	} else {
+		span.TraceID = newID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+//FromContext 从context里获取当前span
+func FromContext(ctx context.Context) (span *Span, ok bool) {
+	span, ok = ctx.Value(spanKey{}).(*Span)
+	return
+}
+
+//ContextWithRemote 放入上游传过来的SpanContext
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+//RemoteFromContext 获取上游传过来的SpanContext
+func RemoteFromContext(ctx context.Context) (sc SpanContext, ok bool) {
+	sc, ok = ctx.Value(remoteKey{}).(SpanContext)
+	return
+}
+
+//ContextWithTraceparent 解析traceparent并放入context, 解析失败时原样返回ctx
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if len(traceparent) == 0 {
+		return ctx
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+//Traceparent 返回需要传给下游的traceparent, context里没有span时返回空串
+func Traceparent(ctx context.Context) string {
+	if span, ok := FromContext(ctx); ok {
+		return span.Context().Traceparent()
+	}
+	if sc, ok := RemoteFromContext(ctx); ok {
+		return sc.Traceparent()
+	}
+	return ""
+}
+
+//生成n字节的随机id, 以16进制输出
+func newID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		//随机数失败时退化成时间戳, 保证id非空
+		return fmt.Sprintf("%0*x", n*2, time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
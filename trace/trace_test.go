@@ -0,0 +1,78 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("unexpected span context %+v", sc)
+	}
+	if sc.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("unexpected traceparent %s", sc.Traceparent())
+	}
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(s); err == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	ctx := ContextWithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := StartSpan(ctx, "root", KIND_SERVER)
+	_, child := StartSpan(ctx, "child", KIND_CLIENT)
+	child.Finish()
+	root.Finish()
+	root.Finish()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expect 2 spans, got %d", len(spans))
+	}
+	if spans[1].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spans[1].ParentID != "00f067aa0ba902b7" {
+		t.Errorf("root span should continue remote trace: %+v", spans[1])
+	}
+	if spans[0].TraceID != root.TraceID || spans[0].ParentID != root.SpanID {
+		t.Errorf("child span should be a child of root: %+v", spans[0])
+	}
+	if Traceparent(ctx) != root.Context().Traceparent() {
+		t.Errorf("unexpected traceparent %s", Traceparent(ctx))
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	var buf bytes.Buffer
+	SetExporter(NewWriterExporter(&buf))
+	defer SetExporter(nil)
+
+	_, span := StartSpan(context.Background(), "query", KIND_CLIENT)
+	span.SetAttribute("db", "global")
+	span.Finish()
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["span_id"] != span.SpanID || line["name"] != "query" {
+		t.Errorf("unexpected line %s", buf.String())
+	}
+}
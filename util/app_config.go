@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
+	"strconv"
 )
 
 //InterfaceInfo client的配置
@@ -14,6 +15,14 @@ type InterfaceInfo struct {
 	ReqType string `xml:"request-type,attr" json:"req_type,omitempty"`
 	//出参类型名称, 对应proto生成的go文件中的类型. 如 login.loginReply
 	RspType string `xml:"response-type,attr" json:"rsp_type,omitempty"`
+	//请求的压缩方式, 为空时使用<client>上的配置
+	Compression string `xml:"compression,attr" json:"compression,omitempty"`
+	//请求的编码方式, proto或json, 为空时使用<client>上的配置
+	Codec string `xml:"codec,attr" json:"codec,omitempty"`
+	//流式接口: server(服务端流), client(客户端流), bidi(双向流), 为空时是普通接口. 流式接口只能用NewStream调用
+	Stream string `xml:"stream,attr" json:"stream,omitempty"`
+	//携带幂等key的metadata, 与服务端<idempotency header="">一致, 默认x-idempotency-key. 网关从同名的http header读取
+	IdempotencyHeader string `xml:"idempotency-header,attr" json:"idempotency_header,omitempty"`
 }
 
 //MethodInfo <method name="/login.loginService/login" request-type="login.loginRequest" response-type="login.loginReply"/>
@@ -24,6 +33,107 @@ type MethodInfo struct {
 	ReqType string `xml:"request-type,attr" json:"req_type,omitempty"`
 	//出参类型名称, 对应proto生成的go文件中的类型. 如 login.loginReply
 	RspType string `xml:"response-type,attr" json:"rsp_type,omitempty"`
+	//认证方式, 对应<auth>的name, 多个用逗号分隔, 任意一个通过即可. 为空时使用service上的配置
+	Auth string `xml:"auth,attr" json:"auth,omitempty"`
+	//授权规则. deny与service上的合并; allow不为空时替换service上的allow
+	AllowList []*ACLRule `xml:"allow" json:"allow_list,omitempty"`
+	DenyList  []*ACLRule `xml:"deny" json:"deny_list,omitempty"`
+	//限流配置, 没配置的项使用service上的配置
+	LimitInfo
+	//入参校验规则
+	Validate *ValidateInfo `xml:"validate" json:"validate,omitempty"`
+	//幂等配置, 为空时不启用
+	Idempotency *IdempotencyInfo `xml:"idempotency" json:"idempotency,omitempty"`
+}
+
+//IdempotencyInfo 幂等配置, 相同幂等key的请求只处理一次, 重复请求返回第一次的结果
+//<idempotency redis="redis1" ttl="24h" wait="5s" lock-ttl="30s" header="x-idempotency-key"/>
+type IdempotencyInfo struct {
+	//保存结果用的redis, 对应<redis>的name
+	Redis string `xml:"redis,attr" json:"redis,omitempty"`
+	//结果保存时间, 默认24h
+	TTL string `xml:"ttl,attr" json:"ttl,omitempty"`
+	//并发的重复请求等待第一个请求完成的时间, 默认5s
+	Wait string `xml:"wait,attr" json:"wait,omitempty"`
+	//处理中状态的保存时间, 防止进程异常退出后一直无法重试, 默认30s
+	LockTTL string `xml:"lock-ttl,attr" json:"lock_ttl,omitempty"`
+	//携带幂等key的metadata, 默认x-idempotency-key
+	Header string `xml:"header,attr" json:"header,omitempty"`
+}
+
+//ValidateInfo 入参校验规则
+//<validate>
+//<field name="userName" required="true" min-len="1" max-len="32" regex="^[a-zA-Z0-9_]+$"/>
+//<field name="srcCode" min="0" max="100" enum="1,2,3"/>
+//</validate>
+type ValidateInfo struct {
+	FieldList []*FieldRule `xml:"field" json:"field_list,omitempty"`
+}
+
+//FieldRule 字段校验规则, 没配置的项不校验
+type FieldRule struct {
+	//proto中的字段名, 嵌套字段用.分隔, 如 user.name
+	Name     string `xml:"name,attr" json:"name,omitempty"`
+	Required bool   `xml:"required,attr" json:"required,omitempty"`
+	//字符串/bytes的长度, repeated字段的个数
+	MinLen string `xml:"min-len,attr" json:"min_len,omitempty"`
+	MaxLen string `xml:"max-len,attr" json:"max_len,omitempty"`
+	//数字的取值范围
+	Min   string `xml:"min,attr" json:"min,omitempty"`
+	Max   string `xml:"max,attr" json:"max,omitempty"`
+	Regex string `xml:"regex,attr" json:"regex,omitempty"`
+	//允许的取值, 逗号分隔
+	Enum string `xml:"enum,attr" json:"enum,omitempty"`
+}
+
+//LimitInfo 服务端限流配置, 可以配置在<service>和<method>上, 0表示不限制
+//<method name="login" qps="1000" burst="100" caller-qps="10" max-concurrent="200" shed-latency="500ms"/>
+type LimitInfo struct {
+	//接口总的令牌桶速率(个/秒)和容量
+	QPS   float64 `xml:"qps,attr" json:"qps,omitempty"`
+	Burst int     `xml:"burst,attr" json:"burst,omitempty"`
+	//每个调用方(认证后的principal, 未认证时为对端ip)的令牌桶速率和容量
+	CallerQPS   float64 `xml:"caller-qps,attr" json:"caller_qps,omitempty"`
+	CallerBurst int     `xml:"caller-burst,attr" json:"caller_burst,omitempty"`
+	//最大并发请求数
+	MaxConcurrent int `xml:"max-concurrent,attr" json:"max_concurrent,omitempty"`
+	//自适应降载: 处理中的请求数超过shed-inflight, 或平均耗时超过shed-latency时按比例丢弃请求
+	ShedInflight int    `xml:"shed-inflight,attr" json:"shed_inflight,omitempty"`
+	ShedLatency  string `xml:"shed-latency,attr" json:"shed_latency,omitempty"`
+}
+
+//ConnInfo grpc连接参数, 服务端<server>和客户端<client>共用, 为空时使用grpc的默认值
+type ConnInfo struct {
+	//没有数据时发送keepalive ping的间隔和等待ack的超时, 如 2h, 20s
+	KeepaliveTime    string `xml:"keepalive-time,attr" json:"keepalive_time,omitempty"`
+	KeepaliveTimeout string `xml:"keepalive-timeout,attr" json:"keepalive_timeout,omitempty"`
+	//没有进行中的请求时是否也发送(服务端: 是否允许客户端这样做)
+	PermitWithoutStream bool `xml:"permit-without-stream,attr" json:"permit_without_stream,omitempty"`
+	//最大接收/发送消息大小, 如 16MB, 1024. grpc默认接收4MB
+	MaxRecvMsgSize string `xml:"max-recv-msg-size,attr" json:"max_recv_msg_size,omitempty"`
+	MaxSendMsgSize string `xml:"max-send-msg-size,attr" json:"max_send_msg_size,omitempty"`
+	//压缩方式, 目前只支持gzip
+	Compression string `xml:"compression,attr" json:"compression,omitempty"`
+}
+
+//GRPCServerInfo grpc服务端参数
+//<server keepalive-time="2h" keepalive-timeout="20s" keepalive-min-time="5m" max-recv-msg-size="16MB" connection-timeout="120s" compression="gzip"/>
+type GRPCServerInfo struct {
+	ConnInfo
+	//允许客户端发送keepalive ping的最小间隔, 太频繁时断开连接
+	KeepaliveMinTime string `xml:"keepalive-min-time,attr" json:"keepalive_min_time,omitempty"`
+	//新连接完成握手的超时
+	ConnectionTimeout string `xml:"connection-timeout,attr" json:"connection_timeout,omitempty"`
+}
+
+//ACLRule 授权规则, 配置了的属性都满足才算匹配, 属性内多个值用逗号分隔, 满足一个即可
+//<allow principal="gateway,app1" role="admin" cidr="10.0.0.0/8,127.0.0.1/32"/>
+//<deny cidr="192.168.1.0/24"/>
+type ACLRule struct {
+	//调用方标识, *表示任意已认证的调用方
+	Principal string `xml:"principal,attr" json:"principal,omitempty"`
+	Role      string `xml:"role,attr" json:"role,omitempty"`
+	CIDR      string `xml:"cidr,attr" json:"cidr,omitempty"`
 }
 
 //ServiceInfo 服务接口注册信息
@@ -39,14 +149,92 @@ type ServerInfo struct {
 	ProtoName        string        `xml:"proto-name,attr" json:"proto_name,omitempty"`
 	HandlerInterface string        `xml:"handler-interface,attr" json:"handler_interface,omitempty"`
 	HandlerCls       string        `xml:"handler-class,attr" json:"handler_cls,omitempty"`
+	Enabled          string        `xml:"enabled,attr" json:"enabled,omitempty"` //为false时不注册该服务, 默认true
+	Auth             string        `xml:"auth,attr" json:"auth,omitempty"`
+	AllowList        []*ACLRule    `xml:"allow" json:"allow_list,omitempty"`
+	DenyList         []*ACLRule    `xml:"deny" json:"deny_list,omitempty"`
 	MethodList       []*MethodInfo `xml:"method" json:"method_list,omitempty"`
+	LimitInfo
+}
+
+//IsEnabled 是否注册该服务, 没配置enabled时为true
+func (me *ServerInfo) IsEnabled() (bool, error) {
+	if len(me.Enabled) == 0 {
+		return true, nil
+	}
+	return strconv.ParseBool(me.Enabled)
+}
+
+//AuthInfo 认证方式配置
+//<auth name="inner" type="apikey" header="x-api-key">
+//<key id="serviceA">secret</key>
+//</auth>
+//<auth name="sign" type="hmac" window="5m">
+//<key id="app1">secret</key>
+//</auth>
+//<auth name="token" type="jwt" alg="HS256" secret="secret" issuer="passport" role-claim="roles"/>
+//网关中使用:
+//<auth name="public" type="apikey" header="x-api-key" query="api_key"><key id="web">key</key></auth>
+//<auth name="app" type="sign" alg="md5" window="5m"><key id="app1">secret</key></auth>
+//后端服务信任网关转发的调用方身份: <auth name="gateway" type="forwarded"/>
+type AuthInfo struct {
+	Name string `xml:"name,attr" json:"name,omitempty"`
+	//取值: apikey, hmac, jwt, sign(http请求签名, 只能在网关使用), forwarded(网关转发的身份)
+	Type   string `xml:"type,attr" json:"type,omitempty"`
+	Header string `xml:"header,attr" json:"header,omitempty"`
+	//apikey在网关中可以放在该query参数中
+	Query string `xml:"query,attr" json:"query,omitempty"`
+	//hmac/sign允许的时间偏差, 如 5m. nonce在window内不允许重复, 只在单个进程内检查, 多实例部署时不能防止跨实例的重放
+	Window string `xml:"window,attr" json:"window,omitempty"`
+	//jwt签名算法: HS256, HS384, HS512, RS256, RS384, RS512; sign签名算法: md5, sha256
+	Alg string `xml:"alg,attr" json:"alg,omitempty"`
+	//HS算法的密钥
+	Secret string `xml:"secret,attr" json:"secret,omitempty"`
+	//RS算法的公钥文件(PEM)
+	PublicKey string     `xml:"public-key,attr" json:"public_key,omitempty"`
+	Issuer    string     `xml:"issuer,attr" json:"issuer,omitempty"`
+	Audience  string     `xml:"audience,attr" json:"audience,omitempty"`
+	RoleClaim string     `xml:"role-claim,attr" json:"role_claim,omitempty"`
+	KeyList   []*AuthKey `xml:"key" json:"key_list,omitempty"`
+}
+
+//AuthKey apikey和hmac用的密钥, id为调用方标识
+type AuthKey struct {
+	ID    string `xml:"id,attr" json:"id,omitempty"`
+	Roles string `xml:"roles,attr" json:"roles,omitempty"`
+	Value string `xml:",chardata" json:"value,omitempty"`
+}
+
+//Merge 用other中非0的项覆盖, 返回新的配置
+func (me LimitInfo) Merge(other *LimitInfo) LimitInfo {
+	if other == nil {
+		return me
+	}
+	if other.QPS > 0 {
+		me.QPS = other.QPS
+		me.Burst = other.Burst
+	}
+	if other.CallerQPS > 0 {
+		me.CallerQPS = other.CallerQPS
+		me.CallerBurst = other.CallerBurst
+	}
+	if other.MaxConcurrent > 0 {
+		me.MaxConcurrent = other.MaxConcurrent
+	}
+	if other.ShedInflight > 0 {
+		me.ShedInflight = other.ShedInflight
+	}
+	if len(other.ShedLatency) > 0 {
+		me.ShedLatency = other.ShedLatency
+	}
+	return me
 }
 
 // ClientInfo 客户端接口注册信息
-//<client name="serv">
-//<addr>127.0.0.1:8081</addr>
+//<client name="serv" keepalive-time="30s" max-recv-msg-size="16MB" connect-timeout="3s" compression="gzip">
 //<addr>127.0.0.1:8081</addr>
 //<addr>127.0.0.1:8081</addr>
+//<addr>unix:///tmp/sndaRpc.sock</addr>
 //<interface name="/login.loginService/login" request-type="login.loginRequest" response-type="login.loginReply"/>
 //<interface name="/login.loginService/logout" request-type="login.logoutRequest" response-type="login.logoutReply"/>
 //</client>
@@ -54,6 +242,11 @@ type ClientInfo struct {
 	Name          string           `xml:"name,attr" json:"name,omitempty"`
 	Addr          []string         `xml:"addr" json:"addr,omitempty"`
 	InterfaceList []*InterfaceInfo `xml:"interface" json:"interface_list,omitempty"`
+	ConnInfo
+	//建立连接的超时, 如 3s
+	ConnectTimeout string `xml:"connect-timeout,attr" json:"connect_timeout,omitempty"`
+	//编码方式, proto(默认)或json
+	Codec string `xml:"codec,attr" json:"codec,omitempty"`
 }
 
 // RedisInfo redis配置信息
@@ -86,6 +279,96 @@ type MySQLInfo struct {
 type HTTPGateWayInfo struct {
 	Name   string `xml:"name,attr" json:"name,omitempty"`
 	Method string `xml:"method,attr" json:"method,omitempty"`
+	//Verb 配置了http method时name是路径模板, 如 GET /users/{userName}/session
+	Verb string `xml:"verb,attr" json:"verb,omitempty"`
+	//Body *: body是整个入参; 字段路径: body是该字段; 空: 没有body
+	Body string `xml:"body,attr" json:"body,omitempty"`
+	//CORS 跨域策略名, 对应<cors>的name, 为空时使用name为default的策略
+	CORS string `xml:"cors,attr" json:"cors,omitempty"`
+	//MaxBody 最大请求body, 如 10MB, 为空时使用<security>的max-body
+	MaxBody string `xml:"max-body,attr" json:"max_body,omitempty"`
+	//Stream 把流式接口桥接成sse或websocket, 为空时是普通接口
+	Stream string `xml:"stream,attr" json:"stream,omitempty"`
+	//MaxFile multipart/form-data上传的每个文件的最大大小, 如 2MB, 为空时只受max-body限制
+	MaxFile string `xml:"max-file,attr" json:"max_file,omitempty"`
+	//Auth 认证方式, 对应<auth>的name, 多个用逗号分隔, 任意一个通过即可
+	Auth string `xml:"auth,attr" json:"auth,omitempty"`
+	//RateLimitList 限流规则, 全部满足才放行
+	RateLimitList []*RateLimitInfo `xml:"rate-limit" json:"rate_limit,omitempty"`
+}
+
+// RateLimitInfo http网关限流规则
+//<rate-limit by="ip" limit="100" window="1m" redis="redis1"/>
+type RateLimitInfo struct {
+	//By 限流维度: route(默认, 路由的全部请求), ip, key(认证通过的调用方, 没有认证时按ip)
+	By    string `xml:"by,attr" json:"by,omitempty"`
+	Limit string `xml:"limit,attr" json:"limit,omitempty"`
+	//Window 滑动窗口的长度, 如 1s, 1m, 默认1s
+	Window string `xml:"window,attr" json:"window,omitempty"`
+	//Redis 对应<redis>的name, 多个网关实例共享计数; 为空时在每个实例的内存中计数
+	Redis string `xml:"redis,attr" json:"redis,omitempty"`
+}
+
+// HTTPJSONInfo http网关json编解码配置
+type HTTPJSONInfo struct {
+	//Naming 响应字段命名, camelCase(默认)或original
+	Naming string `xml:"naming,attr" json:"naming,omitempty"`
+	//EmitDefaults 响应中是否输出默认值字段, 默认false
+	EmitDefaults string `xml:"emit-defaults,attr" json:"emit_defaults,omitempty"`
+	//Envelope 成功的响应是否包装成 {"code":0,"data":响应}, 默认false
+	Envelope string `xml:"envelope,attr" json:"envelope,omitempty"`
+}
+
+// HTTPDocsInfo http网关OpenAPI文档配置, 文档在/openapi.json
+type HTTPDocsInfo struct {
+	Title   string `xml:"title,attr" json:"title,omitempty"`
+	Version string `xml:"version,attr" json:"version,omitempty"`
+	//UI 文档页面的路径, 如 /docs, 为空时不提供页面
+	UI string `xml:"ui,attr" json:"ui,omitempty"`
+	//Enabled 为false时不提供文档和页面, 默认提供
+	Enabled string `xml:"enabled,attr" json:"enabled,omitempty"`
+	//Auth 访问文档和页面的认证方式, 对应<auth>的name, 多个用逗号分隔, 为空时不认证
+	Auth string `xml:"auth,attr" json:"auth,omitempty"`
+}
+
+//IsEnabled 没有配置enabled时为true
+func (me *HTTPDocsInfo) IsEnabled() (bool, error) {
+	if len(me.Enabled) == 0 {
+		return true, nil
+	}
+	return strconv.ParseBool(me.Enabled)
+}
+
+// CORSInfo http网关跨域策略
+type CORSInfo struct {
+	//Name 策略名, 为空时同default, 用于没有单独配置的路由
+	Name string `xml:"name,attr" json:"name,omitempty"`
+	//Origins 允许的Origin, 逗号分隔, *表示任意, *.example.com表示子域名
+	Origins       string `xml:"origins,attr" json:"origins,omitempty"`
+	Methods       string `xml:"methods,attr" json:"methods,omitempty"`
+	Headers       string `xml:"headers,attr" json:"headers,omitempty"`
+	ExposeHeaders string `xml:"expose-headers,attr" json:"expose_headers,omitempty"`
+	Credentials   string `xml:"credentials,attr" json:"credentials,omitempty"`
+	//MaxAge 预检结果的缓存时间, 如 10m
+	MaxAge string `xml:"max-age,attr" json:"max_age,omitempty"`
+}
+
+// HeaderInfo http响应头
+type HeaderInfo struct {
+	Name  string `xml:"name,attr" json:"name,omitempty"`
+	Value string `xml:"value,attr" json:"value,omitempty"`
+}
+
+// HTTPSecurityInfo http网关安全配置
+type HTTPSecurityInfo struct {
+	//MaxBody 默认的最大请求body, 如 4MB, 0表示不限制
+	MaxBody string `xml:"max-body,attr" json:"max_body,omitempty"`
+	//Headers 每个响应都带的响应头, value为空时去掉默认的响应头
+	Headers []*HeaderInfo `xml:"header" json:"headers,omitempty"`
+	//MultipartMemory 上传文件时在内存中保留的最大大小, 超过的写入临时文件, 默认1MB
+	MultipartMemory string `xml:"multipart-memory,attr" json:"multipart_memory,omitempty"`
+	//RequestID 读取和返回请求id的header, 默认X-Request-ID
+	RequestID string `xml:"request-id,attr" json:"request_id,omitempty"`
 }
 
 // AppXMLConf xml配置信息
@@ -97,6 +380,13 @@ type AppXMLConf struct {
 	RedisList       []*RedisInfo       `xml:"redis" json:"redis_list,omitempty"`
 	MySQLList       []*MySQLInfo       `xml:"mysql" json:"my_sql_list,omitempty"`
 	HTTPGateWayList []*HTTPGateWayInfo `xml:"http>interface" json:"http_gate_way_list,omitempty"`
+	HTTPProtoList   []string           `xml:"http>proto" json:"http_proto_list,omitempty"`
+	HTTPJSON        *HTTPJSONInfo      `xml:"http>json" json:"http_json,omitempty"`
+	HTTPDocs        *HTTPDocsInfo      `xml:"http>docs" json:"http_docs,omitempty"`
+	HTTPCORSList    []*CORSInfo        `xml:"http>cors" json:"http_cors_list,omitempty"`
+	HTTPSecurity    *HTTPSecurityInfo  `xml:"http>security" json:"http_security,omitempty"`
+	AuthList        []*AuthInfo        `xml:"auth" json:"auth_list,omitempty"`
+	GRPCServer      *GRPCServerInfo    `xml:"server" json:"grpc_server,omitempty"`
 }
 
 func (me *AppXMLConf) merge(other *AppXMLConf) {
@@ -128,6 +418,29 @@ func (me *AppXMLConf) merge(other *AppXMLConf) {
 			me.HTTPGateWayList = append(me.HTTPGateWayList, list)
 		}
 	}
+	if len(other.HTTPProtoList) > 0 {
+		me.HTTPProtoList = append(me.HTTPProtoList, other.HTTPProtoList...)
+	}
+	if other.HTTPJSON != nil {
+		me.HTTPJSON = other.HTTPJSON
+	}
+	if other.HTTPDocs != nil {
+		me.HTTPDocs = other.HTTPDocs
+	}
+	if len(other.HTTPCORSList) > 0 {
+		me.HTTPCORSList = append(me.HTTPCORSList, other.HTTPCORSList...)
+	}
+	if other.HTTPSecurity != nil {
+		me.HTTPSecurity = other.HTTPSecurity
+	}
+	if len(other.AuthList) > 0 {
+		for _, authInfo := range other.AuthList {
+			me.AuthList = append(me.AuthList, authInfo)
+		}
+	}
+	if other.GRPCServer != nil {
+		me.GRPCServer = other.GRPCServer
+	}
 }
 
 // LoadXMLConfig 加载xml配置
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"strings"
+)
+
+const (
+	//UNIX_PREFIX unix domain socket地址的前缀, 如 unix:///var/run/sndaRpc.sock
+	UNIX_PREFIX = "unix://"
+	//TCP_PREFIX 可选的tcp地址前缀, 如 tcp://127.0.0.1:8081
+	TCP_PREFIX = "tcp://"
+)
+
+//ParseAddr 解析监听或连接地址, 返回net.Listen/net.Dial用的network和address
+//unix:///tmp/a.sock => unix, /tmp/a.sock
+//tcp://:8081, :8081 => tcp, :8081
+func ParseAddr(addr string) (string, string) {
+	addr = strings.TrimSpace(addr)
+	if strings.HasPrefix(addr, UNIX_PREFIX) {
+		return "unix", addr[len(UNIX_PREFIX):]
+	}
+	return "tcp", strings.TrimPrefix(addr, TCP_PREFIX)
+}
+
+//SplitAddrs 拆分逗号分隔的地址列表, 忽略空地址
+func SplitAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 func Int(val interface{}, def int) int {
@@ -27,3 +28,25 @@ func String(val interface{}, def string) string {
 	return fmt.Sprint(val)
 	//return def
 }
+
+//ParseSize 解析大小, 支持KB, MB, GB后缀(大小写均可, B可省略), 没有后缀时为字节数
+func ParseSize(size string) (int, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	unit := 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,86 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/golang/protobuf/descriptor"
+	"github.com/golang/protobuf/proto"
+	protobuf "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+//E_Rules 字段上的校验规则option, 定义见validate.proto:
+//string userName = 1 [(sndarpc.rules) = "required,len=1:32"];
+var E_Rules = &proto.ExtensionDesc{
+	ExtendedType:  (*protobuf.FieldOptions)(nil),
+	ExtensionType: (*string)(nil),
+	Field:         51001,
+	Name:          "sndarpc.rules",
+	Tag:           "bytes,51001,opt,name=rules",
+	Filename:      "validate.proto",
+}
+
+//optionResult 一个message类型的解析结果
+type optionResult struct {
+	rules []*Rule
+	err   error
+}
+
+var (
+	optionMu    sync.RWMutex
+	optionRules map[reflect.Type]*optionResult //<message类型, 从proto option中解析出的规则>
+)
+
+func init() {
+	optionRules = make(map[reflect.Type]*optionResult)
+	proto.RegisterExtension(E_Rules)
+}
+
+//CheckRules 检查message字段option中的规则是否合法, tp为message的指针类型, 注册服务时调用
+func CheckRules(tp reflect.Type) error {
+	_, err := messageRules(tp)
+	return err
+}
+
+//messageRules 读取message描述中字段上的(sndarpc.rules), 按类型缓存.
+//规则写错时返回错误, 不能忽略, 否则该字段不再校验
+func messageRules(tp reflect.Type) ([]*Rule, error) {
+	optionMu.RLock()
+	result, ok := optionRules[tp]
+	optionMu.RUnlock()
+	if ok {
+		return result.rules, result.err
+	}
+	result = new(optionResult)
+	if msg, ok := reflect.Zero(tp).Interface().(descriptor.Message); ok && tp.Kind() == reflect.Ptr {
+		_, desc := descriptor.ForMessage(msg)
+		for _, field := range desc.GetField() {
+			if field.GetOptions() == nil || !proto.HasExtension(field.GetOptions(), E_Rules) {
+				continue
+			}
+			v, err := proto.GetExtension(field.GetOptions(), E_Rules)
+			if err != nil {
+				result.err = fmt.Errorf("%s field %s: %s", desc.GetName(), field.GetName(), err)
+				break
+			}
+			s, ok := v.(*string)
+			if !ok {
+				continue
+			}
+			rule, err := ParseRules(field.GetName(), *s)
+			if err != nil {
+				result.err = fmt.Errorf("%s: %s", desc.GetName(), err)
+				break
+			}
+			result.rules = append(result.rules, rule)
+		}
+	}
+	if result.err != nil {
+		result.rules = nil
+	}
+	optionMu.Lock()
+	optionRules[tp] = result
+	optionMu.Unlock()
+	return result.rules, result.err
+}
@@ -0,0 +1,275 @@
+package validate
+
+import (
+	"fmt"
+	"local/sndaRpc/util"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//Rule 一个字段的校验规则
+type Rule struct {
+	//proto字段名, 嵌套字段用.分隔
+	Field    string
+	Required bool
+	MinLen   *int
+	MaxLen   *int
+	Min      *float64
+	Max      *float64
+	Regex    *regexp.Regexp
+	Enum     []string
+}
+
+//NewRule 通过xml配置创建规则, 错误信息中带上出错的属性名
+func NewRule(info *util.FieldRule) (*Rule, error) {
+	if len(info.Name) == 0 {
+		return nil, fmt.Errorf("field name required")
+	}
+	rule := &Rule{Field: info.Name, Required: info.Required}
+	var err error
+	if rule.MinLen, err = parseInt(info.MinLen); err != nil {
+		return nil, fmt.Errorf("field %s: invalid min-len %s", info.Name, info.MinLen)
+	}
+	if rule.MaxLen, err = parseInt(info.MaxLen); err != nil {
+		return nil, fmt.Errorf("field %s: invalid max-len %s", info.Name, info.MaxLen)
+	}
+	if rule.Min, err = parseFloat(info.Min); err != nil {
+		return nil, fmt.Errorf("field %s: invalid min %s", info.Name, info.Min)
+	}
+	if rule.Max, err = parseFloat(info.Max); err != nil {
+		return nil, fmt.Errorf("field %s: invalid max %s", info.Name, info.Max)
+	}
+	if len(info.Regex) > 0 {
+		if rule.Regex, err = regexp.Compile(info.Regex); err != nil {
+			return nil, fmt.Errorf("field %s: invalid regex %s", info.Name, info.Regex)
+		}
+	}
+	if len(info.Enum) > 0 {
+		for _, v := range strings.Split(info.Enum, ",") {
+			rule.Enum = append(rule.Enum, strings.TrimSpace(v))
+		}
+	}
+	return rule, nil
+}
+
+//ParseRules 解析proto字段option中的规则串, 如 "required,len=1:32,range=0:100,enum=a|b,regex=^[a-z]+$".
+//regex会吃掉后面的全部内容, 所以要放在最后
+func ParseRules(field, s string) (*Rule, error) {
+	info := &util.FieldRule{Name: field}
+	parts := strings.Split(s, ",")
+	for i := 0; i < len(parts); i++ {
+		part := strings.TrimSpace(parts[i])
+		if len(part) == 0 {
+			continue
+		}
+		key, value := part, ""
+		if idx := strings.Index(part, "="); idx > 0 {
+			key, value = part[:idx], part[idx+1:]
+		}
+		switch key {
+		case "required":
+			info.Required = true
+		case "len":
+			info.MinLen, info.MaxLen = splitRange(value)
+		case "range":
+			info.Min, info.Max = splitRange(value)
+		case "enum":
+			info.Enum = strings.Replace(value, "|", ",", -1)
+		case "regex":
+			info.Regex = strings.Join(append([]string{value}, parts[i+1:]...), ",")
+			i = len(parts)
+		default:
+			return nil, fmt.Errorf("field %s: unknown rule %s", field, key)
+		}
+	}
+	return NewRule(info)
+}
+
+//"1:32"拆成1和32, 任意一边可以为空
+func splitRange(s string) (string, string) {
+	idx := strings.Index(s, ":")
+	if idx < 0 {
+		return s, s
+	}
+	return s[:idx], s[idx+1:]
+}
+
+func parseInt(s string) (*int, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func parseFloat(s string) (*float64, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+//check 校验msg中的字段, 通过时返回空串.
+//字段为零值且不是required时不做其他校验(proto3中零值等同于没传)
+func (me *Rule) check(msg reflect.Value) string {
+	value, found := fieldByPath(msg, me.Field)
+	if !found || isZero(value) {
+		if me.Required {
+			return "is required"
+		}
+		return ""
+	}
+	if value.Kind() == reflect.Ptr {
+		return ""
+	}
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() != reflect.Uint8 {
+		if desc := me.checkLen(value.Len()); len(desc) > 0 {
+			return desc
+		}
+		for i := 0; i < value.Len(); i++ {
+			if desc := me.checkValue(value.Index(i)); len(desc) > 0 {
+				return fmt.Sprintf("[%d] %s", i, desc)
+			}
+		}
+		return ""
+	}
+	return me.checkValue(value)
+}
+
+func (me *Rule) checkValue(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		s := value.String()
+		if desc := me.checkLen(utf8.RuneCountInString(s)); len(desc) > 0 {
+			return desc
+		}
+		if me.Regex != nil && !me.Regex.MatchString(s) {
+			return "does not match " + me.Regex.String()
+		}
+	case reflect.Slice:
+		if desc := me.checkLen(value.Len()); len(desc) > 0 {
+			return desc
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if desc := me.checkRange(float64(value.Int())); len(desc) > 0 {
+			return desc
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if desc := me.checkRange(float64(value.Uint())); len(desc) > 0 {
+			return desc
+		}
+	case reflect.Float32, reflect.Float64:
+		if desc := me.checkRange(value.Float()); len(desc) > 0 {
+			return desc
+		}
+	}
+	if len(me.Enum) > 0 && !me.inEnum(value) {
+		return "must be one of " + strings.Join(me.Enum, ",")
+	}
+	return ""
+}
+
+func (me *Rule) checkLen(n int) string {
+	if me.MinLen != nil && n < *me.MinLen {
+		return fmt.Sprintf("length must be at least %d", *me.MinLen)
+	}
+	if me.MaxLen != nil && n > *me.MaxLen {
+		return fmt.Sprintf("length must be at most %d", *me.MaxLen)
+	}
+	return ""
+}
+
+func (me *Rule) checkRange(f float64) string {
+	if me.Min != nil && f < *me.Min {
+		return fmt.Sprintf("must be >= %v", *me.Min)
+	}
+	if me.Max != nil && f > *me.Max {
+		return fmt.Sprintf("must be <= %v", *me.Max)
+	}
+	return ""
+}
+
+//inEnum proto的enum类型同时支持名字和数字
+func (me *Rule) inEnum(value reflect.Value) bool {
+	candidates := []string{fmt.Sprint(value.Interface())}
+	if value.Kind() == reflect.Int32 {
+		candidates = append(candidates, strconv.FormatInt(value.Int(), 10))
+	}
+	for _, v := range me.Enum {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//fieldByPath 按proto字段名查找字段, 中间的message为nil时返回false
+func fieldByPath(msg reflect.Value, path string) (reflect.Value, bool) {
+	value := msg
+	for _, name := range strings.Split(path, ".") {
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return reflect.Value{}, false
+			}
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		idx := fieldIndex(value.Type(), name)
+		if idx < 0 {
+			return reflect.Value{}, false
+		}
+		value = value.Field(idx)
+	}
+	return value, true
+}
+
+//fieldIndex 按protobuf tag中的name, json名或go字段名查找
+func fieldIndex(tp reflect.Type, name string) int {
+	for i := 0; i < tp.NumField(); i++ {
+		field := tp.Field(i)
+		if field.Name == name {
+			return i
+		}
+		for _, kv := range strings.Split(field.Tag.Get("protobuf"), ",") {
+			if kv == "name="+name || kv == "json="+name {
+				return i
+			}
+		}
+		if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func isZero(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package validate
+
+import (
+	"fmt"
+	"local/sndaRpc/util"
+	"reflect"
+	"strings"
+	"sync"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rulesMu     sync.RWMutex
+	methodRules map[string][]*Rule //<完整接口名, xml中配置的规则>
+)
+
+func init() {
+	methodRules = make(map[string][]*Rule)
+}
+
+//FieldViolation 一个字段的校验错误
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+//Error 校验失败, 包含所有不合法的字段
+type Error struct {
+	Violations []*FieldViolation
+}
+
+func (me *Error) Error() string {
+	msgs := make([]string, 0, len(me.Violations))
+	for _, v := range me.Violations {
+		msgs = append(msgs, v.Field+" "+v.Description)
+	}
+	return "invalid argument: " + strings.Join(msgs, "; ")
+}
+
+//Status 转成InvalidArgument, 字段错误放在BadRequest details里
+func (me *Error) Status() *status.Status {
+	st := status.New(codes.InvalidArgument, me.Error())
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range me.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+	if detailed, err := st.WithDetails(badRequest); err == nil {
+		return detailed
+	}
+	return st
+}
+
+//FromError 从*Error或带BadRequest details的grpc status中取出字段错误
+func FromError(err error) (*Error, bool) {
+	if err == nil {
+		return nil, false
+	}
+	if verr, ok := err.(*Error); ok {
+		return verr, true
+	}
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.InvalidArgument {
+		return nil, false
+	}
+	verr := new(Error)
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range badRequest.FieldViolations {
+				verr.Violations = append(verr.Violations, &FieldViolation{Field: v.Field, Description: v.Description})
+			}
+		}
+	}
+	return verr, len(verr.Violations) > 0
+}
+
+//Register 设置接口的校验规则, 会替换之前的规则
+//fullMethod: 完整接口名, 如 /login.loginService/login
+func Register(fullMethod string, rules []*Rule) {
+	rulesMu.Lock()
+	methodRules[fullMethod] = rules
+	rulesMu.Unlock()
+}
+
+//ReplaceAll 用rulesByMethod替换全部xml中配置的规则, 没有出现的接口不再有xml规则, proto字段option中的规则不变
+func ReplaceAll(rulesByMethod map[string][]*Rule) {
+	rules := make(map[string][]*Rule, len(rulesByMethod))
+	for fullMethod, list := range rulesByMethod {
+		rules[fullMethod] = list
+	}
+	rulesMu.Lock()
+	methodRules = rules
+	rulesMu.Unlock()
+}
+
+//RegisterByConfig 加载<service><method><validate>中的规则, 有规则不合法时一条都不加载
+func RegisterByConfig(serverInfoList []*util.ServerInfo) error {
+	rulesByMethod, err := RulesByConfig(serverInfoList)
+	if err != nil {
+		return err
+	}
+	for fullMethod, rules := range rulesByMethod {
+		Register(fullMethod, rules)
+	}
+	return nil
+}
+
+//RulesByConfig 解析<service><method><validate>中的规则但不加载, 返回<完整接口名, 规则>
+func RulesByConfig(serverInfoList []*util.ServerInfo) (map[string][]*Rule, error) {
+	rulesByMethod := make(map[string][]*Rule)
+	for _, serverInfo := range serverInfoList {
+		for _, methodInfo := range serverInfo.MethodList {
+			if methodInfo.Validate == nil {
+				continue
+			}
+			var rules []*Rule
+			for _, fieldRule := range methodInfo.Validate.FieldList {
+				rule, err := NewRule(fieldRule)
+				if err != nil {
+					return nil, fmt.Errorf("service %s method %s validate: %s", serverInfo.Name, methodInfo.Name, err)
+				}
+				rules = append(rules, rule)
+			}
+			rulesByMethod[serverInfo.Name+"/"+methodInfo.Name] = rules
+		}
+	}
+	return rulesByMethod, nil
+}
+
+//Validate 按proto字段option和xml中的规则校验入参, 不合法时返回*Error, option中的规则写错时返回Internal
+func Validate(fullMethod string, msg interface{}) error {
+	value := reflect.ValueOf(msg)
+	if !value.IsValid() {
+		return nil
+	}
+	rules, err := messageRules(value.Type())
+	if err != nil {
+		return status.Errorf(codes.Internal, "invalid validate rules: %s", err)
+	}
+	rulesMu.RLock()
+	if list := methodRules[fullMethod]; len(list) > 0 {
+		rules = append(append([]*Rule{}, rules...), list...)
+	}
+	rulesMu.RUnlock()
+	if len(rules) == 0 {
+		return nil
+	}
+	verr := new(Error)
+	for _, rule := range rules {
+		if desc := rule.check(value); len(desc) > 0 {
+			verr.Violations = append(verr.Violations, &FieldViolation{Field: rule.Field, Description: desc})
+		}
+	}
+	if len(verr.Violations) > 0 {
+		return verr
+	}
+	return nil
+}
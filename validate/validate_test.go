@@ -0,0 +1,197 @@
+package validate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"local/sndaRpc/pb/common"
+	"local/sndaRpc/pb/login"
+	"local/sndaRpc/util"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	protobuf "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateByConfig(t *testing.T) {
+	err := RegisterByConfig([]*util.ServerInfo{{
+		Name: "/login.loginService",
+		MethodList: []*util.MethodInfo{{
+			Name: "login",
+			Validate: &util.ValidateInfo{FieldList: []*util.FieldRule{
+				{Name: "userName", Required: true, MaxLen: "8", Regex: "^[a-z]+$"},
+				{Name: "password", MinLen: "3"},
+			}},
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	method := "/login.loginService/login"
+	if err := Validate(method, &login.LoginRequest{UserName: "tommy"}); err != nil {
+		t.Errorf("valid request rejected: %v", err)
+	}
+	err = Validate(method, &login.LoginRequest{Password: "12"})
+	verr, ok := err.(*Error)
+	if !ok || len(verr.Violations) != 2 {
+		t.Fatalf("expect 2 violations, got %v", err)
+	}
+	if verr.Violations[0].Field != "userName" || verr.Violations[1].Field != "password" {
+		t.Errorf("unexpected violations %+v %+v", verr.Violations[0], verr.Violations[1])
+	}
+	if err := Validate(method, &login.LoginRequest{UserName: "Tommy123"}); err == nil {
+		t.Error("regex should be checked")
+	}
+	if err := Validate("/login.loginService/logout", &login.LogoutRequest{}); err != nil {
+		t.Errorf("method without rules should pass: %v", err)
+	}
+
+	//有不合法的规则时一条都不加载
+	err = RegisterByConfig([]*util.ServerInfo{{
+		Name: "/login.loginService",
+		MethodList: []*util.MethodInfo{
+			{Name: "login", Validate: &util.ValidateInfo{}},
+			{Name: "logout", Validate: &util.ValidateInfo{FieldList: []*util.FieldRule{{Name: "sessionId", MinLen: "x"}}}},
+		},
+	}})
+	if err == nil {
+		t.Error("invalid rule should fail")
+	}
+	if err := Validate(method, &login.LoginRequest{Password: "12"}); err == nil {
+		t.Error("rules should be kept when loading fails")
+	}
+
+	//替换后删除了<validate>的接口不再校验
+	rulesByMethod, err := RulesByConfig([]*util.ServerInfo{{
+		Name:       "/login.loginService",
+		MethodList: []*util.MethodInfo{{Name: "logout", Validate: &util.ValidateInfo{FieldList: []*util.FieldRule{{Name: "sessionId", Required: true}}}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ReplaceAll(rulesByMethod)
+	if err := Validate(method, &login.LoginRequest{Password: "12"}); err != nil {
+		t.Errorf("removed rules should not be checked: %v", err)
+	}
+	if err := Validate("/login.loginService/logout", &login.LogoutRequest{}); err == nil {
+		t.Error("replaced rules should be checked")
+	}
+	ReplaceAll(nil)
+}
+
+func TestParseRules(t *testing.T) {
+	rule, err := ParseRules("srcCode", "required,range=1:10,enum=1|2|3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Register("/common.commonService/appInfo", []*Rule{rule})
+	if err := Validate("/common.commonService/appInfo", &common.AppInfoRequest{SrcCode: 2}); err != nil {
+		t.Errorf("valid request rejected: %v", err)
+	}
+	for _, code := range []int32{0, 4, 11} {
+		if err := Validate("/common.commonService/appInfo", &common.AppInfoRequest{SrcCode: code}); err == nil {
+			t.Errorf("srcCode %d should be rejected", code)
+		}
+	}
+	rule, err = ParseRules("userName", "len=1:,regex=^[a-z]{1,3},[0-9]$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Regex.String() != "^[a-z]{1,3},[0-9]$" || rule.MaxLen != nil || *rule.MinLen != 1 {
+		t.Errorf("unexpected rule %+v", rule)
+	}
+	if _, err := ParseRules("userName", "size=1"); err == nil {
+		t.Error("unknown rule should fail")
+	}
+	if _, err := NewRule(&util.FieldRule{Name: "userName", MaxLen: "x"}); err == nil {
+		t.Error("invalid max-len should fail")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	verr := &Error{Violations: []*FieldViolation{{Field: "userName", Description: "is required"}}}
+	err := verr.Status().Err()
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("unexpected code %v", status.Code(err))
+	}
+	back, ok := FromError(err)
+	if !ok || len(back.Violations) != 1 || back.Violations[0].Field != "userName" {
+		t.Errorf("details lost: %+v", back)
+	}
+}
+
+// optionDescriptor 字段上带(sndarpc.rules)的message描述, 第一个message的规则合法, 第二个写错了
+var optionDescriptor = func() []byte {
+	message := func(name, rules string) *protobuf.DescriptorProto {
+		options := &protobuf.FieldOptions{}
+		if err := proto.SetExtension(options, E_Rules, proto.String(rules)); err != nil {
+			panic(err)
+		}
+		return &protobuf.DescriptorProto{
+			Name: proto.String(name),
+			Field: []*protobuf.FieldDescriptorProto{{
+				Name:     proto.String("userName"),
+				JsonName: proto.String("userName"),
+				Number:   proto.Int32(1),
+				Label:    protobuf.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     protobuf.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Options:  options,
+			}},
+		}
+	}
+	fd := &protobuf.FileDescriptorProto{
+		Name:        proto.String("validate_option_test.proto"),
+		Package:     proto.String("validatetest"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*protobuf.DescriptorProto{message("goodRequest", "required,len=1:8"), message("badRequest", "len=x")},
+	}
+	b, err := proto.Marshal(fd)
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}()
+
+type goodRequest struct {
+	UserName string `protobuf:"bytes,1,opt,name=userName,proto3" json:"userName,omitempty"`
+}
+
+func (m *goodRequest) Reset()                    { *m = goodRequest{} }
+func (m *goodRequest) String() string            { return proto.CompactTextString(m) }
+func (*goodRequest) ProtoMessage()               {}
+func (*goodRequest) Descriptor() ([]byte, []int) { return optionDescriptor, []int{0} }
+
+type badRequest struct {
+	UserName string `protobuf:"bytes,1,opt,name=userName,proto3" json:"userName,omitempty"`
+}
+
+func (m *badRequest) Reset()                    { *m = badRequest{} }
+func (m *badRequest) String() string            { return proto.CompactTextString(m) }
+func (*badRequest) ProtoMessage()               {}
+func (*badRequest) Descriptor() ([]byte, []int) { return optionDescriptor, []int{1} }
+
+func TestOptionRules(t *testing.T) {
+	if err := CheckRules(reflect.TypeOf(&goodRequest{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate("/validatetest.service/good", &goodRequest{}); err == nil {
+		t.Error("option rules should be checked")
+	}
+	if err := Validate("/validatetest.service/good", &goodRequest{UserName: "tommy"}); err != nil {
+		t.Errorf("valid request rejected: %v", err)
+	}
+	//option中的规则写错时不能忽略
+	if err := CheckRules(reflect.TypeOf(&badRequest{})); err == nil {
+		t.Error("invalid option rules should fail")
+	}
+	if err := Validate("/validatetest.service/bad", &badRequest{UserName: "tommy"}); status.Code(err) != codes.Internal {
+		t.Errorf("expect Internal, got %v", err)
+	}
+}